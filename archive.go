@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/suapapa/mqvision/internal/concierge"
+	"github.com/suapapa/mqvision/internal/imagestore"
+	"github.com/suapapa/mqvision/internal/thumbnail"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Archive store types.
+const (
+	storeConcierge = "concierge"
+	storeFS        = "fs"
+	storeS3        = "s3"
+)
+
+// pruneInterval is how often an fs archive drops expired images.
+const pruneInterval = time.Hour
+
+// Derivative sizes, as the longer side in pixels.
+const (
+	thumbnailSide = 320
+	cropSide      = 640
+)
+
+// ArchiveConfig picks the image store behind the archive sink and /api/images.
+type ArchiveConfig struct {
+	// Store is concierge, fs or s3. It defaults to concierge when
+	// CONCIERGE_ADDR and CONCIERGE_TOKEN are set; empty archives nothing.
+	Store string `yaml:"store"`
+	// Dir holds the images of an fs store, kept for Retention after they were
+	// last stored (zero keeps them forever).
+	Dir       string        `yaml:"dir"`
+	Retention time.Duration `yaml:"retention"`
+	// S3 addresses a bucket path-style. ${VAR} in the keys is expanded from the
+	// environment; retention is left to the bucket's lifecycle rules.
+	S3 struct {
+		Endpoint  string `yaml:"endpoint"`
+		Region    string `yaml:"region"`
+		Bucket    string `yaml:"bucket"`
+		Prefix    string `yaml:"prefix"`
+		AccessKey string `yaml:"access_key"`
+		SecretKey string `yaml:"secret_key"`
+	} `yaml:"s3"`
+}
+
+func (a ArchiveConfig) validate(name string, c *Config) error {
+	switch a.Store {
+	case "":
+	case storeConcierge:
+		if strings.TrimSpace(c.Concierge.Addr) == "" || strings.TrimSpace(c.Concierge.Token) == "" {
+			return fmt.Errorf("%s: concierge store needs CONCIERGE_ADDR and CONCIERGE_TOKEN", name)
+		}
+	case storeFS:
+		if strings.TrimSpace(a.Dir) == "" {
+			return fmt.Errorf("%s.dir is required", name)
+		}
+	case storeS3:
+		for _, f := range []struct{ field, value string }{
+			{"endpoint", a.S3.Endpoint},
+			{"bucket", a.S3.Bucket},
+			{"access_key", a.S3.AccessKey},
+			{"secret_key", a.S3.SecretKey},
+		} {
+			if strings.TrimSpace(f.value) == "" {
+				return fmt.Errorf("%s.s3.%s is required", name, f.field)
+			}
+		}
+	default:
+		return fmt.Errorf("%s.store must be one of concierge, fs, s3", name)
+	}
+	if a.Retention < 0 {
+		return fmt.Errorf("%s.retention must not be negative", name)
+	}
+	return nil
+}
+
+// newImageStore builds the configured store, or nil when nothing is archived.
+func newImageStore(a ArchiveConfig) imagestore.Store {
+	switch a.Store {
+	case storeConcierge:
+		return concierge.NewClient(config.Concierge.Addr, config.Concierge.Token,
+			concierge.WithTTL(config.Concierge.TTL),
+			concierge.WithHTTPClient(&http.Client{Timeout: config.Concierge.Timeout}))
+	case storeFS:
+		return imagestore.FS{Dir: a.Dir, Retention: a.Retention}
+	case storeS3:
+		return imagestore.S3{
+			Endpoint:  a.S3.Endpoint,
+			Region:    a.S3.Region,
+			Bucket:    a.S3.Bucket,
+			Prefix:    a.S3.Prefix,
+			AccessKey: a.S3.AccessKey,
+			SecretKey: a.S3.SecretKey,
+		}
+	default:
+		return nil
+	}
+}
+
+// archived is what the archive sink returns for a frame.
+type archived struct {
+	URL  string
+	Took time.Duration // to store the source image
+	// derivatives delivers the URLs of the derivatives once they are stored.
+	derivatives <-chan derivatives
+}
+
+// derivatives are the URLs of the small copies of an archived image.
+type derivatives struct {
+	ThumbnailURL string
+	CropURL      string
+}
+
+// imageURLs are where the image of a frame and its derivatives were archived.
+type imageURLs struct {
+	Source, Thumbnail, Crop string
+	Took                    time.Duration // to store the source image
+}
+
+// archiveGrace is how long a reading waits for its archived images after the
+// vision call, which usually outlasts the archive. Images archived later are
+// attached to the reading once it is stored.
+const archiveGrace = 2 * time.Second
+
+// archivedImages follows the result of the archive sink to the URLs of the
+// image and its derivatives, sent once both are stored; empty when the sink failed.
+func archivedImages(done <-chan sinkResult) <-chan imageURLs {
+	ch := make(chan imageURLs, 1)
+	go func() {
+		r := <-done
+		a, _ := r.Value.(archived)
+		u := imageURLs{Source: a.URL, Took: a.Took}
+		if r.Err == nil && a.derivatives != nil {
+			d := <-a.derivatives
+			u.Thumbnail, u.Crop = d.ThumbnailURL, d.CropURL
+		}
+		ch <- u
+	}()
+	return ch
+}
+
+// awaitImages waits up to grace for the archived images of l. Images still
+// being archived are left in l.lateImages for attachLateImages.
+func (l *Luggage) awaitImages(ctx context.Context, images <-chan imageURLs, grace time.Duration) {
+	if images == nil {
+		return
+	}
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case u := <-images:
+		l.SrcImageURL, l.ThumbnailURL, l.CropURL = u.Source, u.Thumbnail, u.Crop
+		if u.Source != "" && l.Timings != nil {
+			l.Timings.Archive = u.Took.Seconds()
+			slog.InfoContext(ctx, "Archived image", "url", u.Source)
+		}
+		return
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	slog.WarnContext(ctx, "Image not archived in time; attaching it once the reading is stored")
+	l.lateImages = images
+}
+
+// attachLateImages waits for the images of reading id that were not archived
+// in time and records them with the reading.
+func attachLateImages(ctx context.Context, s *SensorServer, id bson.ObjectID, images <-chan imageURLs) {
+	var u imageURLs
+	select {
+	case u = <-images:
+	case <-appCtx.Done():
+		return
+	}
+	if u.Source == "" {
+		// The archive sink failed and said why.
+		return
+	}
+	if err := s.SetImages(ctx, id, u); err != nil {
+		slog.ErrorContext(ctx, "Error attaching archived image", "url", u.Source, "err", err)
+		return
+	}
+	slog.InfoContext(ctx, "Attached archived image", "url", u.Source)
+}
+
+// startDerivatives archives the derivatives of f in the background within
+// timeout, so that a slow thumbnail does not hold up the source image.
+func startDerivatives(ctx context.Context, f *Frame, timeout time.Duration) <-chan derivatives {
+	ch := make(chan derivatives, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+		defer cancel()
+		var d derivatives
+		var err error
+		// A thumbnail is kept even when the crop fails.
+		d.ThumbnailURL, d.CropURL, err = archiveDerivatives(ctx, f)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error archiving derivatives", "err", err)
+		}
+		ch <- d
+	}()
+	return ch
+}
+
+// archiveDerivatives stores a thumbnail of f and, when its meter has a crop
+// region, a close-up of the meter window, and returns their URLs.
+func archiveDerivatives(ctx context.Context, f *Frame) (thumbURL, cropURL string, err error) {
+	img, err := thumbnail.Decode(f.Image)
+	if err != nil {
+		return "", "", err
+	}
+
+	thumbURL, err = archiveJPEG(ctx, thumbnail.Fit(img, thumbnailSide))
+	if err != nil {
+		return "", "", fmt.Errorf("thumbnail: %w", err)
+	}
+	if m, ok := config.meter(f.MeterID); ok && m.Crop != nil {
+		cropURL, err = archiveJPEG(ctx, thumbnail.Fit(thumbnail.Crop(img, *m.Crop), cropSide))
+		if err != nil {
+			return thumbURL, "", fmt.Errorf("crop: %w", err)
+		}
+	}
+	return thumbURL, cropURL, nil
+}
+
+func archiveJPEG(ctx context.Context, img image.Image) (string, error) {
+	b, err := thumbnail.JPEG(img)
+	if err != nil {
+		return "", err
+	}
+	id, err := imageStore.Put(ctx, bytes.NewReader(b), "image/jpeg")
+	if err != nil {
+		return "", err
+	}
+	return imageURL(id), nil
+}
+
+// runImageStore does the housekeeping of stores that need it until ctx is done.
+func runImageStore(ctx context.Context, s imagestore.Store) {
+	if fs, ok := s.(imagestore.FS); ok && fs.Retention > 0 {
+		fs.Run(ctx, pruneInterval)
+	}
+}
+
+// imageURL is the src_image_url of an archived image, absolute when PUBLIC_URL is set.
+func imageURL(id string) string {
+	return config.API.PublicURL + "/api/images/" + url.PathEscape(id)
+}
+
+// archivedImageID returns the id of the archived image at u, a src_image_url.
+func archivedImageID(u string) (string, bool) {
+	_, escaped, ok := strings.Cut(u, "/api/images/")
+	if !ok {
+		return "", false
+	}
+	id, err := url.PathUnescape(escaped)
+	return id, err == nil && id != ""
+}
+
+// getImageHandler serves an archived image. IDs never change content, so it may be cached for good.
+func getImageHandler(c *gin.Context) {
+	if imageStore == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no image archive configured"})
+		return
+	}
+
+	r, mimeType, err := imageStore.Get(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, imagestore.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error opening archived image", "id", c.Param("id"), "err", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "image archive unavailable"})
+		return
+	}
+	defer r.Close()
+
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.DataFromReader(http.StatusOK, -1, mimeType, r, nil)
+}
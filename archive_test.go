@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/suapapa/mqvision/internal/imagestore"
+	"github.com/suapapa/mqvision/internal/thumbnail"
+)
+
+// TestGetImageHandler swaps the image store, so it must not run in parallel.
+func TestGetImageHandler(t *testing.T) {
+	config = &Config{}
+	config.API.PublicURL = "https://mqvision.example.com"
+	imageStore = imagestore.FS{Dir: t.TempDir()}
+	defer func() { imageStore = nil }()
+
+	id, err := imageStore.Put(context.Background(), strings.NewReader("jpeg bytes"), "image/jpeg")
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	src := imageURL(id)
+	if want := "https://mqvision.example.com/api/images/" + id; src != want {
+		t.Errorf("imageURL = %s, want %s", src, want)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/images/:id", getImageHandler)
+
+	tests := []struct {
+		name     string
+		path     string
+		wantCode int
+	}{
+		{name: "archived", path: strings.TrimPrefix(src, config.API.PublicURL), wantCode: http.StatusOK},
+		{name: "unknown", path: "/api/images/" + strings.Repeat("0", 64) + ".jpg", wantCode: http.StatusNotFound},
+		{name: "traversal", path: "/api/images/..%2F..%2Fprompt.yaml", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d", w.Code, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusOK {
+				if w.Body.String() != "jpeg bytes" || w.Header().Get("Content-Type") != "image/jpeg" {
+					t.Errorf("served %q as %s", w.Body, w.Header().Get("Content-Type"))
+				}
+			}
+		})
+	}
+}
+
+// TestArchiveDerivatives swaps the image store, so it must not run in parallel.
+func TestArchiveDerivatives(t *testing.T) {
+	jpg, err := os.ReadFile(filepath.Join("sample", "ok.jpg"))
+	if err != nil {
+		t.Fatalf("read sample: %v", err)
+	}
+	config = &Config{Meters: []MeterConfig{
+		{ID: "gas", Crop: &thumbnail.Rect{X: 0.25, Y: 0.25, W: 0.5, H: 0.5}},
+		{ID: "water"},
+	}}
+	imageStore = imagestore.FS{Dir: t.TempDir()}
+	defer func() { imageStore = nil }()
+
+	open := func(u string) []byte {
+		t.Helper()
+		r, mimeType, err := imageStore.Get(context.Background(), strings.TrimPrefix(u, "/api/images/"))
+		if err != nil || mimeType != "image/jpeg" {
+			t.Fatalf("open %s: %v (%s)", u, err, mimeType)
+		}
+		defer r.Close()
+		b, _ := io.ReadAll(r)
+		return b
+	}
+
+	thumbURL, cropURL, err := archiveDerivatives(context.Background(), &Frame{MeterID: "gas", Image: jpg})
+	if err != nil {
+		t.Fatalf("archive derivatives: %v", err)
+	}
+	img, err := thumbnail.Decode(open(thumbURL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); max(b.Dx(), b.Dy()) > thumbnailSide {
+		t.Errorf("thumbnail is %v", b)
+	}
+	if len(open(cropURL)) == 0 {
+		t.Error("empty crop")
+	}
+
+	_, cropURL, err = archiveDerivatives(context.Background(), &Frame{MeterID: "water", Image: jpg})
+	if err != nil || cropURL != "" {
+		t.Errorf("meter without crop region: crop %q, %v", cropURL, err)
+	}
+	if _, _, err := archiveDerivatives(context.Background(), &Frame{MeterID: "gas", Image: []byte("junk")}); err == nil {
+		t.Error("undecodable image accepted")
+	}
+}
+
+// slowStore stores the first image once hold is closed (at once when it is nil)
+// and holds every later Put until ctx is done.
+type slowStore struct {
+	imagestore.FS
+	hold chan struct{}
+	puts atomic.Int32
+}
+
+func (s *slowStore) Put(ctx context.Context, r io.Reader, mimeType string) (string, error) {
+	if s.puts.Add(1) > 1 {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+	if s.hold != nil {
+		<-s.hold
+	}
+	return s.FS.Put(ctx, r, mimeType)
+}
+
+// TestArchiveSinkInBackground swaps the config and the image store, so it must not run in parallel.
+func TestArchiveSinkInBackground(t *testing.T) {
+	jpg, err := os.ReadFile(filepath.Join("sample", "ok.jpg"))
+	if err != nil {
+		t.Fatalf("read sample: %v", err)
+	}
+	savedConfig, savedStore := config, imageStore
+	t.Cleanup(func() { config, imageStore = savedConfig, savedStore })
+	config = &Config{Meters: []MeterConfig{{ID: "gas"}}}
+
+	read := Sink{Name: sinkVision, Wait: true, Consume: func(ctx context.Context, f *Frame, r io.Reader) (any, error) {
+		_, err := io.Copy(io.Discard, r)
+		return nil, err
+	}}
+	archive := func(t *testing.T, store *slowStore, grace time.Duration) (*Luggage, map[string]sinkResult) {
+		t.Helper()
+		imageStore = store
+		sinks := append([]Sink{read}, configuredSinks([]SinkConfig{{Type: sinkArchive, Timeout: 100 * time.Millisecond}})...)
+		f := &Frame{MeterID: "gas", Image: jpg, MIMEType: "image/jpeg", ReceivedAt: time.Now()}
+		results, background := fanOut(context.Background(), f, sinks)
+		l := &Luggage{Timings: &Timings{}}
+		l.awaitImages(context.Background(), archivedImages(background[sinkArchive]), grace)
+		return l, results
+	}
+
+	t.Run("slow source", func(t *testing.T) {
+		store := &slowStore{FS: imagestore.FS{Dir: t.TempDir()}, hold: make(chan struct{})}
+		start := time.Now()
+		l, results := archive(t, store, 50*time.Millisecond)
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("the reading waited %v for the archive", d)
+		}
+		if _, ok := results[sinkArchive]; ok {
+			t.Error("fanOut waited for the archive sink")
+		}
+		if l.SrcImageURL != "" || l.lateImages == nil {
+			t.Fatalf("source %q, late images %v before the store was done", l.SrcImageURL, l.lateImages)
+		}
+		close(store.hold)
+		if u := <-l.lateImages; u.Source == "" {
+			t.Error("no source URL once the store was done")
+		}
+	})
+
+	t.Run("slow derivatives", func(t *testing.T) {
+		// The derivatives time out within the grace period; the source is kept.
+		l, _ := archive(t, &slowStore{FS: imagestore.FS{Dir: t.TempDir()}}, time.Second)
+		if l.lateImages != nil || l.SrcImageURL == "" || l.ThumbnailURL != "" {
+			t.Errorf("source %q, thumbnail %q, late %v", l.SrcImageURL, l.ThumbnailURL, l.lateImages != nil)
+		}
+	})
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Scopes of an API token, each including the ones before it.
+const (
+	scopeRead  = "read"
+	scopeWrite = "write"
+	scopeAdmin = "admin"
+)
+
+var scopes = []string{scopeRead, scopeWrite, scopeAdmin}
+
+// Scopes anonymous requests get.
+const (
+	anonymousRead = "read" // read endpoints stay open, e.g. for Home Assistant
+	anonymousNone = "none"
+)
+
+// legacyTokenName names the token from API_TOKEN in the audit log.
+const legacyTokenName = "api"
+
+// Where require leaves the name of the request's token and the rank of the
+// scopes the request holds.
+const (
+	tokenNameKey = "mqvision.token"
+	tokenRankKey = "mqvision.rank"
+)
+
+// tokenCookie carries the web UI's token for what cannot send a header, such
+// as EventSource and <img>. It is only read on GET and HEAD so that a cross-site
+// form cannot write with it.
+const tokenCookie = "mqvision_token"
+
+// AuthConfig lists the API tokens and what anonymous requests may do.
+type AuthConfig struct {
+	Anonymous string        `yaml:"anonymous"`
+	Tokens    []TokenConfig `yaml:"tokens"`
+}
+
+// TokenConfig is a bearer token, kept as the hex SHA-256 of the token,
+// e.g. from `printf %s "$TOKEN" | sha256sum`.
+type TokenConfig struct {
+	Name   string   `yaml:"name"`
+	SHA256 string   `yaml:"sha256"`
+	Scopes []string `yaml:"scopes"`
+}
+
+func (c AuthConfig) validate(name string) error {
+	switch c.Anonymous {
+	case anonymousRead, anonymousNone:
+	default:
+		return fmt.Errorf("%s.anonymous must be one of read, none", name)
+	}
+	names := make(map[string]bool)
+	for i, t := range c.Tokens {
+		if strings.TrimSpace(t.Name) == "" {
+			return fmt.Errorf("%s.tokens[%d].name is required", name, i)
+		}
+		if names[t.Name] || t.Name == legacyTokenName {
+			return fmt.Errorf("%s.tokens[%d].name %q is taken", name, i, t.Name)
+		}
+		names[t.Name] = true
+		if b, err := hex.DecodeString(t.SHA256); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("%s.tokens[%d].sha256 must be 64 hex digits", name, i)
+		}
+		if len(t.Scopes) == 0 {
+			return fmt.Errorf("%s.tokens[%d].scopes is required", name, i)
+		}
+		for _, s := range t.Scopes {
+			if !slices.Contains(scopes, s) {
+				return fmt.Errorf("%s.tokens[%d].scopes must be among read, write, admin", name, i)
+			}
+		}
+	}
+	return nil
+}
+
+// apiToken is a known token: its name and the highest scope it holds.
+type apiToken struct {
+	name string
+	rank int
+}
+
+// Authenticator admits requests by the scopes of their bearer token.
+type Authenticator struct {
+	anonymous int // rank of anonymous requests; 0 admits none
+	tokens    map[[sha256.Size]byte]apiToken
+}
+
+// newAuthenticator knows the tokens of cfg and, when legacy is set, legacy
+// itself as the admin token "api".
+func newAuthenticator(cfg AuthConfig, legacy string) *Authenticator {
+	a := &Authenticator{tokens: make(map[[sha256.Size]byte]apiToken)}
+	if cfg.Anonymous == anonymousRead {
+		a.anonymous = rank(scopeRead)
+	}
+	for _, t := range cfg.Tokens {
+		var sum [sha256.Size]byte
+		hex.Decode(sum[:], []byte(t.SHA256))
+		tok := apiToken{name: t.Name}
+		for _, s := range t.Scopes {
+			tok.rank = max(tok.rank, rank(s))
+		}
+		a.tokens[sum] = tok
+	}
+	if legacy != "" {
+		a.tokens[sha256.Sum256([]byte(legacy))] = apiToken{name: legacyTokenName, rank: rank(scopeAdmin)}
+	}
+	return a
+}
+
+func rank(scope string) int {
+	return slices.Index(scopes, scope) + 1
+}
+
+// HasTokens reports whether any token is known.
+func (a *Authenticator) HasTokens() bool {
+	return len(a.tokens) > 0
+}
+
+// require admits requests whose bearer token holds scope, and anonymous ones
+// when anonymous requests may. Tokens are looked up by their hash, so the
+// lookup time tells nothing about a token.
+func (a *Authenticator) require(scope string) gin.HandlerFunc {
+	need := rank(scope)
+	return func(c *gin.Context) {
+		got, ok := requestToken(c)
+		if !ok {
+			if a.anonymous >= need {
+				c.Set(tokenRankKey, a.anonymous)
+				c.Next()
+				return
+			}
+			c.Header("WWW-Authenticate", `Bearer realm="mqvision"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing token"})
+			return
+		}
+		tok, ok := a.tokens[sha256.Sum256([]byte(got))]
+		if !ok {
+			c.Header("WWW-Authenticate", `Bearer realm="mqvision", error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+			return
+		}
+		if tok.rank < need {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("token %q lacks the %s scope", tok.name, scope)})
+			return
+		}
+		c.Set(tokenNameKey, tok.name)
+		c.Set(tokenRankKey, tok.rank)
+		c.Next()
+	}
+}
+
+// requestToken returns the bearer token of the request, or on GET and HEAD the
+// token cookie when there is no Authorization header.
+func requestToken(c *gin.Context) (string, bool) {
+	if h := c.GetHeader("Authorization"); h != "" {
+		return strings.CutPrefix(h, "Bearer ")
+	}
+	if m := c.Request.Method; m != http.MethodGet && m != http.MethodHead {
+		return "", false
+	}
+	got, err := c.Cookie(tokenCookie)
+	return got, err == nil && got != ""
+}
+
+// tokenName returns the name of the token the request was admitted with, or
+// legacyTokenName when it was anonymous.
+func tokenName(c *gin.Context) string {
+	if name := c.GetString(tokenNameKey); name != "" {
+		return name
+	}
+	return legacyTokenName
+}
+
+// hasScope reports whether the request was admitted with scope, which a
+// handler may check to answer lower scopes with less.
+func hasScope(c *gin.Context, scope string) bool {
+	return c.GetInt(tokenRankKey) >= rank(scope)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestAuthConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	hash := hashToken("t")
+	tests := []struct {
+		name    string
+		cfg     AuthConfig
+		wantErr string
+	}{
+		{"anonymous read", AuthConfig{Anonymous: anonymousRead}, ""},
+		{"tokens", AuthConfig{Anonymous: anonymousNone, Tokens: []TokenConfig{
+			{Name: "hass", SHA256: hash, Scopes: []string{scopeRead}},
+			{Name: "kim", SHA256: strings.ToUpper(hash), Scopes: []string{scopeRead, scopeWrite}},
+		}}, ""},
+		{"unknown anonymous", AuthConfig{Anonymous: "write"}, "auth.anonymous"},
+		{"no name", AuthConfig{Anonymous: anonymousRead, Tokens: []TokenConfig{{SHA256: hash, Scopes: []string{scopeRead}}}}, "name is required"},
+		{"legacy name", AuthConfig{Anonymous: anonymousRead, Tokens: []TokenConfig{{Name: "api", SHA256: hash, Scopes: []string{scopeRead}}}}, "taken"},
+		{"plain token", AuthConfig{Anonymous: anonymousRead, Tokens: []TokenConfig{{Name: "kim", SHA256: "secret", Scopes: []string{scopeRead}}}}, "64 hex digits"},
+		{"no scopes", AuthConfig{Anonymous: anonymousRead, Tokens: []TokenConfig{{Name: "kim", SHA256: hash}}}, "scopes is required"},
+		{"unknown scope", AuthConfig{Anonymous: anonymousRead, Tokens: []TokenConfig{{Name: "kim", SHA256: hash, Scopes: []string{"root"}}}}, "scopes must be"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := tt.cfg.validate("auth")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthenticatorRequire(t *testing.T) {
+	t.Parallel()
+
+	tokens := []TokenConfig{
+		{Name: "hass", SHA256: hashToken("hass-token"), Scopes: []string{scopeRead}},
+		{Name: "kim", SHA256: hashToken("kim-token"), Scopes: []string{scopeWrite}},
+	}
+	routers := map[string]*gin.Engine{}
+	for _, anonymous := range []string{anonymousRead, anonymousNone} {
+		a := newAuthenticator(AuthConfig{Anonymous: anonymous, Tokens: tokens}, "legacy-token")
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		whoami := func(c *gin.Context) { c.String(http.StatusOK, tokenName(c)) }
+		router.GET("/read", a.require(scopeRead), whoami)
+		router.GET("/write", a.require(scopeWrite), whoami)
+		router.GET("/admin", a.require(scopeAdmin), whoami)
+		routers[anonymous] = router
+	}
+
+	tests := []struct {
+		anonymous, target, token string
+		wantCode                 int
+		wantName                 string
+	}{
+		{anonymousRead, "/read", "", http.StatusOK, "api"},
+		{anonymousRead, "/write", "", http.StatusUnauthorized, ""},
+		{anonymousNone, "/read", "", http.StatusUnauthorized, ""},
+		{anonymousNone, "/read", "hass-token", http.StatusOK, "hass"},
+		{anonymousRead, "/read", "wrong", http.StatusUnauthorized, ""},
+		{anonymousRead, "/write", "hass-token", http.StatusForbidden, ""},
+		{anonymousRead, "/write", "kim-token", http.StatusOK, "kim"},
+		{anonymousNone, "/read", "kim-token", http.StatusOK, "kim"},
+		{anonymousRead, "/admin", "kim-token", http.StatusForbidden, ""},
+		{anonymousRead, "/admin", "legacy-token", http.StatusOK, "api"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.anonymous+tt.target+"/"+tt.token, func(t *testing.T) {
+			t.Parallel()
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			routers[tt.anonymous].ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantName != "" && w.Body.String() != tt.wantName {
+				t.Errorf("token name %q, want %q", w.Body.String(), tt.wantName)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+		})
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	tokens := []TokenConfig{
+		{Name: "hass", SHA256: hashToken("hass-token"), Scopes: []string{scopeRead}},
+	}
+	a := newAuthenticator(AuthConfig{Anonymous: anonymousRead, Tokens: tokens}, "legacy-token")
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/read", a.require(scopeRead), func(c *gin.Context) {
+		c.String(http.StatusOK, strconv.FormatBool(hasScope(c, scopeAdmin)))
+	})
+
+	for token, want := range map[string]string{"": "false", "hass-token": "false", "legacy-token": "true"} {
+		req := httptest.NewRequest(http.MethodGet, "/read", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if got := w.Body.String(); got != want {
+			t.Errorf("token %q: admin %s, want %s", token, got, want)
+		}
+	}
+}
+
+func TestAuthenticatorRequireCookie(t *testing.T) {
+	a := newAuthenticator(AuthConfig{Anonymous: anonymousNone}, "legacy-token")
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	whoami := func(c *gin.Context) { c.String(http.StatusOK, tokenName(c)) }
+	router.GET("/events", a.require(scopeRead), whoami)
+	router.POST("/readings", a.require(scopeWrite), whoami)
+
+	tests := []struct {
+		method, target, cookie string
+		wantCode               int
+	}{
+		{http.MethodGet, "/events", "legacy-token", http.StatusOK},
+		{http.MethodGet, "/events", "wrong", http.StatusUnauthorized},
+		{http.MethodGet, "/events", "", http.StatusUnauthorized},
+		{http.MethodPost, "/readings", "legacy-token", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: tokenCookie, Value: tt.cookie})
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tt.wantCode {
+			t.Errorf("%s %s with cookie %q: code = %d, want %d", tt.method, tt.target, tt.cookie, w.Code, tt.wantCode)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/suapapa/mqvision/internal/cron"
+	"github.com/suapapa/mqvision/internal/quality"
+)
+
+// Capture defaults.
+const (
+	defaultCaptureTimeout = 30 * time.Second
+	defaultFlashStep      = 64
+	defaultMaxFlash       = 255
+)
+
+// captureEventsKept bounds the recent capture events reported by /api/health.
+const captureEventsKept = 20
+
+// Capture event kinds.
+const (
+	captureCaptured      = "captured"
+	captureNoResponse    = "no_response"
+	captureTooDark       = "too_dark"
+	capturePublishFailed = "publish_failed"
+)
+
+var errFrameRetaken = errors.New("frame too dark, retaken with more flash")
+
+// CaptureConfig lets mqvision trigger a meter's camera on its own schedule
+// instead of relying on the camera's timer.
+type CaptureConfig struct {
+	// Schedule is a cron spec ("*/10 * * * *", "@hourly", "@every 15m"); empty disables triggering.
+	Schedule string `yaml:"schedule"`
+	// CommandTopic receives the capture commands (default <MQTT_BASE_TOPIC>/<id>/capture).
+	CommandTopic string `yaml:"command_topic"`
+	// Timeout is how long to wait for the image after a command (default 30s).
+	Timeout time.Duration `yaml:"timeout"`
+	// Flash is the flash LED level of the first attempt. A frame the quality
+	// gate finds too dark is retaken with FlashStep more, up to MaxFlash.
+	Flash     int `yaml:"flash"`
+	FlashStep int `yaml:"flash_step"`
+	MaxFlash  int `yaml:"max_flash"`
+}
+
+// captureCommand is the JSON published on a meter's command topic.
+type captureCommand struct {
+	Command string    `json:"command"`
+	Flash   int       `json:"flash"`
+	Attempt int       `json:"attempt"`
+	At      time.Time `json:"at"`
+}
+
+// CaptureEvent records the outcome of one capture attempt.
+type CaptureEvent struct {
+	MeterID string    `json:"meter_id"`
+	At      time.Time `json:"at"`
+	Event   string    `json:"event"`
+	Flash   int       `json:"flash"`
+	Attempt int       `json:"attempt"`
+	Error   string    `json:"error,omitempty"`
+}
+
+type captureMeter struct {
+	cfg      CaptureConfig
+	schedule cron.Schedule
+	waiting  chan *Frame // set while a capture waits for its image
+
+	noResponse int
+	last       *CaptureEvent
+}
+
+// CaptureScheduler publishes capture commands on each meter's schedule and
+// hands the answering frame on, retaking it with more flash when it is too dark.
+// Frames of meters with no capture outstanding pass straight through.
+type CaptureScheduler struct {
+	mu      sync.Mutex
+	meters  map[string]*captureMeter
+	events  []CaptureEvent // newest last
+	publish func(topic string, payload []byte) error
+	next    func(*Frame)
+}
+
+// NewCaptureScheduler sets up the meters with a capture schedule. publish sends
+// a command; next receives every frame that is not retaken.
+func NewCaptureScheduler(meters []MeterConfig, baseTopic string, publish func(string, []byte) error, next func(*Frame)) (*CaptureScheduler, error) {
+	s := &CaptureScheduler{
+		meters:  make(map[string]*captureMeter),
+		publish: publish,
+		next:    next,
+	}
+	for _, m := range meters {
+		cfg := m.Capture
+		if cfg.Schedule == "" {
+			continue
+		}
+		schedule, err := cron.Parse(cfg.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("meter %s: %w", m.ID, err)
+		}
+		if cfg.CommandTopic == "" {
+			cfg.CommandTopic = baseTopic + "/" + m.ID + "/capture"
+		}
+		if cfg.Timeout == 0 {
+			cfg.Timeout = defaultCaptureTimeout
+		}
+		if cfg.FlashStep == 0 {
+			cfg.FlashStep = defaultFlashStep
+		}
+		if cfg.MaxFlash == 0 {
+			cfg.MaxFlash = defaultMaxFlash
+		}
+		s.meters[m.ID] = &captureMeter{cfg: cfg, schedule: schedule}
+	}
+	return s, nil
+}
+
+// Submit delivers f to the capture waiting for it, or forwards it.
+func (s *CaptureScheduler) Submit(f *Frame) {
+	s.mu.Lock()
+	if m := s.meters[f.MeterID]; m != nil && m.waiting != nil {
+		if f.Quality == nil {
+			if r, err := quality.Analyze(f.Image); err == nil {
+				f.Quality = &r
+			}
+		}
+		// waiting has room for exactly this frame; sending under the lock lets await clear it race-free.
+		m.waiting <- f
+		m.waiting = nil
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+	s.next(f)
+}
+
+// Run triggers captures on schedule until ctx is done.
+func (s *CaptureScheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for id, m := range s.meters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runMeter(ctx, id, m)
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *CaptureScheduler) runMeter(ctx context.Context, id string, m *captureMeter) {
+	next := m.schedule.Next(time.Now())
+	for !next.IsZero() {
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.capture(ctx, id, m)
+		// Skip the slots missed while the capture ran.
+		if now := time.Now(); now.After(next) {
+			next = now
+		}
+		next = m.schedule.Next(next)
+	}
+	slog.ErrorContext(ctx, "Capture schedule has no next activation; captures stopped", "meter", id, "schedule", m.cfg.Schedule)
+}
+
+// capture commands one image, retaking it with more flash while it is too dark.
+func (s *CaptureScheduler) capture(ctx context.Context, id string, m *captureMeter) {
+	flash := m.cfg.Flash
+	for attempt := 1; ; attempt++ {
+		ev := CaptureEvent{MeterID: id, Flash: flash, Attempt: attempt}
+		waiting := make(chan *Frame, 1)
+		s.mu.Lock()
+		m.waiting = waiting
+		s.mu.Unlock()
+
+		cmd, _ := json.Marshal(captureCommand{Command: "capture", Flash: flash, Attempt: attempt, At: time.Now()})
+		if err := s.publish(m.cfg.CommandTopic, cmd); err != nil {
+			s.stopWaiting(m, waiting)
+			ev.Event, ev.Error = capturePublishFailed, err.Error()
+			s.record(m, ev)
+			return
+		}
+
+		f := s.await(ctx, m, waiting)
+		switch {
+		case f == nil && ctx.Err() != nil:
+			return
+		case f == nil:
+			ev.Event, ev.Error = captureNoResponse, fmt.Sprintf("camera did not respond within %v", m.cfg.Timeout)
+			s.record(m, ev)
+			return
+		case f.Quality != nil && f.Quality.TooDark && flash < m.cfg.MaxFlash:
+			f.settle(errFrameRetaken)
+			ev.Event = captureTooDark
+			s.record(m, ev)
+			flash = min(flash+m.cfg.FlashStep, m.cfg.MaxFlash)
+		default:
+			ev.Event = captureCaptured
+			s.record(m, ev)
+			s.next(f)
+			return
+		}
+	}
+}
+
+// await waits for the frame answering a command, or nil on timeout or shutdown.
+func (s *CaptureScheduler) await(ctx context.Context, m *captureMeter, waiting chan *Frame) *Frame {
+	timer := time.NewTimer(m.cfg.Timeout)
+	defer timer.Stop()
+
+	select {
+	case f := <-waiting:
+		return f
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+	s.stopWaiting(m, waiting)
+	// Submit sends under the lock, so a frame that beat stopWaiting is already buffered.
+	select {
+	case f := <-waiting:
+		return f
+	default:
+		return nil
+	}
+}
+
+func (s *CaptureScheduler) stopWaiting(m *captureMeter, waiting chan *Frame) {
+	s.mu.Lock()
+	if m.waiting == waiting {
+		m.waiting = nil
+	}
+	s.mu.Unlock()
+}
+
+func (s *CaptureScheduler) record(m *captureMeter, ev CaptureEvent) {
+	ev.At = time.Now()
+	if ev.Error != "" {
+		slog.Warn("Capture "+ev.Event, "meter", ev.MeterID, "attempt", ev.Attempt, "flash", ev.Flash, "err", ev.Error)
+		publisher.PublishError(ev.MeterID, errors.New(ev.Error))
+	} else {
+		slog.Info("Capture "+ev.Event, "meter", ev.MeterID, "attempt", ev.Attempt, "flash", ev.Flash)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ev.Event == captureNoResponse {
+		m.noResponse++
+	}
+	m.last = &ev
+	s.events = append(s.events, ev)
+	if len(s.events) > captureEventsKept {
+		s.events = s.events[len(s.events)-captureEventsKept:]
+	}
+}
+
+// CaptureStatus reports the capture schedules and recent capture events.
+type CaptureStatus struct {
+	Meters map[string]MeterCaptureStatus `json:"meters"`
+	Events []CaptureEvent                `json:"events"`
+}
+
+// MeterCaptureStatus is the capture schedule of one meter.
+type MeterCaptureStatus struct {
+	Schedule     string        `json:"schedule"`
+	CommandTopic string        `json:"command_topic"`
+	Waiting      bool          `json:"waiting"`
+	NoResponse   int           `json:"no_response"`
+	LastEvent    *CaptureEvent `json:"last_event"`
+}
+
+// Status reports the schedules and recent capture events for /api/health.
+func (s *CaptureScheduler) Status() CaptureStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meters := make(map[string]MeterCaptureStatus, len(s.meters))
+	for id, m := range s.meters {
+		meters[id] = MeterCaptureStatus{
+			Schedule:     m.cfg.Schedule,
+			CommandTopic: m.cfg.CommandTopic,
+			Waiting:      m.waiting != nil,
+			NoResponse:   m.noResponse,
+			LastEvent:    m.last,
+		}
+	}
+	events := make([]CaptureEvent, len(s.events))
+	copy(events, s.events)
+	return CaptureStatus{
+		Meters: meters,
+		Events: events,
+	}
+}
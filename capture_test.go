@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCamera answers each capture command with the next of its frames.
+type fakeCamera struct {
+	mu       sync.Mutex
+	frames   []string
+	commands []captureCommand
+	s        *CaptureScheduler
+	t        *testing.T
+	sent     []*Frame
+}
+
+func (c *fakeCamera) publish(topic string, payload []byte) error {
+	var cmd captureCommand
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		c.t.Errorf("command on %s is not JSON: %v", topic, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.commands = append(c.commands, cmd)
+	if len(c.frames) == 0 {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join("sample", c.frames[0]))
+	if err != nil {
+		c.t.Fatalf("read sample: %v", err)
+	}
+	c.frames = c.frames[1:]
+	f := &Frame{MeterID: "gas", Image: data, done: make(chan error, 1)}
+	c.sent = append(c.sent, f)
+	go c.s.Submit(f)
+	return nil
+}
+
+func TestCaptureRetriesDarkFrames(t *testing.T) {
+	t.Parallel()
+
+	var got []*Frame
+	cam := &fakeCamera{frames: []string{"too_dark.jpg", "ok.jpg"}, t: t}
+	s, err := NewCaptureScheduler(
+		[]MeterConfig{{ID: "gas", Capture: CaptureConfig{Schedule: "@hourly", Flash: 100, FlashStep: 100, MaxFlash: 250}}},
+		"mqvision", cam.publish, func(f *Frame) { got = append(got, f) },
+	)
+	if err != nil {
+		t.Fatalf("NewCaptureScheduler: %v", err)
+	}
+	cam.s = s
+
+	s.capture(context.Background(), "gas", s.meters["gas"])
+
+	if len(cam.commands) != 2 || cam.commands[0].Flash != 100 || cam.commands[1].Flash != 200 {
+		t.Fatalf("commands = %+v, want flash 100 then 200", cam.commands)
+	}
+	if len(got) != 1 || got[0] != cam.sent[1] {
+		t.Fatalf("forwarded %d frames, want only the retaken one", len(got))
+	}
+	if err := <-cam.sent[0].done; err != errFrameRetaken {
+		t.Errorf("dark frame settled with %v, want errFrameRetaken", err)
+	}
+	if ev := s.meters["gas"].last; ev == nil || ev.Event != captureCaptured || ev.Attempt != 2 {
+		t.Errorf("last event = %+v, want captured on attempt 2", ev)
+	}
+}
+
+func TestCaptureNoResponse(t *testing.T) {
+	t.Parallel()
+
+	var got []*Frame
+	cam := &fakeCamera{t: t}
+	s, err := NewCaptureScheduler(
+		[]MeterConfig{{ID: "gas", Capture: CaptureConfig{Schedule: "@hourly", Timeout: 20 * time.Millisecond}}},
+		"mqvision", cam.publish, func(f *Frame) { got = append(got, f) },
+	)
+	if err != nil {
+		t.Fatalf("NewCaptureScheduler: %v", err)
+	}
+	cam.s = s
+	m := s.meters["gas"]
+
+	s.capture(context.Background(), "gas", m)
+
+	if m.noResponse != 1 || m.last == nil || m.last.Event != captureNoResponse {
+		t.Fatalf("noResponse = %d, last = %+v, want one no_response event", m.noResponse, m.last)
+	}
+	if m.cfg.CommandTopic != "mqvision/gas/capture" {
+		t.Errorf("CommandTopic = %q, want the default", m.cfg.CommandTopic)
+	}
+
+	// A late frame is no longer awaited and goes through as usual.
+	s.Submit(&Frame{MeterID: "gas"})
+	if len(got) != 1 {
+		t.Errorf("late frame forwarded %d times, want 1", len(got))
+	}
+}
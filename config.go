@@ -1,12 +1,25 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"log/slog"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/goccy/go-yaml"
 	"github.com/joho/godotenv"
+	"github.com/suapapa/mqvision/internal/concierge"
+	"github.com/suapapa/mqvision/internal/cron"
+	"github.com/suapapa/mqvision/internal/genai"
+	"github.com/suapapa/mqvision/internal/logctx"
+	"github.com/suapapa/mqvision/internal/mqttdump"
+	"github.com/suapapa/mqvision/internal/payload"
+	"github.com/suapapa/mqvision/internal/thumbnail"
+	"github.com/suapapa/mqvision/internal/tracing"
 )
 
 // PromptPair is a system/user prompt pair loaded from YAML.
@@ -15,15 +28,80 @@ type PromptPair struct {
 	User   string `yaml:"user"`
 }
 
+// expandClock fills the {{utc_offset}} and {{timezone}} placeholders from loc.
+func (p PromptPair) expandClock(loc *time.Location) PromptPair {
+	r := strings.NewReplacer(
+		"{{utc_offset}}", time.Now().In(loc).Format("-07:00"),
+		"{{timezone}}", loc.String(),
+	)
+	return PromptPair{
+		System: r.Replace(p.System),
+		User:   r.Replace(p.User),
+	}
+}
+
+// promptVersion is a short hash of prompts, so readings made with different
+// prompts can be told apart.
+func promptVersion(prompts ...PromptPair) string {
+	h := sha256.New()
+	for _, p := range prompts {
+		fmt.Fprintf(h, "%s\x00%s\x00", p.System, p.User)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// MeterConfig describes one gas meter read by this instance.
+type MeterConfig struct {
+	ID string `yaml:"id"`
+	// Window collects frames for this long after the first of a burst and
+	// reads only the sharpest, best exposed one. Zero reads every frame.
+	Window time.Duration `yaml:"window"`
+	// Limit caps the vision calls spent on this meter. on_exceed defaults to the global one.
+	Limit LimitConfig `yaml:"limit"`
+	// Capture triggers the meter's camera over MQTT on a schedule.
+	Capture CaptureConfig `yaml:"capture"`
+	// Source is where the meter's images come from. The first meter defaults
+	// to MQTT on MQTT_TOPIC when MQTT_HOST is set.
+	Source SourceConfig `yaml:"source"`
+	// Crop is the meter window in the camera image, archived as a close-up
+	// next to the thumbnail. Without it only the thumbnail is made.
+	Crop *thumbnail.Rect `yaml:"crop"`
+}
+
 // Config holds settings from environment variables and YAML (prompts).
 type Config struct {
+	// MQTT is optional; without a host images arrive only through the upload API.
 	MQTT struct {
 		Host  string
 		Topic string
+		// BaseTopic prefixes the state, error and availability topics mqvision publishes.
+		BaseTopic string
+		// DiscoveryPrefix is the Home Assistant discovery prefix; empty disables discovery.
+		DiscoveryPrefix string
+		// TLS applies to mqtts:// and wss:// hosts.
+		TLS mqttdump.TLSConfig
+		// QoS of the image subscription.
+		QoS byte
+		// ClientID is stable across restarts when set, so the broker can keep our session.
+		ClientID string
+		// CleanSession discards the session on disconnect. It defaults to false
+		// when ClientID is set, so images published during a restart are queued.
+		CleanSession bool
 	}
 	Concierge struct {
 		Addr  string
 		Token string
+		// TTL is how long concierge keeps archived images.
+		TTL time.Duration
+		// Timeout bounds each request to concierge; failed requests are retried.
+		Timeout time.Duration
+	}
+	API struct {
+		// Token is an admin token next to those of Auth, named "api".
+		Token string
+		// PublicURL prefixes the src_image_url of archived images, e.g.
+		// https://mqvision.example.com; empty makes them relative.
+		PublicURL string
 	}
 	// Gemini struct {
 	// 	APIKey string
@@ -38,8 +116,54 @@ type Config struct {
 		URI string
 		DB  string
 	}
-	ReadGasGauge PromptPair `yaml:"read_gas_gauge"`
-	FixAmbiguous PromptPair `yaml:"fix_ambiguous"`
+	// Tracing follows the standard OTEL_* variables; the OTLP exporter reads
+	// its endpoint and headers from OTEL_EXPORTER_OTLP_* itself.
+	Tracing struct {
+		Exporter    string
+		ServiceName string
+	}
+	// Log selects the log format (text or json) and the lowest level logged.
+	Log struct {
+		Format string
+		Level  slog.Level
+	}
+	// Pricing holds per-million-token model prices and an optional monthly budget
+	// (zero means unlimited) after which vision calls are paused.
+	Pricing struct {
+		Currency      string           `yaml:"currency"`
+		MonthlyBudget float64          `yaml:"monthly_budget"`
+		Models        genai.PriceTable `yaml:"models"`
+	} `yaml:"pricing"`
+	// CameraClock describes the clock the camera imprints on each image.
+	CameraClock struct {
+		// Timezone is the IANA zone of the camera clock; it also fills
+		// {{utc_offset}} and {{timezone}} in the prompts.
+		Timezone string `yaml:"timezone"`
+		// MaxDrift is how far the imprinted time may be from the read time
+		// before it is no longer trusted as the reading's timestamp.
+		MaxDrift time.Duration `yaml:"max_drift"`
+
+		location *time.Location
+	} `yaml:"camera_clock"`
+	// Meters lists the meters; without any, a single meter "gas" is assumed.
+	Meters []MeterConfig `yaml:"meters"`
+	// Sinks receive every incoming image alongside the vision client.
+	Sinks []SinkConfig `yaml:"sinks"`
+	// Archive is the store of the archive sink.
+	Archive ArchiveConfig `yaml:"archive"`
+	// Exchanges is where the raw model answers behind each reading are kept.
+	Exchanges ExchangeConfig `yaml:"exchanges"`
+	// Auth lists the API tokens and what anonymous requests may do.
+	Auth AuthConfig `yaml:"auth"`
+	// Payload describes how cameras encode images in MQTT messages.
+	Payload payload.Config `yaml:"payload"`
+	// Limit caps the vision calls across all meters.
+	Limit        LimitConfig `yaml:"limit"`
+	ReadGasGauge PromptPair  `yaml:"read_gas_gauge"`
+	FixAmbiguous PromptPair  `yaml:"fix_ambiguous"`
+
+	// promptVersion identifies the prompts above; readings record it.
+	promptVersion string
 }
 
 // LoadConfig reads prompt settings from YAML and connection secrets from the environment.
@@ -62,12 +186,84 @@ func LoadConfig(filename string) (*Config, error) {
 
 	config.MQTT.Host = os.Getenv("MQTT_HOST")
 	config.MQTT.Topic = os.Getenv("MQTT_TOPIC")
+	config.MQTT.BaseTopic = os.Getenv("MQTT_BASE_TOPIC")
+	if config.MQTT.BaseTopic == "" {
+		config.MQTT.BaseTopic = "mqvision"
+	}
+	config.MQTT.TLS.CAFile = os.Getenv("MQTT_CA_FILE")
+	config.MQTT.TLS.CertFile = os.Getenv("MQTT_CERT_FILE")
+	config.MQTT.TLS.KeyFile = os.Getenv("MQTT_KEY_FILE")
+	if v := os.Getenv("MQTT_INSECURE_SKIP_VERIFY"); v != "" {
+		skip, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("MQTT_INSECURE_SKIP_VERIFY: %w", err)
+		}
+		config.MQTT.TLS.InsecureSkipVerify = skip
+	}
+	config.MQTT.QoS = 1
+	if v := os.Getenv("MQTT_QOS"); v != "" {
+		qos, err := strconv.ParseUint(v, 10, 8)
+		if err != nil || qos > 2 {
+			return nil, fmt.Errorf("MQTT_QOS must be 0, 1 or 2")
+		}
+		config.MQTT.QoS = byte(qos)
+	}
+	config.MQTT.ClientID = os.Getenv("MQTT_CLIENT_ID")
+	config.MQTT.CleanSession = config.MQTT.ClientID == ""
+	if v := os.Getenv("MQTT_CLEAN_SESSION"); v != "" {
+		clean, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("MQTT_CLEAN_SESSION: %w", err)
+		}
+		config.MQTT.CleanSession = clean
+	}
+	config.MQTT.DiscoveryPrefix = "homeassistant"
+	if v, ok := os.LookupEnv("HASS_DISCOVERY_PREFIX"); ok {
+		config.MQTT.DiscoveryPrefix = v
+	}
+	config.API.Token = os.Getenv("API_TOKEN")
+	config.API.PublicURL = strings.TrimRight(os.Getenv("PUBLIC_URL"), "/")
 	config.Concierge.Addr = os.Getenv("CONCIERGE_ADDR")
 	config.Concierge.Token = os.Getenv("CONCIERGE_TOKEN")
+	config.Concierge.TTL = concierge.DefaultTTL
+	if v := os.Getenv("CONCIERGE_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil || ttl < time.Minute {
+			return nil, fmt.Errorf("CONCIERGE_TTL must be a duration of at least 1m")
+		}
+		config.Concierge.TTL = ttl
+	}
+	config.Concierge.Timeout = 30 * time.Second
+	if v := os.Getenv("CONCIERGE_TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("CONCIERGE_TIMEOUT must be a positive duration")
+		}
+		config.Concierge.Timeout = timeout
+	}
 	config.OpenAICompat.BaseURL = os.Getenv("OPENAI_BASE_URL")
 	config.OpenAICompat.APIKey = os.Getenv("OPENAI_API_KEY")
 	config.OpenAICompat.Model = os.Getenv("OPENAI_MODEL")
 
+	config.Tracing.Exporter = os.Getenv("OTEL_TRACES_EXPORTER")
+	if config.Tracing.Exporter == "" {
+		config.Tracing.Exporter = tracing.ExporterNone
+	}
+	config.Tracing.ServiceName = os.Getenv("OTEL_SERVICE_NAME")
+	if config.Tracing.ServiceName == "" {
+		config.Tracing.ServiceName = "mqvision"
+	}
+
+	config.Log.Format = os.Getenv("LOG_FORMAT")
+	if config.Log.Format == "" {
+		config.Log.Format = logctx.FormatText
+	}
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if err := config.Log.Level.UnmarshalText([]byte(v)); err != nil {
+			return nil, fmt.Errorf("LOG_LEVEL must be one of debug, info, warn, error")
+		}
+	}
+
 	config.Mongo.URI = os.Getenv("MONGO_URI")
 	if config.Mongo.URI == "" {
 		config.Mongo.URI = "mongodb://localhost:27017"
@@ -77,6 +273,69 @@ func LoadConfig(filename string) (*Config, error) {
 		config.Mongo.DB = "mqvision"
 	}
 
+	if config.CameraClock.Timezone == "" {
+		config.CameraClock.Timezone = "Asia/Seoul"
+	}
+	if config.CameraClock.MaxDrift == 0 {
+		config.CameraClock.MaxDrift = 10 * time.Minute
+	}
+	loc, err := time.LoadLocation(config.CameraClock.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("camera_clock.timezone: %w", err)
+	}
+	config.CameraClock.location = loc
+	config.ReadGasGauge = config.ReadGasGauge.expandClock(loc)
+	config.promptVersion = promptVersion(config.ReadGasGauge, config.FixAmbiguous)
+
+	if len(config.Meters) == 0 {
+		config.Meters = []MeterConfig{{ID: "gas"}}
+	}
+
+	for i := range config.Meters {
+		src := &config.Meters[i].Source
+		if src.Type == "" && i == 0 && config.MQTT.Host != "" {
+			src.Type = sourceMQTT
+		}
+		if src.Type == sourceMQTT && src.Topic == "" {
+			src.Topic = config.MQTT.Topic
+		}
+		if src.Type == sourceHTTP && src.Interval == 0 {
+			src.Interval = defaultSnapshotInterval
+		}
+		src.URL = os.ExpandEnv(src.URL)
+		src.Username = os.ExpandEnv(src.Username)
+		src.Password = os.ExpandEnv(src.Password)
+	}
+
+	// Without an archive section, archive to concierge as before when it is configured.
+	if config.Archive.Store == "" && config.Concierge.Addr != "" && config.Concierge.Token != "" {
+		config.Archive.Store = storeConcierge
+	}
+	if config.Archive.S3.Region == "" {
+		config.Archive.S3.Region = "us-east-1"
+	}
+	config.Archive.S3.AccessKey = os.ExpandEnv(config.Archive.S3.AccessKey)
+	config.Archive.S3.SecretKey = os.ExpandEnv(config.Archive.S3.SecretKey)
+	// Without a sinks section, images are archived when there is a store.
+	if config.Sinks == nil && config.Archive.Store != "" {
+		config.Sinks = []SinkConfig{{Type: sinkArchive}}
+	}
+	for i := range config.Sinks {
+		config.Sinks[i].URL = os.ExpandEnv(config.Sinks[i].URL)
+		config.Sinks[i].Token = os.ExpandEnv(config.Sinks[i].Token)
+	}
+
+	if config.Exchanges.Store == "" {
+		config.Exchanges.Store = exchangesInReading
+	}
+	if config.Auth.Anonymous == "" {
+		config.Auth.Anonymous = anonymousRead
+	}
+
+	if config.Pricing.Currency == "" {
+		config.Pricing.Currency = "USD"
+	}
+
 	if err := config.validate(); err != nil {
 		return nil, err
 	}
@@ -88,8 +347,6 @@ func (c *Config) validate() error {
 	required := []struct {
 		name, value string
 	}{
-		{"MQTT_HOST", c.MQTT.Host},
-		{"MQTT_TOPIC", c.MQTT.Topic},
 		{"OPENAI_BASE_URL", c.OpenAICompat.BaseURL},
 		{"OPENAI_API_KEY", c.OpenAICompat.APIKey},
 		{"OPENAI_MODEL", c.OpenAICompat.Model},
@@ -105,5 +362,169 @@ func (c *Config) validate() error {
 			return fmt.Errorf("%s is required", r.name)
 		}
 	}
+	if c.CameraClock.MaxDrift < 0 {
+		return fmt.Errorf("camera_clock.max_drift must not be negative")
+	}
+	if c.Pricing.MonthlyBudget < 0 {
+		return fmt.Errorf("pricing.monthly_budget must not be negative")
+	}
+
+	if _, err := payload.New(c.Payload); err != nil {
+		return fmt.Errorf("payload: %w", err)
+	}
+	switch c.Tracing.Exporter {
+	case tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterConsole:
+	default:
+		return fmt.Errorf("OTEL_TRACES_EXPORTER must be one of none, otlp, console")
+	}
+	switch c.Log.Format {
+	case logctx.FormatText, logctx.FormatJSON:
+	default:
+		return fmt.Errorf("LOG_FORMAT must be one of text, json")
+	}
+	if err := c.Archive.validate("archive", c); err != nil {
+		return err
+	}
+	if err := c.Exchanges.validate("exchanges"); err != nil {
+		return err
+	}
+	if err := c.Auth.validate("auth"); err != nil {
+		return err
+	}
+	for i, s := range c.Sinks {
+		if err := s.validate(fmt.Sprintf("sinks[%d]", i), c); err != nil {
+			return err
+		}
+	}
+	if err := c.Limit.validate("limit"); err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	topics := make(map[string]bool)
+	for i, m := range c.Meters {
+		if strings.TrimSpace(m.ID) == "" {
+			return fmt.Errorf("meters[%d].id is required", i)
+		}
+		if seen[m.ID] {
+			return fmt.Errorf("meters[%d].id %q is duplicated", i, m.ID)
+		}
+		seen[m.ID] = true
+		if m.Window < 0 {
+			return fmt.Errorf("meters[%d].window must not be negative", i)
+		}
+		if err := m.Limit.validate(fmt.Sprintf("meters[%d].limit", i)); err != nil {
+			return err
+		}
+		if m.Crop != nil {
+			if err := m.Crop.Validate(); err != nil {
+				return fmt.Errorf("meters[%d].crop: %w", i, err)
+			}
+		}
+		if err := m.Capture.validate(fmt.Sprintf("meters[%d].capture", i)); err != nil {
+			return err
+		}
+		if err := m.Source.validate(fmt.Sprintf("meters[%d].source", i), c.MQTT.Host); err != nil {
+			return err
+		}
+		if m.Source.Type == sourceMQTT {
+			if topics[m.Source.Topic] {
+				return fmt.Errorf("meters[%d].source.topic %q is used by another meter", i, m.Source.Topic)
+			}
+			topics[m.Source.Topic] = true
+		}
+	}
+	return nil
+}
+
+func (s SinkConfig) validate(name string, c *Config) error {
+	switch s.Type {
+	case sinkArchive:
+		if c.Archive.Store == "" {
+			return fmt.Errorf("%s: archive sink needs an archive store", name)
+		}
+	case sinkDisk:
+		if strings.TrimSpace(s.Dir) == "" {
+			return fmt.Errorf("%s.dir is required", name)
+		}
+	case sinkForward:
+		if strings.TrimSpace(s.URL) == "" {
+			return fmt.Errorf("%s.url is required", name)
+		}
+	default:
+		return fmt.Errorf("%s.type must be one of archive, disk, forward", name)
+	}
+	if s.Timeout < 0 {
+		return fmt.Errorf("%s.timeout must not be negative", name)
+	}
 	return nil
 }
+
+func (s SourceConfig) validate(name, mqttHost string) error {
+	switch s.Type {
+	case "":
+	case sourceMQTT:
+		if mqttHost == "" {
+			return fmt.Errorf("%s: mqtt source needs MQTT_HOST", name)
+		}
+		if strings.TrimSpace(s.Topic) == "" {
+			return fmt.Errorf("%s.topic or MQTT_TOPIC is required", name)
+		}
+	case sourceHTTP:
+		if strings.TrimSpace(s.URL) == "" {
+			return fmt.Errorf("%s.url is required", name)
+		}
+		if s.Interval < 0 {
+			return fmt.Errorf("%s.interval must not be negative", name)
+		}
+	case sourceDir:
+		if strings.TrimSpace(s.Path) == "" {
+			return fmt.Errorf("%s.path is required", name)
+		}
+	default:
+		return fmt.Errorf("%s.type must be one of mqtt, http, dir", name)
+	}
+	return nil
+}
+
+func (c CaptureConfig) validate(name string) error {
+	if c.Schedule != "" {
+		if _, err := cron.Parse(c.Schedule); err != nil {
+			return fmt.Errorf("%s.schedule: %w", name, err)
+		}
+	}
+	if c.Timeout < 0 || c.Flash < 0 || c.FlashStep < 0 || c.MaxFlash < 0 {
+		return fmt.Errorf("%s must not be negative", name)
+	}
+	if c.MaxFlash > 0 && c.Flash > c.MaxFlash {
+		return fmt.Errorf("%s.flash must not exceed max_flash", name)
+	}
+	return nil
+}
+
+func (l LimitConfig) validate(name string) error {
+	switch l.OnExceed {
+	case "", onExceedDrop, onExceedQueue, onExceedLatest:
+	default:
+		return fmt.Errorf("%s.on_exceed must be one of drop, queue, latest", name)
+	}
+	if l.Every < 0 || l.Burst < 0 || l.DailyQuota < 0 {
+		return fmt.Errorf("%s must not be negative", name)
+	}
+	return nil
+}
+
+// hasMeter reports whether id is a configured meter.
+func (c *Config) hasMeter(id string) bool {
+	_, ok := c.meter(id)
+	return ok
+}
+
+// meter returns the configuration of meter id.
+func (c *Config) meter(id string) (MeterConfig, bool) {
+	for _, m := range c.Meters {
+		if m.ID == id {
+			return m, true
+		}
+	}
+	return MeterConfig{}, false
+}
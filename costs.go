@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/suapapa/mqvision/internal/genai"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var errBudgetExceeded = errors.New("monthly LLM budget exceeded")
+
+// usageRecord is one priced vision call (a reading plus its fix_ambiguous follow-up).
+type usageRecord struct {
+	At          time.Time `bson:"at"`
+	Model       string    `bson:"model"`
+	genai.Usage `bson:",inline"`
+	Cost        float64 `bson:"cost"`
+}
+
+// CostTotal aggregates usage over one day ("2006-01-02") or month ("2006-01").
+type CostTotal struct {
+	Period           string  `json:"period" bson:"_id"`
+	Cost             float64 `json:"cost" bson:"cost"`
+	PromptTokens     int     `json:"prompt_tokens" bson:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens" bson:"completion_tokens"`
+	Calls            int     `json:"calls" bson:"calls"`
+}
+
+// CostTracker prices LLM usage from a per-model table, keeps a ledger in MongoDB
+// and pauses vision calls once the monthly budget is spent.
+type CostTracker struct {
+	prices   genai.PriceTable
+	currency string
+	budget   float64 // per month; zero means unlimited
+
+	collection *mongo.Collection
+
+	mu         sync.Mutex
+	day        string
+	dayTotal   float64
+	month      string
+	monthTotal float64
+}
+
+// NewCostTracker opens the usage ledger and loads today's and this month's totals.
+func NewCostTracker(ctx context.Context, db *mongo.Database, prices genai.PriceTable, currency string, monthlyBudget float64) (*CostTracker, error) {
+	t := &CostTracker{
+		prices:     prices,
+		currency:   currency,
+		budget:     monthlyBudget,
+		collection: db.Collection("llm_usage"),
+	}
+
+	now := time.Now()
+	monthly, err := t.totals(ctx, startOfMonth(now), "%Y-%m")
+	if err != nil {
+		return nil, fmt.Errorf("load monthly cost: %w", err)
+	}
+	daily, err := t.totals(ctx, startOfDay(now), "%Y-%m-%d")
+	if err != nil {
+		return nil, fmt.Errorf("load daily cost: %w", err)
+	}
+
+	t.day, t.month = now.Format("2006-01-02"), now.Format("2006-01")
+	for _, m := range monthly {
+		if m.Period == t.month {
+			t.monthTotal = m.Cost
+		}
+	}
+	for _, d := range daily {
+		if d.Period == t.day {
+			t.dayTotal = d.Cost
+		}
+	}
+	return t, nil
+}
+
+// Paused reports whether the monthly budget is used up.
+func (t *CostTracker) Paused() bool {
+	if t == nil || t.budget <= 0 {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollover(time.Now())
+	return t.monthTotal >= t.budget
+}
+
+// Record prices the usage in r, appends it to the ledger and returns its cost.
+func (t *CostTracker) Record(ctx context.Context, r *genai.GasMeterReadResult) (float64, error) {
+	if t == nil || r == nil || r.Usage.Calls == 0 {
+		return 0, nil
+	}
+	cost, ok := t.prices.Cost(r.Model, r.Usage)
+	if !ok {
+		slog.WarnContext(ctx, "No price configured for model; recording zero cost", "model", r.Model)
+	}
+
+	observeLLMUsage(r.Model, t.currency, r.Usage, cost)
+
+	now := time.Now()
+	t.mu.Lock()
+	t.rollover(now)
+	t.dayTotal += cost
+	t.monthTotal += cost
+	t.mu.Unlock()
+
+	rec := usageRecord{At: now, Model: r.Model, Usage: r.Usage, Cost: cost}
+	if _, err := t.collection.InsertOne(ctx, rec); err != nil {
+		return cost, fmt.Errorf("insert usage: %w", err)
+	}
+	return cost, nil
+}
+
+// CostStatus is the current spending.
+type CostStatus struct {
+	Currency      string  `json:"currency"`
+	CostToday     float64 `json:"cost_today"`
+	CostMonth     float64 `json:"cost_month"`
+	MonthlyBudget float64 `json:"monthly_budget"`
+	Paused        bool    `json:"paused"`
+}
+
+// Costs is the response of /api/costs.
+type Costs struct {
+	CostStatus
+	Daily   []CostTotal `json:"daily"`
+	Monthly []CostTotal `json:"monthly"`
+}
+
+// Status summarizes the current spending for health and metrics.
+func (t *CostTracker) Status() CostStatus {
+	t.mu.Lock()
+	t.rollover(time.Now())
+	today, month := t.dayTotal, t.monthTotal
+	t.mu.Unlock()
+
+	return CostStatus{
+		Currency:      t.currency,
+		CostToday:     today,
+		CostMonth:     month,
+		MonthlyBudget: t.budget,
+		Paused:        t.budget > 0 && month >= t.budget,
+	}
+}
+
+// rollover resets the running totals when the day or month changed. t.mu must be held.
+func (t *CostTracker) rollover(now time.Time) {
+	if d := now.Format("2006-01-02"); d != t.day {
+		t.day, t.dayTotal = d, 0
+	}
+	if m := now.Format("2006-01"); m != t.month {
+		t.month, t.monthTotal = m, 0
+	}
+}
+
+// localZone is the IANA name of the local timezone, empty when it is not known.
+var localZone = sync.OnceValue(localZoneName)
+
+// localZoneName finds the IANA name of time.Local in TZ or, without TZ, in the
+// target of the /etc/localtime link, where Go loads it from.
+func localZoneName() string {
+	name, ok := os.LookupEnv("TZ")
+	if !ok {
+		target, err := os.Readlink("/etc/localtime")
+		if err != nil {
+			return ""
+		}
+		name = target
+	}
+	name = strings.TrimPrefix(name, ":")
+	if name == "" {
+		return "UTC"
+	}
+	if filepath.IsAbs(name) {
+		// A zoneinfo file is named after its zone.
+		if _, name, ok = strings.Cut(name, "/zoneinfo/"); !ok {
+			return ""
+		}
+	}
+	if _, err := time.LoadLocation(name); err != nil {
+		return ""
+	}
+	return name
+}
+
+// dateZone is the timezone the ledger is bucketed in: the local zone by name,
+// so that buckets follow its DST changes, or else the UTC offset at since.
+func dateZone(since time.Time) string {
+	if name := localZone(); name != "" {
+		return name
+	}
+	return since.Format("-07:00")
+}
+
+// totals groups the ledger since the given time by the $dateToString format in local time.
+func (t *CostTracker) totals(ctx context.Context, since time.Time, format string) ([]CostTotal, error) {
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{"at": bson.M{"$gte": since}}},
+		bson.M{"$group": bson.M{
+			"_id": bson.M{"$dateToString": bson.M{
+				"format":   format,
+				"date":     "$at",
+				"timezone": dateZone(since),
+			}},
+			"cost":              bson.M{"$sum": "$cost"},
+			"prompt_tokens":     bson.M{"$sum": "$prompt_tokens"},
+			"completion_tokens": bson.M{"$sum": "$completion_tokens"},
+			"calls":             bson.M{"$sum": "$calls"},
+		}},
+		bson.M{"$sort": bson.M{"_id": 1}},
+	}
+
+	cursor, err := t.collection.Aggregate(ctx, pipeline, options.Aggregate())
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	out := []CostTotal{}
+	if err := cursor.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetCostsHandler returns daily totals for the last 31 days and monthly totals for the last 12 months.
+func (t *CostTracker) GetCostsHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	now := time.Now()
+
+	daily, err := t.totals(ctx, startOfDay(now).AddDate(0, 0, -30), "%Y-%m-%d")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to aggregate daily cost: %v", err),
+		})
+		return
+	}
+	monthly, err := t.totals(ctx, startOfMonth(now).AddDate(0, -11, 0), "%Y-%m")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": fmt.Sprintf("failed to aggregate monthly cost: %v", err),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Costs{CostStatus: t.Status(), Daily: daily, Monthly: monthly})
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+func startOfMonth(t time.Time) time.Time {
+	y, m, _ := t.Date()
+	return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+}
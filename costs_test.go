@@ -0,0 +1,38 @@
+package main
+
+import (
+	"cmp"
+	"testing"
+	"time"
+)
+
+func TestLocalZoneName(t *testing.T) {
+	tests := []struct {
+		tz   string
+		want string
+	}{
+		{"Europe/Berlin", "Europe/Berlin"},
+		{":America/New_York", "America/New_York"},
+		{"", "UTC"},
+		{"/usr/share/zoneinfo/Asia/Seoul", "Asia/Seoul"},
+		{"/etc/localtime", ""},
+		{"Nowhere/Atlantis", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tz, func(t *testing.T) {
+			t.Setenv("TZ", tt.tz)
+			if got := localZoneName(); got != tt.want {
+				t.Errorf("localZoneName() with TZ=%q = %q, want %q", tt.tz, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDateZone(t *testing.T) {
+	t.Parallel()
+
+	since := time.Date(2025, 3, 1, 0, 0, 0, 0, time.FixedZone("", 9*3600))
+	if got, want := dateZone(since), cmp.Or(localZone(), "+09:00"); got != want {
+		t.Errorf("dateZone = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/suapapa/mqvision/internal/genai"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Where the raw model answers behind each reading are kept.
+const (
+	exchangesInReading  = "reading"    // in the reading's metadata.exchange
+	exchangesCollection = "collection" // in exchangeCollection, expiring after Retention
+	exchangesNone       = "none"
+)
+
+const (
+	exchangeCollection = "model_exchanges"
+	exchangeTTLIndex   = "at_ttl"
+)
+
+// ExchangeConfig decides where the raw model answers behind each reading are kept.
+type ExchangeConfig struct {
+	Store string `yaml:"store"`
+	// Retention expires exchanges kept in the collection; zero keeps them.
+	Retention time.Duration `yaml:"retention"`
+}
+
+func (c ExchangeConfig) validate(name string) error {
+	switch c.Store {
+	case exchangesInReading, exchangesCollection, exchangesNone:
+	default:
+		return fmt.Errorf("%s.store must be one of reading, collection, none", name)
+	}
+	if c.Retention < 0 {
+		return fmt.Errorf("%s.retention must not be negative", name)
+	}
+	return nil
+}
+
+// exchangeRecord is an exchange kept apart from its reading. Exchanges behind
+// frames that ended without a reading have no reading id but the image and why.
+type exchangeRecord struct {
+	ReadingID      bson.ObjectID `bson:"reading_id,omitempty"`
+	MeterID        string        `bson:"meter_id"`
+	At             time.Time     `bson:"at"`
+	Image          string        `bson:"image,omitempty"`
+	Error          string        `bson:"error,omitempty"`
+	genai.Exchange `bson:",inline"`
+}
+
+// detachExchange takes the exchange off l unless it is to be stored with the
+// reading, and returns it when it goes to the exchange collection.
+func detachExchange(l *Luggage, store string) *genai.Exchange {
+	if l.GasMeterReadResult == nil || store == exchangesInReading {
+		return nil
+	}
+	ex := l.Exchange
+	l.Exchange = nil
+	if store != exchangesCollection {
+		return nil
+	}
+	return ex
+}
+
+// withoutExchange returns metadata without the model exchange kept in it, for
+// callers below admin. metadata itself is left as it is.
+func withoutExchange(metadata any) any {
+	switch m := metadata.(type) {
+	case *Luggage:
+		if m == nil || m.GasMeterReadResult == nil || m.Exchange == nil {
+			return metadata
+		}
+		l, res := *m, *m.GasMeterReadResult
+		res.Exchange = nil
+		l.GasMeterReadResult = &res
+		return &l
+	case map[string]any:
+		if _, ok := m["exchange"]; !ok {
+			return metadata
+		}
+		out := make(map[string]any, len(m)-1)
+		for k, v := range m {
+			if k != "exchange" {
+				out[k] = v
+			}
+		}
+		return out
+	}
+	return metadata
+}
+
+// SetupExchanges indexes the exchange collection and expires its entries after
+// retention, or keeps them when it is zero.
+func (s *SensorServer) SetupExchanges(ctx context.Context, retention time.Duration) error {
+	indexes := s.exchanges.Indexes()
+	if _, err := indexes.CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "reading_id", Value: 1}}}); err != nil {
+		return fmt.Errorf("index exchanges: %w", err)
+	}
+
+	if retention <= 0 {
+		if err := indexes.DropOne(ctx, exchangeTTLIndex); err != nil && !isIndexNotFound(err) {
+			return fmt.Errorf("drop exchange retention: %w", err)
+		}
+		return nil
+	}
+	seconds := int32(retention / time.Second)
+	_, err := indexes.CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "at", Value: 1}},
+		Options: options.Index().SetName(exchangeTTLIndex).SetExpireAfterSeconds(seconds),
+	})
+	if err == nil {
+		return nil
+	}
+	// The index exists with another retention; change it in place.
+	cmd := bson.D{
+		{Key: "collMod", Value: exchangeCollection},
+		{Key: "index", Value: bson.D{{Key: "name", Value: exchangeTTLIndex}, {Key: "expireAfterSeconds", Value: seconds}}},
+	}
+	if err := s.db.RunCommand(ctx, cmd).Err(); err != nil {
+		return fmt.Errorf("set exchange retention: %w", err)
+	}
+	return nil
+}
+
+func isIndexNotFound(err error) bool {
+	var ce mongo.CommandError
+	return errors.As(err, &ce) && ce.Name == "IndexNotFound"
+}
+
+// StoreExchange keeps ex apart from reading id of meterID.
+func (s *SensorServer) StoreExchange(ctx context.Context, id bson.ObjectID, meterID string, ex *genai.Exchange) error {
+	rec := exchangeRecord{ReadingID: id, MeterID: meterID, At: time.Now(), Exchange: *ex}
+	if _, err := s.exchanges.InsertOne(ctx, rec); err != nil {
+		return fmt.Errorf("insert exchange: %w", err)
+	}
+	return nil
+}
+
+// StoreRejectedExchange keeps ex, the model's answers behind image of meterID,
+// which ended without a reading because of cause.
+func (s *SensorServer) StoreRejectedExchange(ctx context.Context, meterID, image string, ex *genai.Exchange, cause error) error {
+	rec := exchangeRecord{MeterID: meterID, At: time.Now(), Image: image, Error: cause.Error(), Exchange: *ex}
+	if _, err := s.exchanges.InsertOne(ctx, rec); err != nil {
+		return fmt.Errorf("insert exchange: %w", err)
+	}
+	return nil
+}
+
+// keepRejectedExchange stores the exchange behind a frame that ended without a
+// reading because of cause, in the exchange collection whatever the store,
+// unless exchanges are not kept at all.
+func keepRejectedExchange(ctx context.Context, f *Frame, ex *genai.Exchange, cause error) {
+	if ex == nil || sensorServer == nil || config.Exchanges.Store == exchangesNone {
+		return
+	}
+	if err := sensorServer.StoreRejectedExchange(ctx, f.MeterID, f.image, ex, cause); err != nil {
+		slog.ErrorContext(ctx, "Error storing model exchange", "err", err)
+	}
+}
+
+// Exchange returns the exchange kept apart from reading id, or nil if there is none.
+func (s *SensorServer) Exchange(ctx context.Context, id bson.ObjectID) (*genai.Exchange, error) {
+	var rec exchangeRecord
+	err := s.exchanges.FindOne(ctx, bson.M{"reading_id": id}).Decode(&rec)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("find exchange: %w", err)
+	}
+	return &rec.Exchange, nil
+}
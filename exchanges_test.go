@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/suapapa/mqvision/internal/genai"
+)
+
+func TestDetachExchange(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		store        string
+		wantDetached bool
+		wantKept     bool
+	}{
+		{exchangesInReading, false, true},
+		{exchangesCollection, true, false},
+		{exchangesNone, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.store, func(t *testing.T) {
+			t.Parallel()
+			ex := &genai.Exchange{Model: "m", Answer: `{"read":"1"}`}
+			l := &Luggage{GasMeterReadResult: &genai.GasMeterReadResult{Read: "1", Exchange: ex}}
+			got := detachExchange(l, tt.store)
+			if (got == ex) != tt.wantDetached || (l.Exchange == ex) != tt.wantKept {
+				t.Errorf("detached %v, kept %v", got, l.Exchange)
+			}
+		})
+	}
+
+	if got := detachExchange(&Luggage{}, exchangesCollection); got != nil {
+		t.Errorf("detached %v from a luggage without a read result", got)
+	}
+}
+
+func TestWithoutExchange(t *testing.T) {
+	t.Parallel()
+
+	ex := &genai.Exchange{Model: "m", Answer: `{"read":"1"}`}
+	l := &Luggage{MeterID: "gas", GasMeterReadResult: &genai.GasMeterReadResult{Read: "1", Exchange: ex}}
+	got, ok := withoutExchange(l).(*Luggage)
+	if !ok || got.Exchange != nil || got.Read != "1" || got.MeterID != "gas" {
+		t.Errorf("withoutExchange(luggage) = %+v", got)
+	}
+	if l.Exchange != ex {
+		t.Error("withoutExchange changed the luggage it was given")
+	}
+
+	m := map[string]any{"read": "1", "exchange": map[string]any{"model": "m"}}
+	if got := withoutExchange(m).(map[string]any); got["exchange"] != nil || got["read"] != "1" {
+		t.Errorf("withoutExchange(map) = %v", got)
+	}
+	if m["exchange"] == nil {
+		t.Error("withoutExchange changed the map it was given")
+	}
+
+	if got := withoutExchange(nil); got != nil {
+		t.Errorf("withoutExchange(nil) = %v", got)
+	}
+}
+
+func TestLatestExchangeScope(t *testing.T) {
+	t.Parallel()
+
+	s := &SensorServer{Value: 1, UpdatedAt: time.Now(), Metadata: &Luggage{
+		MeterID:            "gas",
+		GasMeterReadResult: &genai.GasMeterReadResult{Read: "00001.000", Exchange: &genai.Exchange{Model: "m", Answer: "secret"}},
+	}}
+	for _, scope := range []string{scopeRead, scopeWrite, scopeAdmin} {
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		router.Use(conformsToSpec(t, nil))
+		router.Use(func(c *gin.Context) { c.Set(tokenRankKey, rank(scope)) })
+		router.GET("/api/sensor", s.GetValueHandler)
+
+		w := serve(router, http.MethodGet, "/api/sensor", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", scope, w.Code)
+		}
+		if got, want := strings.Contains(w.Body.String(), "secret"), scope == scopeAdmin; got != want {
+			t.Errorf("%s: exchange served %v, want %v: %s", scope, got, want, w.Body)
+		}
+	}
+}
+
+func TestExchangeConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		cfg     ExchangeConfig
+		wantErr bool
+	}{
+		{ExchangeConfig{Store: exchangesInReading}, false},
+		{ExchangeConfig{Store: exchangesCollection, Retention: 720 * time.Hour}, false},
+		{ExchangeConfig{Store: "file"}, true},
+		{ExchangeConfig{Store: exchangesCollection, Retention: -1}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.cfg.validate("exchanges"); (err != nil) != tt.wantErr {
+			t.Errorf("validate(%+v) = %v, want error %v", tt.cfg, err, tt.wantErr)
+		}
+	}
+}
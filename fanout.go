@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/suapapa/mqvision/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Sink types. The vision sink is always there and cannot be configured.
+const (
+	sinkVision  = "vision"
+	sinkArchive = "archive" // the image store behind src_image_url
+	sinkDisk    = "disk"    // a local copy under Dir
+	sinkForward = "forward" // POST to URL, e.g. another mqvision
+)
+
+// Sink defaults.
+const (
+	defaultSinkTimeout = 10 * time.Second
+	// sinkStallTimeout cuts off a sink that stops reading the image stream for
+	// this long; writeChunks makes it a bound on a pause, not on the whole read.
+	sinkStallTimeout = 5 * time.Second
+	// sinkChunkSize is the smallest piece of the image written to the sinks at a time.
+	sinkChunkSize = 32 << 10
+)
+
+// SinkConfig declares one consumer of every incoming image besides the vision client.
+type SinkConfig struct {
+	Type string `yaml:"type"`
+	// Timeout bounds the sink per image (default 10s).
+	Timeout time.Duration `yaml:"timeout"`
+	// Dir receives <meter id>/<time>.<ext> copies (disk).
+	Dir string `yaml:"dir"`
+	// URL receives the image as the request body, with Token as bearer if set (forward).
+	URL   string `yaml:"url"`
+	Token string `yaml:"token"`
+}
+
+// Sink consumes the image stream of one frame.
+type Sink struct {
+	Name    string
+	Timeout time.Duration // zero means no limit beyond ctx
+	// Wait makes fanOut wait for the sink and return its value; other sinks finish in the background.
+	Wait    bool
+	Consume func(ctx context.Context, f *Frame, r io.Reader) (any, error)
+}
+
+// sinkResult is what a waited-for sink returned.
+type sinkResult struct {
+	Value any
+	Err   error
+}
+
+// fanOut streams f.Image to every sink concurrently through a SingleInMultiOutPipe
+// and returns the results of the sinks it waited for, by name, and the results
+// to come of the others. A sink that fails, times out or stops reading is cut
+// off without holding up the others.
+func fanOut(ctx context.Context, f *Frame, sinks []Sink) (results map[string]sinkResult, background map[string]<-chan sinkResult) {
+	w, readers := SingleInMultiOutPipe(len(sinks))
+	w.SetStallTimeout(sinkStallTimeout)
+
+	start := time.Now()
+	done := make([]chan sinkResult, len(sinks))
+	for i, s := range sinks {
+		done[i] = make(chan sinkResult, 1)
+		go func() {
+			sctx := ctx
+			if !s.Wait {
+				// Background sinks outlive the reading they were started for.
+				sctx = context.WithoutCancel(ctx)
+			}
+			if s.Timeout > 0 {
+				var cancel context.CancelFunc
+				sctx, cancel = context.WithTimeout(sctx, s.Timeout)
+				defer cancel()
+			}
+			// A sink that ignores ctx still loses its stream when time is up.
+			stop := context.AfterFunc(sctx, func() { readers[i].CloseWithError(sctx.Err()) })
+			defer stop()
+
+			v, err := s.Consume(sctx, f, readers[i])
+			// Drop whatever the sink left unread so the stream is not held for it.
+			readers[i].Close()
+			if err != nil {
+				err = fmt.Errorf("%s sink: %w", s.Name, err)
+				if !s.Wait {
+					slog.ErrorContext(f.context(), "Error in background sink", "sink", s.Name, "err", err)
+				}
+			}
+			done[i] <- sinkResult{Value: v, Err: err}
+		}()
+	}
+
+	writeChunks(w, f.Image)
+	w.Close()
+
+	results = make(map[string]sinkResult)
+	background = make(map[string]<-chan sinkResult)
+	for i, s := range sinks {
+		if !s.Wait {
+			background[s.Name] = done[i]
+			continue
+		}
+		var timeout <-chan time.Time
+		if s.Timeout > 0 {
+			timer := time.NewTimer(time.Until(start.Add(s.Timeout)))
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case r := <-done[i]:
+			results[s.Name] = r
+		case <-timeout:
+			results[s.Name] = sinkResult{Err: fmt.Errorf("%s sink: %w", s.Name, context.DeadlineExceeded)}
+		case <-ctx.Done():
+			results[s.Name] = sinkResult{Err: fmt.Errorf("%s sink: %w", s.Name, ctx.Err())}
+		}
+	}
+	return results, background
+}
+
+// writeChunks writes b to w in pieces of at least sinkChunkSize bytes, and in
+// no more pieces than a reader may fall behind by.
+func writeChunks(w io.Writer, b []byte) {
+	size := max(sinkChunkSize, (len(b)+pipeQueueLen-1)/pipeQueueLen)
+	for len(b) > 0 {
+		n := min(size, len(b))
+		w.Write(b[:n])
+		b = b[n:]
+	}
+}
+
+// configuredSinks builds the sinks declared in config besides the vision client.
+func configuredSinks(cfgs []SinkConfig) []Sink {
+	var sinks []Sink
+	for _, c := range cfgs {
+		timeout := c.Timeout
+		if timeout == 0 {
+			timeout = defaultSinkTimeout
+		}
+		switch c.Type {
+		case sinkArchive:
+			// Readings wait for the archive only up to archiveGrace; see awaitImages.
+			sinks = append(sinks, Sink{Name: sinkArchive, Timeout: timeout,
+				Consume: func(ctx context.Context, f *Frame, r io.Reader) (any, error) {
+					start := time.Now()
+					putCtx, span := tracer.Start(ctx, "archive.Put", trace.WithAttributes(attribute.String("mqvision.store", config.Archive.Store)))
+					id, err := imageStore.Put(putCtx, r, f.MIMEType)
+					tracing.End(span, err)
+					took := time.Since(start)
+					archiveSeconds.WithLabelValues(config.Archive.Store).Observe(took.Seconds())
+					if err != nil {
+						archiveErrors.WithLabelValues(config.Archive.Store).Inc()
+						return nil, err
+					}
+					// The reading keeps its source image even if no derivative can be made.
+					return archived{URL: imageURL(id), Took: took, derivatives: startDerivatives(ctx, f, timeout)}, nil
+				}})
+		case sinkDisk:
+			sinks = append(sinks, Sink{Name: sinkDisk, Timeout: timeout, Consume: diskSink(c.Dir)})
+		case sinkForward:
+			sinks = append(sinks, Sink{Name: sinkForward, Timeout: timeout, Consume: forwardSink(c.URL, c.Token)})
+		}
+	}
+	return sinks
+}
+
+// diskSink copies each image to dir/<meter id>/<received time>.<ext>.
+func diskSink(dir string) func(context.Context, *Frame, io.Reader) (any, error) {
+	return func(ctx context.Context, f *Frame, r io.Reader) (any, error) {
+		meterDir := filepath.Join(dir, f.MeterID)
+		if err := os.MkdirAll(meterDir, 0o755); err != nil {
+			return nil, err
+		}
+		ext := strings.TrimPrefix(f.MIMEType, "image/")
+		if ext == "" || ext == "jpeg" {
+			ext = "jpg"
+		}
+		name := filepath.Join(meterDir, f.ReceivedAt.Format("20060102_150405.000")+"."+ext)
+
+		out, err := os.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(out, r); err != nil {
+			out.Close()
+			os.Remove(name)
+			return nil, err
+		}
+		return name, out.Close()
+	}
+}
+
+// forwardSink POSTs each image to url, e.g. the upload API of another instance.
+func forwardSink(url, token string) func(context.Context, *Frame, io.Reader) (any, error) {
+	return func(ctx context.Context, f *Frame, r io.Reader) (any, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.ReplaceAll(url, "{meter}", f.MeterID), r)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", f.MIMEType)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			return nil, fmt.Errorf("forward returned status %d: %s", resp.StatusCode, body)
+		}
+		return nil, nil
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFanOut(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	defer close(release)
+
+	f := &Frame{MeterID: "gas", Image: []byte("jpeg"), MIMEType: "image/jpeg", ReceivedAt: time.Now()}
+	sinks := []Sink{
+		{Name: "vision", Wait: true, Consume: func(ctx context.Context, f *Frame, r io.Reader) (any, error) {
+			b, err := io.ReadAll(r)
+			return string(b), err
+		}},
+		// A background sink that never reads must not delay the result.
+		{Name: "stuck", Consume: func(ctx context.Context, f *Frame, r io.Reader) (any, error) {
+			<-release
+			return nil, nil
+		}},
+		{Name: "slow", Wait: true, Timeout: 50 * time.Millisecond, Consume: func(ctx context.Context, f *Frame, r io.Reader) (any, error) {
+			<-release
+			return nil, nil
+		}},
+		{Name: "failing", Wait: true, Consume: func(ctx context.Context, f *Frame, r io.Reader) (any, error) {
+			return nil, errors.New("boom")
+		}},
+	}
+
+	start := time.Now()
+	results, background := fanOut(context.Background(), f, sinks)
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("fanOut took %v", d)
+	}
+
+	if r := results["vision"]; r.Err != nil || r.Value != "jpeg" {
+		t.Errorf("vision = %+v, want the whole image", r)
+	}
+	if r := results["slow"]; !errors.Is(r.Err, context.DeadlineExceeded) {
+		t.Errorf("slow = %v, want deadline exceeded", r.Err)
+	}
+	if r := results["failing"]; r.Err == nil {
+		t.Error("failing sink error lost")
+	}
+	if _, ok := results["stuck"]; ok {
+		t.Error("background sink reported a result")
+	}
+	if _, ok := background["stuck"]; !ok || len(background) != 1 {
+		t.Errorf("background results %v, want stuck only", background)
+	}
+}
+
+func TestDiskSink(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	at := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	f := &Frame{MeterID: "gas", Image: []byte("png"), MIMEType: "image/png", ReceivedAt: at}
+
+	results, _ := fanOut(context.Background(), f, []Sink{{Name: sinkDisk, Wait: true, Consume: diskSink(dir)}})
+	r := results[sinkDisk]
+	if r.Err != nil {
+		t.Fatalf("disk sink: %v", r.Err)
+	}
+	want := filepath.Join(dir, "gas", "20250102_030405.000.png")
+	if r.Value != want {
+		t.Errorf("wrote %v, want %s", r.Value, want)
+	}
+	if b, err := os.ReadFile(want); err != nil || string(b) != "png" {
+		t.Errorf("file = %q, %v", b, err)
+	}
+}
+
+func TestWriteChunksStall(t *testing.T) {
+	t.Parallel()
+
+	w, readers := SingleInMultiOutPipe(1)
+	w.SetStallTimeout(100 * time.Millisecond)
+	image := bytes.Repeat([]byte{0xff}, 4*sinkChunkSize)
+
+	// A reader that keeps reading, if slowly, is not cut off even though the
+	// whole image takes it longer than the stall timeout.
+	type result struct {
+		b   []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var got []byte
+		buf := make([]byte, sinkChunkSize)
+		for {
+			time.Sleep(40 * time.Millisecond)
+			n, err := io.ReadFull(readers[0], buf)
+			got = append(got, buf[:n]...)
+			if err == io.EOF {
+				err = nil
+			}
+			if err != nil || n == 0 {
+				done <- result{got, err}
+				return
+			}
+		}
+	}()
+	writeChunks(w, image)
+	w.Close()
+	if r := <-done; r.err != nil || len(r.b) != len(image) {
+		t.Fatalf("read %d of %d bytes: %v", len(r.b), len(image), r.err)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/suapapa/mqvision/internal/hass"
+	"github.com/suapapa/mqvision/internal/mqttdump"
+)
+
+// readingState is the JSON published on a meter's state topic.
+type readingState struct {
+	MeterID        string    `json:"meter_id"`
+	Value          float64   `json:"value"`
+	Read           string    `json:"read"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	LatencySeconds float64   `json:"latency_seconds"`
+	SrcImageURL    string    `json:"src_image_url"`
+	ThumbnailURL   string    `json:"thumbnail_url,omitempty"`
+}
+
+// ReadingPublisher mirrors accepted readings and processing errors to MQTT and
+// announces the meters to Home Assistant through MQTT discovery.
+type ReadingPublisher struct {
+	client          *mqttdump.Client
+	baseTopic       string
+	discoveryPrefix string // empty disables discovery
+	meters          []MeterConfig
+}
+
+func NewReadingPublisher(baseTopic, discoveryPrefix string, meters []MeterConfig) *ReadingPublisher {
+	return &ReadingPublisher{
+		baseTopic:       baseTopic,
+		discoveryPrefix: discoveryPrefix,
+		meters:          meters,
+	}
+}
+
+// Will is the Last Will that marks every meter unavailable when mqvision drops off.
+func (p *ReadingPublisher) Will() mqttdump.Will {
+	return mqttdump.Will{
+		Topic:    p.availabilityTopic(),
+		Payload:  hass.PayloadOffline,
+		Retained: true,
+	}
+}
+
+func (p *ReadingPublisher) availabilityTopic() string {
+	return p.baseTopic + "/status"
+}
+
+func (p *ReadingPublisher) meter(id string) hass.Meter {
+	return hass.Meter{
+		ID:                id,
+		StateTopic:        p.baseTopic + "/" + id + "/state",
+		ErrorTopic:        p.baseTopic + "/" + id + "/error",
+		AvailabilityTopic: p.availabilityTopic(),
+	}
+}
+
+// Announce publishes the discovery configs and marks mqvision online. It runs on every MQTT (re)connect.
+func (p *ReadingPublisher) Announce() {
+	if p.discoveryPrefix != "" {
+		for _, m := range p.meters {
+			msgs, err := hass.MeterDiscovery(p.discoveryPrefix, p.meter(m.ID))
+			if err != nil {
+				slog.Error("Error building Home Assistant discovery", "meter", m.ID, "err", err)
+				continue
+			}
+			for _, msg := range msgs {
+				if err := p.client.Publish(msg.Topic, msg.Payload, true); err != nil {
+					slog.Error("Error publishing Home Assistant discovery", "err", err)
+				}
+			}
+		}
+	}
+	if err := p.client.Publish(p.availabilityTopic(), []byte(hass.PayloadOnline), true); err != nil {
+		slog.Error("Error publishing availability", "err", err)
+	}
+}
+
+// PublishReading publishes an accepted reading, retained, on its meter's state topic.
+func (p *ReadingPublisher) PublishReading(value float64, updatedAt time.Time, l *Luggage) {
+	if p == nil || p.client == nil {
+		return
+	}
+	st := readingState{
+		MeterID:      l.MeterID,
+		Value:        value,
+		Read:         l.Read,
+		UpdatedAt:    updatedAt,
+		SrcImageURL:  l.SrcImageURL,
+		ThumbnailURL: l.ThumbnailURL,
+	}
+	if d, err := time.ParseDuration(l.ItTakes); err == nil {
+		st.LatencySeconds = d.Seconds()
+	}
+	payload, err := json.Marshal(st)
+	if err != nil {
+		slog.ErrorContext(l.frame.context(), "Error marshalling reading state", "err", err)
+		return
+	}
+	if err := p.client.Publish(p.meter(l.MeterID).StateTopic, payload, true); err != nil {
+		slog.ErrorContext(l.frame.context(), "Error publishing reading", "err", err)
+	}
+}
+
+// PublishError publishes the last processing error of a meter.
+func (p *ReadingPublisher) PublishError(meterID string, procErr error) {
+	if p == nil || p.client == nil {
+		return
+	}
+	if err := p.client.Publish(p.meter(meterID).ErrorTopic, []byte(procErr.Error()), true); err != nil {
+		slog.Error("Error publishing error", "meter", meterID, "err", err)
+	}
+}
@@ -2,32 +2,99 @@ package concierge
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"mime/multipart"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/suapapa/mqvision/internal/imagestore"
+)
+
+// Client defaults.
+const (
+	DefaultTTL     = 48 * time.Hour
+	defaultTimeout = 30 * time.Second
+	defaultRetries = 2
+	defaultBackoff = 500 * time.Millisecond
 )
 
+// Client stores images as concierge luggage; it is an imagestore.Store whose
+// IDs are luggage keys.
 type Client struct {
 	addr  string
 	token string
+
+	httpClient *http.Client
+	ttl        time.Duration
+	retries    int
+	backoff    time.Duration
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient replaces the default client, which gives up on a request after 30s.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithTTL sets how long concierge keeps uploaded images, in whole minutes (default 48h).
+func WithTTL(ttl time.Duration) Option {
+	return func(c *Client) {
+		if ttl > 0 {
+			c.ttl = ttl
+		}
+	}
 }
 
-func NewClient(addr string, token string) *Client {
-	return &Client{
-		addr:  addr,
-		token: token,
+// WithRetries retries a request failing with a network error, 429 or 5xx up to
+// n more times, waiting backoff, then twice as long, and so on (default 2, 500ms).
+func WithRetries(n int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = max(n, 0)
+		c.backoff = backoff
 	}
 }
 
-func (c *Client) PostImage(image io.Reader, mimeType string) (string, error) {
+func NewClient(addr string, token string, opts ...Option) *Client {
+	c := &Client{
+		addr:       strings.TrimRight(addr, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: defaultTimeout},
+		ttl:        DefaultTTL,
+		retries:    defaultRetries,
+		backoff:    defaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Put uploads the image and returns its luggage key. An empty mimeType is
+// sniffed from the image.
+func (c *Client) Put(ctx context.Context, image io.Reader, mimeType string) (string, error) {
 	// curl -X POST http://localhost:8080/api/v1/luggage \
 	// -F "file=@image.png" \
 	// -F "mime=image/png" \
 	// -F "ttl=10"
 
-	url := fmt.Sprintf("%s/api/v1/luggage", c.addr)
+	img, err := io.ReadAll(image)
+	if err != nil {
+		return "", err
+	}
+	if mimeType == "" {
+		mimeType = http.DetectContentType(img)
+	}
 
 	var body bytes.Buffer
 	writer := multipart.NewWriter(&body)
@@ -37,38 +104,34 @@ func (c *Client) PostImage(image io.Reader, mimeType string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	_, err = io.Copy(fileWriter, image)
-	if err != nil {
-		return "", err
-	}
+	fileWriter.Write(img)
 
 	// Add mime field
 	writer.WriteField("mime", mimeType)
 
-	// Add ttl field
-	writer.WriteField("ttl", fmt.Sprintf("%d", 60*24*2)) // 2 days
+	// Add ttl field, in minutes
+	writer.WriteField("ttl", fmt.Sprintf("%d", int(math.Ceil(c.ttl.Minutes()))))
 
 	err = writer.Close()
 	if err != nil {
 		return "", err
 	}
 
-	req, err := http.NewRequest("POST", url, &body)
-	if err != nil {
-		return "", err
-	}
-	req.Header.Set("Authorization", "Bearer "+c.token)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.addr+"/api/v1/luggage", bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		return req, nil
+	})
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("concierge server returned status %d: %s", resp.StatusCode, string(bodyBytes))
+		return "", statusError(resp)
 	}
 
 	var result struct {
@@ -83,5 +146,93 @@ func (c *Client) PostImage(image io.Reader, mimeType string) (string, error) {
 		return "", fmt.Errorf("concierge response did not contain a key")
 	}
 
-	return c.addr + "/api/v1/luggage/" + result.Key, nil
+	return result.Key, nil
 }
+
+// Get downloads the luggage stored under key.
+func (c *Client) Get(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, c.luggageURL(key), nil)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, "", imagestore.ErrNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, "", statusError(resp)
+	}
+	return resp.Body, resp.Header.Get("Content-Type"), nil
+}
+
+// Delete removes the luggage stored under key. A key that is already gone is not an error.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodDelete, c.luggageURL(key), nil)
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return statusError(resp)
+	}
+	return nil
+}
+
+func (c *Client) luggageURL(key string) string {
+	return c.addr + "/api/v1/luggage/" + url.PathEscape(key)
+}
+
+// do sends the request made by newReq, again after a transient failure. The
+// returned response is the last one received, whatever its status.
+func (c *Client) do(ctx context.Context, newReq func() (*http.Request, error)) (*http.Response, error) {
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+c.token)
+
+		resp, err := c.httpClient.Do(req)
+		if attempt == c.retries || !transient(resp, err) || ctx.Err() != nil {
+			if err != nil {
+				return nil, fmt.Errorf("concierge: %w", err)
+			}
+			return resp, nil
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("concierge: %w", ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// transient reports whether a failed request is worth repeating.
+func transient(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+func statusError(resp *http.Response) error {
+	bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("concierge server returned status %d: %s", resp.StatusCode, string(bodyBytes))
+}
+
+var _ imagestore.Store = (*Client)(nil)
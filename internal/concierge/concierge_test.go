@@ -0,0 +1,148 @@
+package concierge
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/suapapa/mqvision/internal/imagestore"
+)
+
+// fakeConcierge stands in for the luggage API, failing the first failures requests with 503.
+type fakeConcierge struct {
+	failures atomic.Int32
+
+	mu      sync.Mutex
+	luggage map[string]string
+	ttl     string
+	mime    string
+}
+
+func (f *fakeConcierge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if f.failures.Add(-1) >= 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := strings.TrimPrefix(r.URL.Path, "/api/v1/luggage/")
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/luggage":
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		b, _ := io.ReadAll(file)
+		f.luggage["k1"] = string(b)
+		f.ttl, f.mime = r.FormValue("ttl"), r.FormValue("mime")
+		io.WriteString(w, `{"key":"k1"}`)
+	case r.Method == http.MethodGet:
+		b, ok := f.luggage[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		io.WriteString(w, b)
+	case r.Method == http.MethodDelete:
+		if _, ok := f.luggage[key]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.luggage, key)
+	}
+}
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeConcierge{luggage: map[string]string{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	c := NewClient(srv.URL+"/", "token", WithTTL(90*time.Second), WithRetries(2, time.Millisecond))
+	ctx := context.Background()
+
+	fake.failures.Store(2)
+	key, err := c.Put(ctx, strings.NewReader("\x89PNG\r\n\x1a\n"), "")
+	if err != nil {
+		t.Fatalf("put after two 503s: %v", err)
+	}
+	if key != "k1" || fake.ttl != "2" || fake.mime != "image/png" {
+		t.Errorf("put key %q ttl %q mime %q, want k1, 2 minutes, sniffed image/png", key, fake.ttl, fake.mime)
+	}
+
+	r, mimeType, err := c.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	b, _ := io.ReadAll(r)
+	r.Close()
+	if !strings.HasPrefix(string(b), "\x89PNG") || mimeType != "image/png" {
+		t.Errorf("get %q (%s)", b, mimeType)
+	}
+
+	if err := c.Delete(ctx, key); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := c.Delete(ctx, key); err != nil {
+		t.Errorf("delete twice: %v", err)
+	}
+	if _, _, err := c.Get(ctx, key); !errors.Is(err, imagestore.ErrNotFound) {
+		t.Errorf("get deleted: %v, want ErrNotFound", err)
+	}
+
+	fake.failures.Store(3)
+	if _, err := c.Put(ctx, strings.NewReader("img"), "image/jpeg"); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("put after retries ran out: %v, want the 503", err)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	tests := []struct {
+		name string
+		c    *Client
+		ctx  time.Duration
+	}{
+		{name: "context", c: NewClient(srv.URL, "token", WithRetries(0, 0)), ctx: 50 * time.Millisecond},
+		{name: "http client", c: NewClient(srv.URL, "token", WithRetries(1, time.Millisecond),
+			WithHTTPClient(&http.Client{Timeout: 20 * time.Millisecond})), ctx: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.ctx)
+			defer cancel()
+			start := time.Now()
+			if _, err := tt.c.Put(ctx, strings.NewReader("img"), "image/jpeg"); err == nil {
+				t.Fatal("put to a hung concierge succeeded")
+			}
+			if d := time.Since(start); d > 5*time.Second {
+				t.Errorf("put gave up after %v", d)
+			}
+		})
+	}
+}
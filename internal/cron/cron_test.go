@@ -0,0 +1,76 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	t.Parallel()
+
+	from := time.Date(2025, 11, 7, 5, 13, 17, 0, time.UTC) // a Friday
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{spec: "* * * * *", want: time.Date(2025, 11, 7, 5, 14, 0, 0, time.UTC)},
+		{spec: "*/15 * * * *", want: time.Date(2025, 11, 7, 5, 15, 0, 0, time.UTC)},
+		{spec: "0 6,18 * * *", want: time.Date(2025, 11, 7, 6, 0, 0, 0, time.UTC)},
+		{spec: "30 4 * * *", want: time.Date(2025, 11, 8, 4, 30, 0, 0, time.UTC)},
+		{spec: "0 9 * * 1-5", want: time.Date(2025, 11, 7, 9, 0, 0, 0, time.UTC)},
+		{spec: "0 9 * * 7", want: time.Date(2025, 11, 9, 9, 0, 0, 0, time.UTC)},
+		{spec: "0 0 1 * *", want: time.Date(2025, 12, 1, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 29 2 *", want: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 1 * 1", want: time.Date(2025, 11, 10, 0, 0, 0, 0, time.UTC)},
+		{spec: "@daily", want: time.Date(2025, 11, 8, 0, 0, 0, 0, time.UTC)},
+		{spec: "@every 90s", want: from.Add(90 * time.Second)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			t.Parallel()
+			s, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if got := s.Next(from); !got.Equal(tt.want) {
+				t.Errorf("Next = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextHalfHourZone(t *testing.T) {
+	t.Parallel()
+
+	kolkata := time.FixedZone("IST", 5*3600+30*60)
+	s, err := Parse("0 11 * * *")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	from := time.Date(2025, 11, 7, 10, 17, 0, 0, kolkata)
+	want := time.Date(2025, 11, 7, 11, 0, 0, 0, kolkata)
+	if got := s.Next(from); !got.Equal(want) {
+		t.Errorf("Next = %v, want %v", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"@every 10ms",
+		"@every soon",
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) succeeded", spec)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Package events is an in-process publish/subscribe bus that keeps its latest
+// events so subscribers can resume after reconnecting.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind before it is dropped.
+const subscriberBuffer = 64
+
+// Event is one message on the bus. IDs start at 1 and increase by one per event.
+type Event struct {
+	ID   uint64
+	Type string
+	At   time.Time
+	Data any
+}
+
+// Bus fans events out to subscribers and keeps the latest ones.
+type Bus struct {
+	mu     sync.Mutex
+	lastID uint64
+	recent []Event // ring of the latest events, oldest at recent[head] once full
+	head   int
+	subs   map[chan Event]struct{}
+}
+
+// New returns a bus that keeps the latest keep events for resuming.
+func New(keep int) *Bus {
+	if keep < 1 {
+		keep = 1
+	}
+	return &Bus{recent: make([]Event, 0, keep), subs: make(map[chan Event]struct{})}
+}
+
+// Publish sends an event of typ carrying data to every subscriber. A subscriber
+// too far behind to take it is dropped: its channel is closed.
+func (b *Bus) Publish(typ string, data any) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	e := Event{ID: b.lastID, Type: typ, At: time.Now(), Data: data}
+	if len(b.recent) < cap(b.recent) {
+		b.recent = append(b.recent, e)
+	} else {
+		b.recent[b.head] = e
+		b.head = (b.head + 1) % len(b.recent)
+	}
+
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+	return e
+}
+
+// Subscribe returns the kept events published after lastID, then a channel of
+// the events to come. Call cancel once done. The channel is closed on cancel or
+// when the subscriber falls behind.
+func (b *Bus) Subscribe(lastID uint64) (missed []Event, ch <-chan Event, cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := len(b.recent)
+	for i := range n {
+		if e := b.recent[(b.head+i)%n]; e.ID > lastID {
+			missed = append(missed, e)
+		}
+	}
+
+	c := make(chan Event, subscriberBuffer)
+	b.subs[c] = struct{}{}
+	return missed, c, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[c]; ok {
+			delete(b.subs, c)
+			close(c)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package events
+
+import (
+	"testing"
+)
+
+func ids(events []Event) []uint64 {
+	var out []uint64
+	for _, e := range events {
+		out = append(out, e.ID)
+	}
+	return out
+}
+
+func TestSubscribeResumes(t *testing.T) {
+	t.Parallel()
+
+	b := New(3)
+	for range 5 {
+		b.Publish("reading", nil)
+	}
+	tests := []struct {
+		name   string
+		lastID uint64
+		want   []uint64
+	}{
+		{"from the start", 0, []uint64{3, 4, 5}},
+		{"kept", 3, []uint64{4, 5}},
+		{"up to date", 5, nil},
+		{"ahead", 9, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			missed, _, cancel := b.Subscribe(tt.lastID)
+			defer cancel()
+			got := ids(missed)
+			if len(got) != len(tt.want) {
+				t.Fatalf("missed %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("missed %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPublish(t *testing.T) {
+	t.Parallel()
+
+	b := New(10)
+	_, ch, cancel := b.Subscribe(0)
+	_, slow, cancelSlow := b.Subscribe(0)
+	defer cancelSlow()
+
+	e := b.Publish("mqtt", map[string]bool{"connected": true})
+	if got := <-ch; got.ID != e.ID || got.Type != "mqtt" {
+		t.Errorf("got %+v, want %+v", got, e)
+	}
+
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Error("channel open after cancel")
+	}
+	cancel()
+
+	// slow holds the first event; fill its buffer and overflow it.
+	for range subscriberBuffer {
+		b.Publish("progress", nil)
+	}
+	n := 0
+	for range slow {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Errorf("slow subscriber got %d events before being dropped, want %d", n, subscriberBuffer)
+	}
+}
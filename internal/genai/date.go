@@ -0,0 +1,44 @@
+package genai
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// meterDateLayouts are the wall-clock layouts accepted from the model, most specific first.
+var meterDateLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006/01/02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006/01/02 15:04",
+}
+
+// ParseMeterDate parses the timestamp the camera imprints on the image, as
+// transcribed by the model. The digits are the camera's wall clock, so they are
+// always interpreted in loc; an offset or zone suffix written by the model is ignored.
+func ParseMeterDate(s string, loc *time.Location) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, fmt.Errorf("empty date")
+	}
+
+	wall := s
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		wall = t.Format("2006-01-02T15:04:05")
+	}
+
+	for _, layout := range meterDateLayouts {
+		t, err := time.ParseInLocation(layout, wall, loc)
+		if err != nil {
+			continue
+		}
+		if t.Year() < 2000 {
+			return time.Time{}, fmt.Errorf("implausible date %q", s)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date %q", s)
+}
@@ -0,0 +1,42 @@
+package genai
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseMeterDate(t *testing.T) {
+	t.Parallel()
+
+	kst := time.FixedZone("KST", 9*60*60)
+	want := time.Date(2025, 11, 7, 5, 13, 17, 0, kst)
+
+	tests := []struct {
+		name    string
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "rfc3339 with offset", in: "2025-11-07T05:13:17+09:00", want: want},
+		{name: "rfc3339 with wrong offset keeps wall clock", in: "2025-11-07T05:13:17Z", want: want},
+		{name: "no offset", in: "2025-11-07T05:13:17", want: want},
+		{name: "space separated", in: " 2025-11-07 05:13:17\n", want: want},
+		{name: "slashes", in: "2025/11/07 05:13:17", want: want},
+		{name: "minutes only", in: "2025-11-07 05:13", want: time.Date(2025, 11, 7, 5, 13, 0, 0, kst)},
+		{name: "empty", in: "", wantErr: true},
+		{name: "garbage", in: "yesterday", wantErr: true},
+		{name: "camera clock never set", in: "1970-01-01T00:00:12", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseMeterDate(tt.in, kst)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMeterDate(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && !got.Equal(tt.want) {
+				t.Fatalf("ParseMeterDate(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
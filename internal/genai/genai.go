@@ -2,15 +2,42 @@ package genai
 
 import (
 	"context"
+	"errors"
 	"io"
+	"net/http"
+	"strings"
 	"time"
 )
 
-// VisionClient analyzes a JPEG gas-meter image and returns structured read/date.
+// ErrFixAmbiguous marks a failed fix_ambiguous follow-up call.
+var ErrFixAmbiguous = errors.New("guess ambiguous digits")
+
+// ReadError is a read that failed after the model was called. Result holds
+// what is known of the read: the model, the tokens billed for it and, once the
+// model answered, the exchange.
+type ReadError struct {
+	Err    error
+	Result *GasMeterReadResult
+}
+
+func (e *ReadError) Error() string { return e.Err.Error() }
+func (e *ReadError) Unwrap() error { return e.Err }
+
+// Partial returns the partial result of a read that failed with err, or nil.
+func Partial(err error) *GasMeterReadResult {
+	var re *ReadError
+	if errors.As(err, &re) {
+		return re.Result
+	}
+	return nil
+}
+
+// VisionClient analyzes a JPEG, PNG or WebP gas-meter image and returns structured read/date.
+// previous is the meter's read before this one, which fix_ambiguous settles unclear digits against.
 type VisionClient interface {
-	ReadGasGaugePic(ctx context.Context, jpgReader io.Reader) (*GasMeterReadResult, error)
+	ReadGasGaugePic(ctx context.Context, jpgReader io.Reader, previous string) (*GasMeterReadResult, error)
 	// ReadGasGaugePicFromURL runs the same analysis using an image reachable at imageURL (e.g. https).
-	ReadGasGaugePicFromURL(ctx context.Context, imageURL string) (*GasMeterReadResult, error)
+	ReadGasGaugePicFromURL(ctx context.Context, imageURL, previous string) (*GasMeterReadResult, error)
 }
 
 type GasMeterReadResult struct {
@@ -18,4 +45,46 @@ type GasMeterReadResult struct {
 	Date    string    `json:"date" bson:"date"`
 	ReadAt  time.Time `json:"read_at,omitempty" bson:"read_at,omitempty"`
 	ItTakes string    `json:"it_takes,omitempty" bson:"it_takes,omitempty"`
+	Model   string    `json:"model,omitempty" bson:"model,omitempty"`
+	Usage   Usage     `json:"usage" bson:"usage"`
+	// Ambiguous is the first answer, with ? for unclear digits, when fix_ambiguous had to settle them.
+	Ambiguous string `json:"ambiguous,omitempty" bson:"ambiguous,omitempty"`
+	// Exchange is what the model answered, before parsing.
+	Exchange *Exchange `json:"exchange,omitempty" bson:"exchange,omitempty"`
+}
+
+// Exchange records the raw model answers behind one reading.
+type Exchange struct {
+	Model string `json:"model" bson:"model"`
+	// Answer is the first-pass answer as the model returned it.
+	Answer       string `json:"answer" bson:"answer"`
+	FinishReason string `json:"finish_reason,omitempty" bson:"finish_reason,omitempty"`
+	// The fix_ambiguous follow-up, when the answer had unclear digits.
+	Ambiguous       string `json:"ambiguous,omitempty" bson:"ambiguous,omitempty"`
+	FixPrompt       string `json:"fix_prompt,omitempty" bson:"fix_prompt,omitempty"`
+	FixAnswer       string `json:"fix_answer,omitempty" bson:"fix_answer,omitempty"`
+	FixFinishReason string `json:"fix_finish_reason,omitempty" bson:"fix_finish_reason,omitempty"`
+}
+
+// Usage counts the tokens billed for the model calls behind one reading,
+// including the fix_ambiguous follow-up when it runs.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens" bson:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens" bson:"completion_tokens"`
+	Calls            int `json:"calls" bson:"calls"`
+}
+
+// Add accumulates the tokens of one more model call into u.
+func (u *Usage) Add(promptTokens, completionTokens int) {
+	u.PromptTokens += promptTokens
+	u.CompletionTokens += completionTokens
+	u.Calls++
+}
+
+// ImageMIMEType sniffs the type of an image for upload, falling back to JPEG.
+func ImageMIMEType(b []byte) string {
+	if t := http.DetectContentType(b); strings.HasPrefix(t, "image/") {
+		return t
+	}
+	return "image/jpeg"
 }
@@ -2,10 +2,11 @@
 package googleai
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
@@ -16,11 +17,16 @@ import (
 	ggenai "google.golang.org/genai"
 
 	"github.com/suapapa/mqvision/internal/genai"
+	"github.com/suapapa/mqvision/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+var tracer = otel.Tracer("github.com/suapapa/mqvision/internal/genai/googleai")
+
 // const geminiModel = "googleai/gemini-2.5-flash-lite"
 
-// Client uploads JPEG input through the GenAI Files API and runs structured generation with Genkit.
+// Client uploads image input through the GenAI Files API and runs structured generation with Genkit.
 type Client struct {
 	g *genkit.Genkit
 	c *ggenai.Client
@@ -28,10 +34,8 @@ type Client struct {
 	model        string
 	systemPrompt string
 	promptForImg string
-	fixSystem   string
-	fixUser     string
-
-	lastRead string
+	fixSystem    string
+	fixUser      string
 }
 
 // NewClient initializes Genkit with the Google AI plugin and an API-key-backed GenAI HTTP client.
@@ -61,8 +65,8 @@ func NewClient(ctx context.Context,
 		model:        model,
 		systemPrompt: systemPrompt,
 		promptForImg: prompt,
-		fixSystem:   fixSystem,
-		fixUser:     fixUser,
+		fixSystem:    fixSystem,
+		fixUser:      fixUser,
 	}, nil
 }
 
@@ -70,7 +74,11 @@ func NewClient(ctx context.Context,
 func (c *Client) ReadGasGaugePic(
 	ctx context.Context,
 	jpgReader io.Reader,
-) (*genai.GasMeterReadResult, error) {
+	previous string,
+) (res *genai.GasMeterReadResult, err error) {
+	ctx, span := tracer.Start(ctx, "ReadGasGaugePic")
+	span.SetAttributes(attribute.String("gen_ai.request.model", c.model))
+	defer func() { tracing.End(span, err) }()
 
 	start := time.Now()
 
@@ -82,9 +90,15 @@ func (c *Client) ReadGasGaugePic(
 	// 	return nil, fmt.Errorf("failed to upload: %v", err)
 	// }
 
+	imgBytes, err := io.ReadAll(jpgReader)
+	if err != nil {
+		return nil, fmt.Errorf("read image: %w", err)
+	}
+	mimeType := genai.ImageMIMEType(imgBytes)
+
 	// Initialize Genkit
-	file, err := c.c.Files.Upload(ctx, jpgReader, &ggenai.UploadFileConfig{
-		MIMEType:    "image/jpeg",
+	file, err := c.c.Files.Upload(ctx, bytes.NewReader(imgBytes), &ggenai.UploadFileConfig{
+		MIMEType:    mimeType,
 		DisplayName: "Gas Meter Image",
 	})
 	if err != nil {
@@ -101,7 +115,15 @@ func (c *Client) ReadGasGaugePic(
 	// Use Files API URI directly with Genkit (now supported!)
 	// fmt.Println("Analyzing image with Genkit using Files API URI...")
 
-	out, _, err := genkit.GenerateData[genai.GasMeterReadResult](ctx, c.g,
+	// Errors after a billed call carry the usage and the answers so far in a [genai.ReadError].
+	var usage genai.Usage
+	var ex *genai.Exchange
+	defer func() {
+		if err != nil && usage.Calls > 0 {
+			err = &genai.ReadError{Err: err, Result: &genai.GasMeterReadResult{Model: c.model, Usage: usage, Exchange: ex}}
+		}
+	}()
+	out, resp, err := genkit.GenerateData[genai.GasMeterReadResult](ctx, c.g,
 		ai.WithModelName(c.model),
 		ai.WithMessages(
 			ai.NewSystemMessage(
@@ -110,7 +132,7 @@ func (c *Client) ReadGasGaugePic(
 				ai.NewTextPart(c.systemPrompt),
 			),
 			ai.NewUserMessage(
-				ai.NewMediaPart("image/jpeg", file.URI),
+				ai.NewMediaPart(mimeType, file.URI),
 				// ai.NewTextPart("Process the image and extract the reading and date."),
 				ai.NewTextPart(c.promptForImg),
 			),
@@ -120,22 +142,28 @@ func (c *Client) ReadGasGaugePic(
 			Temperature: float32Ptr(0.1),
 		}),
 	)
+	addUsage(&usage, resp) // also billed when the answer does not parse
+	if resp != nil {
+		ex = &genai.Exchange{Model: c.model, Answer: resp.Text(), FinishReason: string(resp.FinishReason)}
+	}
 	if err != nil {
 		return nil, fmt.Errorf("analyze image: %w", err)
 	}
+	out.Exchange = ex
 
 	if strings.Contains(out.Read, "?") {
-		log.Printf("Ambiguous digits found in the reading: %s", out.Read)
-		out.Read, err = c.guessAmbiguousDigits(ctx, out.Read)
+		slog.InfoContext(ctx, "Ambiguous digits found in the reading", "read", out.Read)
+		out.Ambiguous = out.Read
+		out.Read, err = c.guessAmbiguousDigits(ctx, out.Read, previous, &usage, ex)
 		if err != nil {
-			return nil, fmt.Errorf("guess ambiguous digits: %w", err)
+			return nil, fmt.Errorf("%w: %w", genai.ErrFixAmbiguous, err)
 		}
 	}
 
 	out.ItTakes = time.Since(start).String()
 	out.ReadAt = time.Now()
-
-	c.lastRead = out.Read
+	out.Model = c.model
+	out.Usage = usage
 
 	return out, nil
 }
@@ -143,8 +171,12 @@ func (c *Client) ReadGasGaugePic(
 // ReadGasGaugePicFromURL downloads the JPEG at imageURL and delegates to ReadGasGaugePic.
 func (c *Client) ReadGasGaugePicFromURL(
 	ctx context.Context,
-	imageURL string,
-) (*genai.GasMeterReadResult, error) {
+	imageURL, previous string,
+) (res *genai.GasMeterReadResult, err error) {
+	ctx, span := tracer.Start(ctx, "ReadGasGaugePicFromURL")
+	span.SetAttributes(attribute.String("gen_ai.request.model", c.model))
+	defer func() { tracing.End(span, err) }()
+
 	u := strings.TrimSpace(imageURL)
 	if u == "" {
 		return nil, fmt.Errorf("empty image URL")
@@ -161,19 +193,29 @@ func (c *Client) ReadGasGaugePicFromURL(
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("fetch image: status %s", resp.Status)
 	}
-	return c.ReadGasGaugePic(ctx, resp.Body)
+	return c.ReadGasGaugePic(ctx, resp.Body, previous)
 }
 
+// guessAmbiguousDigits asks the model to settle the ? in ambiguousValueString
+// next to the previous read and records the follow-up in ex.
 func (c *Client) guessAmbiguousDigits(
 	ctx context.Context,
 	ambiguousValueString string,
-) (string, error) {
+	previous string,
+	usage *genai.Usage,
+	ex *genai.Exchange,
+) (fixed string, err error) {
+	ctx, span := tracer.Start(ctx, "guessAmbiguousDigits")
+	span.SetAttributes(attribute.String("mqvision.ambiguous", ambiguousValueString))
+	defer func() { tracing.End(span, err) }()
+
 	if !genai.ContainsOnly(ambiguousValueString, ".?0123456789") {
 		return "", fmt.Errorf("ambiguous value string %q is not valid", ambiguousValueString)
 	}
 
 	userPrompt := strings.ReplaceAll(c.fixUser, "{{ambiguous}}", ambiguousValueString)
-	userPrompt = strings.ReplaceAll(userPrompt, "{{previous}}", c.lastRead)
+	userPrompt = strings.ReplaceAll(userPrompt, "{{previous}}", previous)
+	ex.Ambiguous, ex.FixPrompt = ambiguousValueString, userPrompt
 
 	resp, err := genkit.Generate(ctx, c.g,
 		ai.WithModelName(c.model),
@@ -190,13 +232,23 @@ func (c *Client) guessAmbiguousDigits(
 			Temperature: float32Ptr(0.1),
 		}),
 	)
+	addUsage(usage, resp)
 	if err != nil {
 		return "", fmt.Errorf("generate disambiguation: %w", err)
 	}
+	ex.FixAnswer, ex.FixFinishReason = resp.Text(), string(resp.FinishReason)
 
 	return resp.Text(), nil
 }
 
+// addUsage adds the tokens reported in resp, if any, to usage.
+func addUsage(usage *genai.Usage, resp *ai.ModelResponse) {
+	if resp == nil || resp.Usage == nil {
+		return
+	}
+	usage.Add(resp.Usage.InputTokens, resp.Usage.OutputTokens)
+}
+
 func float32Ptr(v float32) *float32 {
 	return &v
 }
@@ -8,14 +8,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/suapapa/mqvision/internal/genai"
+	"github.com/suapapa/mqvision/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+var tracer = otel.Tracer("github.com/suapapa/mqvision/internal/genai/openaicompat")
+
 // Client calls an OpenAI-compatible HTTP API for vision + structured JSON extraction.
 type Client struct {
 	httpClient   *http.Client
@@ -24,9 +29,8 @@ type Client struct {
 	model        string
 	systemPrompt string
 	promptForImg string
-	fixSystem   string
-	fixUser     string
-	lastRead     string
+	fixSystem    string
+	fixUser      string
 }
 
 // NewClient constructs a Client. baseURL should be the API root (e.g. https://host/v1) without a trailing slash.
@@ -44,8 +48,8 @@ func NewClient(
 		model:        model,
 		systemPrompt: systemPrompt,
 		promptForImg: promptForImg,
-		fixSystem:   fixSystem,
-		fixUser:     fixUser,
+		fixSystem:    fixSystem,
+		fixUser:      fixUser,
 	}
 }
 
@@ -53,20 +57,29 @@ func NewClient(
 // imageURL must be reachable by the API provider (typically https).
 func (c *Client) ReadGasGaugePicFromURL(
 	ctx context.Context,
-	imageURL string,
-) (*genai.GasMeterReadResult, error) {
+	imageURL, previous string,
+) (out *genai.GasMeterReadResult, err error) {
+	ctx, span := tracer.Start(ctx, "ReadGasGaugePicFromURL")
+	span.SetAttributes(attribute.String("gen_ai.request.model", c.model))
+	defer func() { tracing.End(span, err) }()
+
 	u := strings.TrimSpace(imageURL)
 	if u == "" {
 		return nil, fmt.Errorf("empty image URL")
 	}
-	return c.readGasGaugeFromVisionURL(ctx, u)
+	return c.readGasGaugeFromVisionURL(ctx, u, previous)
 }
 
 // ReadGasGaugePic implements [genai.VisionClient].
 func (c *Client) ReadGasGaugePic(
 	ctx context.Context,
 	jpgReader io.Reader,
-) (*genai.GasMeterReadResult, error) {
+	previous string,
+) (out *genai.GasMeterReadResult, err error) {
+	ctx, span := tracer.Start(ctx, "ReadGasGaugePic")
+	span.SetAttributes(attribute.String("gen_ai.request.model", c.model))
+	defer func() { tracing.End(span, err) }()
+
 	jpgBytes, err := io.ReadAll(jpgReader)
 	if err != nil {
 		return nil, fmt.Errorf("read image: %w", err)
@@ -74,44 +87,56 @@ func (c *Client) ReadGasGaugePic(
 	if len(jpgBytes) == 0 {
 		return nil, fmt.Errorf("empty image")
 	}
-	dataURL := "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(jpgBytes)
-	return c.readGasGaugeFromVisionURL(ctx, dataURL)
+	dataURL := "data:" + genai.ImageMIMEType(jpgBytes) + ";base64," + base64.StdEncoding.EncodeToString(jpgBytes)
+	return c.readGasGaugeFromVisionURL(ctx, dataURL, previous)
 }
 
 // readGasGaugeFromVisionURL sends imageURL as an OpenAI-style image_url (data URI or https URL).
-func (c *Client) readGasGaugeFromVisionURL(ctx context.Context, imageURL string) (*genai.GasMeterReadResult, error) {
+// Errors after a billed call carry the usage and the answers so far in a [genai.ReadError].
+func (c *Client) readGasGaugeFromVisionURL(ctx context.Context, imageURL, previous string) (out *genai.GasMeterReadResult, err error) {
 	start := time.Now()
 
-	content, err := c.chatCompletion(ctx, []chatMessage{
+	var usage genai.Usage
+	var ex *genai.Exchange
+	defer func() {
+		if err != nil && usage.Calls > 0 {
+			err = &genai.ReadError{Err: err, Result: &genai.GasMeterReadResult{Model: c.model, Usage: usage, Exchange: ex}}
+		}
+	}()
+	content, finishReason, err := c.chatCompletion(ctx, []chatMessage{
 		{Role: "system", Content: c.systemPrompt},
 		{Role: "user", Content: []contentPart{
 			{Type: "text", Text: c.promptForImg},
 			{Type: "image_url", ImageURL: &imageURLPart{URL: imageURL}},
 		}},
-	}, 0.1)
+	}, 0.1, &usage)
 	if err != nil {
 		return nil, err
 	}
 
-	out, err := parseGasMeterJSON(content)
+	ex = &genai.Exchange{Model: c.model, Answer: content, FinishReason: finishReason}
+	out, err = parseGasMeterJSON(content)
 	if err != nil {
 		return nil, fmt.Errorf("parse model JSON: %w", err)
 	}
+	out.Exchange = ex
 
 	out.Read = genai.NormalizeReading(out.Read)
 
 	if strings.Contains(out.Read, "?") {
-		log.Printf("Ambiguous digits found in the reading: %s", out.Read)
-		fixed, err := c.guessAmbiguousDigits(ctx, out.Read)
+		slog.InfoContext(ctx, "Ambiguous digits found in the reading", "read", out.Read)
+		out.Ambiguous = out.Read
+		fixed, err := c.guessAmbiguousDigits(ctx, out.Read, previous, &usage, ex)
 		if err != nil {
-			return nil, fmt.Errorf("guess ambiguous digits: %w", err)
+			return nil, fmt.Errorf("%w: %w", genai.ErrFixAmbiguous, err)
 		}
 		out.Read = genai.NormalizeReading(fixed)
 	}
 
 	out.ItTakes = time.Since(start).String()
 	out.ReadAt = time.Now()
-	c.lastRead = out.Read
+	out.Model = c.model
+	out.Usage = usage
 	return out, nil
 }
 
@@ -141,13 +166,20 @@ type chatCompletionResponse struct {
 		Message struct {
 			Content string `json:"content"`
 		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
 	Error *struct {
 		Message string `json:"message"`
 	} `json:"error"`
 }
 
-func (c *Client) chatCompletion(ctx context.Context, messages []chatMessage, temperature float64) (string, error) {
+// chatCompletion sends messages and returns the content of the first choice as
+// the model returned it and why it stopped. Tokens reported by the API are added to usage.
+func (c *Client) chatCompletion(ctx context.Context, messages []chatMessage, temperature float64, usage *genai.Usage) (content, finishReason string, err error) {
 	body := chatCompletionRequest{
 		Model:       c.model,
 		Messages:    messages,
@@ -155,13 +187,13 @@ func (c *Client) chatCompletion(ctx context.Context, messages []chatMessage, tem
 	}
 	raw, err := json.Marshal(body)
 	if err != nil {
-		return "", fmt.Errorf("marshal request: %w", err)
+		return "", "", fmt.Errorf("marshal request: %w", err)
 	}
 
 	url := c.baseURL + "/chat/completions"
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(raw))
 	if err != nil {
-		return "", fmt.Errorf("build request: %w", err)
+		return "", "", fmt.Errorf("build request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 	if c.apiKey != "" {
@@ -170,37 +202,40 @@ func (c *Client) chatCompletion(ctx context.Context, messages []chatMessage, tem
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("http: %w", err)
+		return "", "", fmt.Errorf("http: %w", err)
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("read response: %w", err)
+		return "", "", fmt.Errorf("read response: %w", err)
 	}
 
 	var parsed chatCompletionResponse
 	decodeErr := json.Unmarshal(respBody, &parsed)
 	if decodeErr != nil {
 		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			return "", fmt.Errorf("http status %d: %w; body: %s", resp.StatusCode, decodeErr, truncate(string(respBody), 500))
+			return "", "", fmt.Errorf("http status %d: %w; body: %s", resp.StatusCode, decodeErr, truncate(string(respBody), 500))
 		}
-		return "", fmt.Errorf("decode response (status %d): %w; body: %s", resp.StatusCode, decodeErr, truncate(string(respBody), 500))
+		return "", "", fmt.Errorf("decode response (status %d): %w; body: %s", resp.StatusCode, decodeErr, truncate(string(respBody), 500))
+	}
+	if parsed.Usage != nil {
+		usage.Add(parsed.Usage.PromptTokens, parsed.Usage.CompletionTokens)
 	}
 	if parsed.Error != nil && parsed.Error.Message != "" {
-		return "", fmt.Errorf("api error: %s", parsed.Error.Message)
+		return "", "", fmt.Errorf("api error: %s", parsed.Error.Message)
 	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return "", fmt.Errorf("http status %d: %s", resp.StatusCode, truncate(string(respBody), 500))
+		return "", "", fmt.Errorf("http status %d: %s", resp.StatusCode, truncate(string(respBody), 500))
 	}
 	if len(parsed.Choices) == 0 {
-		return "", fmt.Errorf("no choices in response: %s", truncate(string(respBody), 500))
+		return "", "", fmt.Errorf("no choices in response: %s", truncate(string(respBody), 500))
 	}
-	content := strings.TrimSpace(parsed.Choices[0].Message.Content)
-	if content == "" {
-		return "", fmt.Errorf("empty message content")
+	choice := parsed.Choices[0]
+	if strings.TrimSpace(choice.Message.Content) == "" {
+		return "", "", fmt.Errorf("empty message content")
 	}
-	return content, nil
+	return choice.Message.Content, choice.FinishReason, nil
 }
 
 func truncate(s string, max int) string {
@@ -262,18 +297,26 @@ func stripMarkdownFence(s string) string {
 	return s
 }
 
-func (c *Client) guessAmbiguousDigits(ctx context.Context, ambiguousValueString string) (string, error) {
+// guessAmbiguousDigits asks the model to settle the ? in ambiguousValueString
+// next to the previous read and records the follow-up in ex.
+func (c *Client) guessAmbiguousDigits(ctx context.Context, ambiguousValueString, previous string, usage *genai.Usage, ex *genai.Exchange) (fixed string, err error) {
+	ctx, span := tracer.Start(ctx, "guessAmbiguousDigits")
+	span.SetAttributes(attribute.String("mqvision.ambiguous", ambiguousValueString))
+	defer func() { tracing.End(span, err) }()
+
 	if !genai.ContainsOnly(ambiguousValueString, ".?0123456789") {
 		return "", fmt.Errorf("ambiguous value string %q is not valid", ambiguousValueString)
 	}
 	userPrompt := strings.ReplaceAll(c.fixUser, "{{ambiguous}}", ambiguousValueString)
-	userPrompt = strings.ReplaceAll(userPrompt, "{{previous}}", c.lastRead)
-	content, err := c.chatCompletion(ctx, []chatMessage{
+	userPrompt = strings.ReplaceAll(userPrompt, "{{previous}}", previous)
+	ex.Ambiguous, ex.FixPrompt = ambiguousValueString, userPrompt
+	content, finishReason, err := c.chatCompletion(ctx, []chatMessage{
 		{Role: "system", Content: c.fixSystem},
 		{Role: "user", Content: userPrompt},
-	}, 0.1)
+	}, 0.1, usage)
 	if err != nil {
 		return "", err
 	}
+	ex.FixAnswer, ex.FixFinishReason = content, finishReason
 	return strings.TrimSpace(content), nil
 }
@@ -1,8 +1,14 @@
 package openaicompat
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"github.com/suapapa/mqvision/internal/genai"
 )
 
 func TestExtractJSONObject(t *testing.T) {
@@ -56,3 +62,89 @@ func TestStripMarkdownFence(t *testing.T) {
 		t.Fatalf("stripMarkdownFence: %q", got)
 	}
 }
+
+func TestReadGasGaugePicUsage(t *testing.T) {
+	t.Parallel()
+
+	answers := []string{
+		`{"read":"02924.45?","date":"2025-11-07T05:13:17+09:00"}`,
+		"02924.457",
+	}
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		content := answers[calls]
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"choices": []any{
+				map[string]any{"message": map[string]any{"content": content}, "finish_reason": "stop"},
+			},
+			"usage": map[string]any{"prompt_tokens": 1000, "completion_tokens": 20},
+		})
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "key", "test-model", "sys", "user", "fix-sys", "fix {{ambiguous}} {{previous}}")
+	res, err := c.ReadGasGaugePic(context.Background(), strings.NewReader("jpeg bytes"), "02924.401")
+	if err != nil {
+		t.Fatalf("ReadGasGaugePic: %v", err)
+	}
+	if res.Read != "02924.457" {
+		t.Fatalf("Read = %q", res.Read)
+	}
+	if res.Model != "test-model" {
+		t.Fatalf("Model = %q", res.Model)
+	}
+	want := genai.Usage{PromptTokens: 2000, CompletionTokens: 40, Calls: 2}
+	if res.Usage != want {
+		t.Fatalf("Usage = %+v, want %+v", res.Usage, want)
+	}
+	wantEx := genai.Exchange{
+		Model:           "test-model",
+		Answer:          answers[0],
+		FinishReason:    "stop",
+		Ambiguous:       "02924.45?",
+		FixPrompt:       "fix 02924.45? 02924.401",
+		FixAnswer:       answers[1],
+		FixFinishReason: "stop",
+	}
+	if res.Exchange == nil || *res.Exchange != wantEx {
+		t.Fatalf("Exchange = %+v, want %+v", res.Exchange, wantEx)
+	}
+}
+
+func TestReadGasGaugePicFailureUsage(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"choices": []any{
+				map[string]any{"message": map[string]any{"content": "I cannot read this meter."}, "finish_reason": "stop"},
+			},
+			"usage": map[string]any{"prompt_tokens": 1000, "completion_tokens": 20},
+		})
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "key", "test-model", "sys", "user", "fix-sys", "fix {{ambiguous}}")
+	_, err := c.ReadGasGaugePic(context.Background(), strings.NewReader("jpeg bytes"), "")
+	if err == nil {
+		t.Fatal("ReadGasGaugePic succeeded")
+	}
+	res := genai.Partial(err)
+	if res == nil {
+		t.Fatalf("error %v carries no partial result", err)
+	}
+	want := genai.Usage{PromptTokens: 1000, CompletionTokens: 20, Calls: 1}
+	if res.Model != "test-model" || res.Usage != want {
+		t.Fatalf("partial = %q %+v, want test-model %+v", res.Model, res.Usage, want)
+	}
+	if res.Exchange == nil || res.Exchange.Answer != "I cannot read this meter." {
+		t.Fatalf("Exchange = %+v, want the unparsed answer", res.Exchange)
+	}
+}
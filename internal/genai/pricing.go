@@ -0,0 +1,20 @@
+package genai
+
+// Price is what a model charges per one million tokens.
+type Price struct {
+	Prompt     float64 `yaml:"prompt"`
+	Completion float64 `yaml:"completion"`
+}
+
+// PriceTable maps model names, as sent to the API, to their prices.
+type PriceTable map[string]Price
+
+// Cost returns the price of u for model. ok is false when the model is not in the table.
+func (t PriceTable) Cost(model string, u Usage) (cost float64, ok bool) {
+	p, ok := t[model]
+	if !ok {
+		return 0, false
+	}
+	cost = (float64(u.PromptTokens)*p.Prompt + float64(u.CompletionTokens)*p.Completion) / 1e6
+	return cost, true
+}
@@ -0,0 +1,49 @@
+package genai
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPriceTableCost(t *testing.T) {
+	t.Parallel()
+
+	table := PriceTable{
+		"gpt-4o-mini": {Prompt: 0.15, Completion: 0.60},
+	}
+
+	tests := []struct {
+		name   string
+		model  string
+		usage  Usage
+		want   float64
+		wantOK bool
+	}{
+		{name: "known model", model: "gpt-4o-mini", usage: Usage{PromptTokens: 1000, CompletionTokens: 100}, want: 0.00021, wantOK: true},
+		{name: "no tokens", model: "gpt-4o-mini", usage: Usage{}, want: 0, wantOK: true},
+		{name: "unknown model", model: "gpt-5", usage: Usage{PromptTokens: 1000}, want: 0, wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, ok := table.Cost(tt.model, tt.usage)
+			if ok != tt.wantOK {
+				t.Fatalf("Cost() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if math.Abs(got-tt.want) > 1e-12 {
+				t.Fatalf("Cost() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsageAdd(t *testing.T) {
+	t.Parallel()
+
+	var u Usage
+	u.Add(100, 10)
+	u.Add(50, 5)
+	if u.PromptTokens != 150 || u.CompletionTokens != 15 || u.Calls != 2 {
+		t.Fatalf("Usage = %+v", u)
+	}
+}
@@ -0,0 +1,123 @@
+// Package hass builds Home Assistant MQTT discovery messages for mqvision's meters.
+// See https://www.home-assistant.io/integrations/sensor.mqtt/.
+package hass
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Availability payloads published on the availability topic.
+const (
+	PayloadOnline  = "online"
+	PayloadOffline = "offline"
+)
+
+// Device groups the entities of one meter in Home Assistant.
+type Device struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer,omitempty"`
+	Model        string   `json:"model,omitempty"`
+}
+
+// Sensor is the discovery payload of one MQTT sensor entity.
+type Sensor struct {
+	Name                string `json:"name"`
+	UniqueID            string `json:"unique_id"`
+	StateTopic          string `json:"state_topic"`
+	ValueTemplate       string `json:"value_template,omitempty"`
+	JSONAttributesTopic string `json:"json_attributes_topic,omitempty"`
+	DeviceClass         string `json:"device_class,omitempty"`
+	StateClass          string `json:"state_class,omitempty"`
+	UnitOfMeasurement   string `json:"unit_of_measurement,omitempty"`
+	EntityCategory      string `json:"entity_category,omitempty"`
+	Icon                string `json:"icon,omitempty"`
+	AvailabilityTopic   string `json:"availability_topic"`
+	PayloadAvailable    string `json:"payload_available"`
+	PayloadNotAvailable string `json:"payload_not_available"`
+	Device              Device `json:"device"`
+}
+
+// Message is a discovery config to publish, retained, on Topic.
+type Message struct {
+	Topic   string
+	Payload []byte
+}
+
+// Meter describes where a meter's readings are published.
+type Meter struct {
+	ID string
+	// StateTopic carries the JSON of each accepted reading.
+	StateTopic string
+	// ErrorTopic carries the last processing error as plain text.
+	ErrorTopic string
+	// AvailabilityTopic carries PayloadOnline or PayloadOffline.
+	AvailabilityTopic string
+}
+
+// MeterDiscovery returns the discovery messages for the gas total of m and its
+// diagnostic sensors (read latency, image URL and last error).
+func MeterDiscovery(prefix string, m Meter) ([]Message, error) {
+	node := "mqvision_" + m.ID
+	device := Device{
+		Identifiers:  []string{node},
+		Name:         "MQVision " + m.ID,
+		Manufacturer: "suapapa",
+		Model:        "mqvision",
+	}
+	base := func(object, name string) Sensor {
+		return Sensor{
+			Name:                name,
+			UniqueID:            node + "_" + object,
+			StateTopic:          m.StateTopic,
+			AvailabilityTopic:   m.AvailabilityTopic,
+			PayloadAvailable:    PayloadOnline,
+			PayloadNotAvailable: PayloadOffline,
+			Device:              device,
+		}
+	}
+
+	gas := base("gas", "Gas")
+	gas.ValueTemplate = "{{ value_json.value }}"
+	gas.JSONAttributesTopic = m.StateTopic
+	gas.DeviceClass = "gas"
+	gas.StateClass = "total_increasing"
+	gas.UnitOfMeasurement = "m³"
+
+	latency := base("latency", "Read latency")
+	latency.ValueTemplate = "{{ value_json.latency_seconds }}"
+	latency.DeviceClass = "duration"
+	latency.StateClass = "measurement"
+	latency.UnitOfMeasurement = "s"
+	latency.EntityCategory = "diagnostic"
+
+	image := base("image_url", "Source image")
+	image.ValueTemplate = "{{ value_json.src_image_url }}"
+	image.EntityCategory = "diagnostic"
+	image.Icon = "mdi:camera"
+
+	lastErr := base("last_error", "Last error")
+	lastErr.StateTopic = m.ErrorTopic
+	lastErr.EntityCategory = "diagnostic"
+	lastErr.Icon = "mdi:alert-circle-outline"
+
+	sensors := map[string]Sensor{
+		"gas":        gas,
+		"latency":    latency,
+		"image_url":  image,
+		"last_error": lastErr,
+	}
+	var msgs []Message
+	for _, object := range []string{"gas", "latency", "image_url", "last_error"} {
+		payload, err := json.Marshal(sensors[object])
+		if err != nil {
+			return nil, fmt.Errorf("marshal %s discovery: %w", object, err)
+		}
+		msgs = append(msgs, Message{
+			Topic:   fmt.Sprintf("%s/sensor/%s/%s/config", prefix, node, object),
+			Payload: payload,
+		})
+	}
+	return msgs, nil
+}
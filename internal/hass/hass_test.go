@@ -0,0 +1,59 @@
+package hass
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMeterDiscovery(t *testing.T) {
+	t.Parallel()
+
+	m := Meter{
+		ID:                "gas",
+		StateTopic:        "mqvision/gas/state",
+		ErrorTopic:        "mqvision/gas/error",
+		AvailabilityTopic: "mqvision/status",
+	}
+	msgs, err := MeterDiscovery("homeassistant", m)
+	if err != nil {
+		t.Fatalf("MeterDiscovery: %v", err)
+	}
+	if len(msgs) != 4 {
+		t.Fatalf("got %d messages, want 4", len(msgs))
+	}
+
+	if msgs[0].Topic != "homeassistant/sensor/mqvision_gas/gas/config" {
+		t.Fatalf("topic = %q", msgs[0].Topic)
+	}
+	var gas Sensor
+	if err := json.Unmarshal(msgs[0].Payload, &gas); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if gas.DeviceClass != "gas" || gas.StateClass != "total_increasing" || gas.UnitOfMeasurement != "m³" {
+		t.Fatalf("gas sensor = %+v", gas)
+	}
+	if gas.StateTopic != m.StateTopic || gas.AvailabilityTopic != m.AvailabilityTopic {
+		t.Fatalf("gas sensor topics = %+v", gas)
+	}
+
+	seen := map[string]bool{}
+	for _, msg := range msgs[1:] {
+		var s Sensor
+		if err := json.Unmarshal(msg.Payload, &s); err != nil {
+			t.Fatalf("unmarshal %s: %v", msg.Topic, err)
+		}
+		if s.EntityCategory != "diagnostic" {
+			t.Errorf("%s: entity_category = %q, want diagnostic", msg.Topic, s.EntityCategory)
+		}
+		if seen[s.UniqueID] {
+			t.Errorf("duplicate unique_id %q", s.UniqueID)
+		}
+		seen[s.UniqueID] = true
+	}
+
+	var lastErr Sensor
+	_ = json.Unmarshal(msgs[3].Payload, &lastErr)
+	if lastErr.StateTopic != m.ErrorTopic {
+		t.Errorf("last error state_topic = %q, want %q", lastErr.StateTopic, m.ErrorTopic)
+	}
+}
@@ -0,0 +1,129 @@
+package imagestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FS stores images content-addressed under Dir, in subdirectories named after
+// the first two hex digits of their hash.
+type FS struct {
+	Dir string
+	// Retention removes images not stored again for this long; zero keeps them forever.
+	Retention time.Duration
+}
+
+func (s FS) path(id string) string {
+	return filepath.Join(s.Dir, id[:2], id)
+}
+
+// Put writes the image unless an identical one is stored, in which case its
+// retention starts over.
+func (s FS) Put(ctx context.Context, r io.Reader, mimeType string) (string, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	id := contentID(b, mimeType)
+	p := s.path(id)
+
+	now := time.Now()
+	if err := os.Chtimes(p, now, now); err == nil {
+		return id, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return "", err
+	}
+	// Write aside and rename, so a reader never sees half an image.
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".put-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func (s FS) Get(ctx context.Context, id string) (io.ReadCloser, string, error) {
+	mimeType, ok := mimeTypeOf(id)
+	if !ok {
+		return nil, "", ErrNotFound
+	}
+	f, err := os.Open(s.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, "", ErrNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return f, mimeType, nil
+}
+
+// Prune removes the images older than Retention and returns how many it removed.
+func (s FS) Prune(now time.Time) (int, error) {
+	if s.Retention <= 0 {
+		return 0, nil
+	}
+	cutoff := now.Add(-s.Retention)
+
+	var removed int
+	err := filepath.WalkDir(s.Dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if _, ok := mimeTypeOf(d.Name()); !ok {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if info.ModTime().Before(cutoff) {
+			if err := os.Remove(p); err != nil {
+				return fmt.Errorf("remove expired image: %w", err)
+			}
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// Run prunes expired images every interval until ctx is done.
+func (s FS) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := s.Prune(time.Now()); err != nil {
+			slog.Error("Error pruning images", "dir", s.Dir, "err", err)
+		} else if n > 0 {
+			slog.Info("Pruned expired images", "dir", s.Dir, "count", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
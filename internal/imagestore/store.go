@@ -0,0 +1,58 @@
+// Package imagestore archives the camera images behind each reading.
+package imagestore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// ErrNotFound is returned for an image that was never stored or has expired.
+var ErrNotFound = errors.New("image not found")
+
+// Store keeps images under an ID of its choosing.
+type Store interface {
+	// Put stores the image and returns its ID.
+	Put(ctx context.Context, r io.Reader, mimeType string) (string, error)
+	// Get opens the image stored under id and returns its MIME type.
+	Get(ctx context.Context, id string) (io.ReadCloser, string, error)
+}
+
+// extensions maps the image types cameras send to the extension ending an ID.
+var extensions = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/webp": "webp",
+}
+
+// contentIDPattern matches the IDs made by contentID: the SHA-256 of the image and its extension.
+var contentIDPattern = regexp.MustCompile(`^[0-9a-f]{64}\.(jpg|png|webp)$`)
+
+// contentID names an image after its content, so storing it twice keeps one copy.
+func contentID(b []byte, mimeType string) string {
+	ext, ok := extensions[mimeType]
+	if !ok {
+		ext = "jpg"
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]) + "." + ext
+}
+
+// mimeTypeOf returns the type of a valid content ID, or false for anything else,
+// including IDs that would escape the store.
+func mimeTypeOf(id string) (string, bool) {
+	if !contentIDPattern.MatchString(id) {
+		return "", false
+	}
+	ext := id[strings.LastIndexByte(id, '.')+1:]
+	for t, e := range extensions {
+		if e == ext {
+			return t, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,60 @@
+package logctx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	logger, err := New(&buf, FormatJSON, slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "image")
+	defer span.End()
+	ctx = With(ctx, slog.String("meter", "gas"))
+	ctx = With(ctx, slog.String("image", "abc"))
+	base := With(context.Background(), slog.String("meter", "water"))
+
+	logger.DebugContext(ctx, "hidden")
+	logger.InfoContext(ctx, "read", "value", "123.4")
+	logger.Info("plain")
+	logger.InfoContext(base, "other")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("logged %d records, want 3:\n%s", len(lines), buf.String())
+	}
+	tests := []struct {
+		line int
+		want map[string]any
+	}{
+		{0, map[string]any{"msg": "read", "value": "123.4", "meter": "gas", "image": "abc", "trace_id": span.SpanContext().TraceID().String()}},
+		{1, map[string]any{"msg": "plain", "meter": nil, "trace_id": nil}},
+		{2, map[string]any{"msg": "other", "meter": "water", "image": nil}},
+	}
+	for _, tt := range tests {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(lines[tt.line]), &rec); err != nil {
+			t.Fatalf("line %d: %v", tt.line, err)
+		}
+		for k, want := range tt.want {
+			if rec[k] != want {
+				t.Errorf("line %d: %s = %v, want %v", tt.line, k, rec[k], want)
+			}
+		}
+	}
+
+	if _, err := New(&buf, "xml", slog.LevelInfo); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
@@ -3,7 +3,7 @@ package mqttdump
 import (
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/url"
 	"os"
 	"sync"
@@ -12,27 +12,101 @@ import (
 	paho "github.com/eclipse/paho.mqtt.golang"
 )
 
+// SubHandler returns a writer for one incoming message. The payload is written
+// and then Close is called; the message is acknowledged to the broker only if
+// both succeed, so a QoS 1 message is redelivered when Close reports that it
+// could not be accepted.
 type SubHandler func() io.WriteCloser
 
 type Client struct {
 	client paho.Client
 	topic  string
 
+	// will is published by the broker if the connection drops; Stop publishes it too.
+	will         *Will
+	connectHook  func()
+	lostHook     func(error)
+	tls          TLSConfig
+	qos          byte
+	clientID     string
+	cleanSession bool
+
 	mu          sync.RWMutex
-	handler     SubHandler
+	handlers    map[string]SubHandler // by topic
 	isConnected bool
 	lastError   error
 }
 
-func NewClient(addr string, topic string) (*Client, error) {
+// Will is a Last Will message, e.g. an availability topic set to "offline".
+type Will struct {
+	Topic    string
+	Payload  string
+	Retained bool
+}
+
+// Option configures a Client.
+type Option func(*Client, *paho.ClientOptions)
+
+// WithWill registers w as the Last Will message of the connection.
+func WithWill(w Will) Option {
+	return func(c *Client, opts *paho.ClientOptions) {
+		c.will = &w
+		opts.SetWill(w.Topic, w.Payload, 1, w.Retained)
+	}
+}
+
+// WithQoS sets the QoS of the image subscription (0, 1 or 2).
+func WithQoS(qos byte) Option {
+	return func(c *Client, _ *paho.ClientOptions) {
+		c.qos = qos
+	}
+}
+
+// WithSession sets a stable client ID and whether the broker discards the
+// session on disconnect. With cleanSession false and QoS 1 or 2 the broker
+// queues images published while mqvision is offline. An empty clientID keeps
+// the per-process default.
+func WithSession(clientID string, cleanSession bool) Option {
+	return func(c *Client, _ *paho.ClientOptions) {
+		c.clientID = clientID
+		c.cleanSession = cleanSession
+	}
+}
+
+// WithOnConnect calls f after every (re)connect, e.g. to publish availability and discovery messages.
+func WithOnConnect(f func()) Option {
+	return func(c *Client, _ *paho.ClientOptions) {
+		c.connectHook = f
+	}
+}
+
+// WithOnConnectionLost calls f with the error whenever the connection drops.
+func WithOnConnectionLost(f func(error)) Option {
+	return func(c *Client, _ *paho.ClientOptions) {
+		c.lostHook = f
+	}
+}
+
+func NewClient(addr string, topic string, options ...Option) (*Client, error) {
+	c := &Client{topic: topic, cleanSession: true, handlers: make(map[string]SubHandler)}
+	opts, err := c.clientOptions(addr, options...)
+	if err != nil {
+		return nil, err
+	}
+	c.client = paho.NewClient(opts)
+	return c, nil
+}
+
+// clientOptions builds the paho options for the broker at addr without connecting.
+func (c *Client) clientOptions(addr string, options ...Option) (*paho.ClientOptions, error) {
 	uri, err := url.Parse(addr)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing MQTT URI: %v", err)
 	}
 
-	host := uri.Host
-	if uri.Port() == "" {
-		host += ":1883" // Default MQTT port
+	broker, secure, err := brokerURL(uri)
+	if err != nil {
+		return nil, err
 	}
 
 	username := uri.User.Username()
@@ -43,36 +117,91 @@ func NewClient(addr string, topic string) (*Client, error) {
 		hostname = h
 	}
 
-	c := &Client{topic: topic}
-
 	opts := paho.NewClientOptions()
-	opts.AddBroker(fmt.Sprintf("tcp://%s", host))
-	opts.SetClientID(fmt.Sprintf("mqvision_%s_%d", hostname, os.Getpid()))
+	opts.AddBroker(broker)
 	opts.SetUsername(username)
 	opts.SetPassword(password)
 	opts.SetAutoReconnect(true)
 	opts.SetConnectRetry(true)
 	opts.SetConnectRetryInterval(5 * time.Second)
 	opts.SetKeepAlive(60 * time.Second)
+	// Handlers ack themselves once the payload is accepted, and may block until
+	// then, so they must not hold up each other.
+	opts.SetAutoAckDisabled(true)
+	opts.SetOrderMatters(false)
 	opts.OnConnect = c.onConnect
 	opts.OnConnectionLost = c.onConnectionLost
+	for _, o := range options {
+		o(c, opts)
+	}
 
-	c.client = paho.NewClient(opts)
-	return c, nil
+	if c.qos > 2 {
+		return nil, fmt.Errorf("invalid MQTT QoS %d", c.qos)
+	}
+	clientID := c.clientID
+	if clientID == "" {
+		clientID = fmt.Sprintf("mqvision_%s_%d", hostname, os.Getpid())
+	}
+	opts.SetClientID(clientID)
+	opts.SetCleanSession(c.cleanSession)
+
+	if secure {
+		tlsConfig, err := c.tls.build()
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+	return opts, nil
+}
+
+// brokerURL maps an mqtt://, mqtts://, ws:// or wss:// URI to the paho broker
+// URL, filling in the scheme's default port. secure reports whether TLS is used.
+func brokerURL(uri *url.URL) (broker string, secure bool, err error) {
+	var scheme, port string
+	switch uri.Scheme {
+	case "mqtt", "tcp", "":
+		scheme, port = "tcp", "1883"
+	case "mqtts", "ssl", "tls":
+		scheme, port, secure = "ssl", "8883", true
+	case "ws":
+		scheme, port = "ws", "80"
+	case "wss":
+		scheme, port, secure = "wss", "443", true
+	default:
+		return "", false, fmt.Errorf("unsupported MQTT scheme %q", uri.Scheme)
+	}
+	if uri.Hostname() == "" {
+		return "", false, fmt.Errorf("MQTT URI %q has no host", uri.Redacted())
+	}
+
+	host := uri.Host
+	if uri.Port() == "" {
+		host += ":" + port
+	}
+	broker = scheme + "://" + host
+	if scheme == "ws" || scheme == "wss" {
+		path := uri.Path
+		if path == "" {
+			path = "/mqtt"
+		}
+		broker += path
+	}
+	return broker, secure, nil
 }
 
-// Run registers the subscription handler and starts connecting.
+// Run registers h for the client's topic, if both are set, and starts connecting.
 // Subscriptions are (re)established in OnConnect so AutoReconnect restores them.
 func (c *Client) Run(h SubHandler) error {
-	c.mu.Lock()
-	c.handler = h
-	c.mu.Unlock()
+	if h != nil && c.topic != "" {
+		c.Handle(c.topic, h)
+	}
 
 	token := c.client.Connect()
 	go func() {
 		token.Wait()
 		if err := token.Error(); err != nil {
-			log.Printf("MQTT connect ended with error: %v", err)
+			slog.Error("MQTT connect ended with error", "err", err)
 			c.mu.Lock()
 			c.lastError = err
 			c.mu.Unlock()
@@ -82,29 +211,49 @@ func (c *Client) Run(h SubHandler) error {
 	return nil
 }
 
+// Handle subscribes h to topic, now if connected and again on every reconnect.
+func (c *Client) Handle(topic string, h SubHandler) {
+	c.mu.Lock()
+	c.handlers[topic] = h
+	connected := c.isConnected
+	c.mu.Unlock()
+
+	if connected {
+		c.subscribe(c.client, topic, h)
+	}
+}
+
 func (c *Client) onConnect(client paho.Client) {
 	c.mu.Lock()
 	c.isConnected = true
 	c.lastError = nil
-	handler := c.handler
-	topic := c.topic
+	handlers := make(map[string]SubHandler, len(c.handlers))
+	for topic, h := range c.handlers {
+		handlers[topic] = h
+	}
 	c.mu.Unlock()
 
-	log.Println("MQTT connected")
+	slog.Info("MQTT connected")
 
-	if handler == nil {
-		return
+	if c.connectHook != nil {
+		go c.connectHook()
 	}
 
-	if token := client.Subscribe(topic, 0, newMessageHandler(handler, c)); token.Wait() && token.Error() != nil {
+	for topic, h := range handlers {
+		c.subscribe(client, topic, h)
+	}
+}
+
+func (c *Client) subscribe(client paho.Client, topic string, h SubHandler) {
+	if token := client.Subscribe(topic, c.qos, newMessageHandler(h, c)); token.Wait() && token.Error() != nil {
 		err := fmt.Errorf("error subscribing to topic %s: %w", topic, token.Error())
-		log.Print(err)
+		slog.Error("MQTT subscribe failed", "topic", topic, "err", token.Error())
 		c.mu.Lock()
 		c.lastError = err
 		c.mu.Unlock()
 		return
 	}
-	log.Printf("MQTT subscribed to %s", topic)
+	slog.Info("MQTT subscribed", "topic", topic)
 }
 
 func (c *Client) onConnectionLost(_ paho.Client, err error) {
@@ -112,7 +261,11 @@ func (c *Client) onConnectionLost(_ paho.Client, err error) {
 	c.isConnected = false
 	c.lastError = err
 	c.mu.Unlock()
-	log.Printf("MQTT connection lost: %v", err)
+	slog.Warn("MQTT connection lost", "err", err)
+
+	if c.lostHook != nil {
+		go c.lostHook(err)
+	}
 }
 
 // Status returns the current connection status and the last error encountered.
@@ -122,15 +275,42 @@ func (c *Client) Status() (bool, error) {
 	return c.isConnected, c.lastError
 }
 
+// Publish sends payload to topic with QoS 1. It fails fast while disconnected.
+func (c *Client) Publish(topic string, payload []byte, retained bool) error {
+	if connected, _ := c.Status(); !connected {
+		return fmt.Errorf("not connected")
+	}
+	token := c.client.Publish(topic, 1, retained, payload)
+	if !token.WaitTimeout(10 * time.Second) {
+		return fmt.Errorf("publish to %s timed out", topic)
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("publish to %s: %w", topic, err)
+	}
+	return nil
+}
+
 func (c *Client) Stop() error {
 	c.mu.RLock()
-	topic := c.topic
+	var topics []string
+	for topic := range c.handlers {
+		topics = append(topics, topic)
+	}
 	connected := c.isConnected
 	c.mu.RUnlock()
 
 	if connected {
-		if token := c.client.Unsubscribe(topic); token.Wait() && token.Error() != nil {
-			return fmt.Errorf("error unsubscribing from topic: %v", token.Error())
+		// A clean disconnect discards the Last Will, so announce it ourselves.
+		if c.will != nil {
+			if err := c.Publish(c.will.Topic, []byte(c.will.Payload), c.will.Retained); err != nil {
+				slog.Error("Error publishing MQTT will on stop", "err", err)
+			}
+		}
+		// Keep the subscription of a persistent session so the broker queues images while we are away.
+		if c.cleanSession && len(topics) > 0 {
+			if token := c.client.Unsubscribe(topics...); token.Wait() && token.Error() != nil {
+				return fmt.Errorf("error unsubscribing from topic: %v", token.Error())
+			}
 		}
 	}
 	c.client.Disconnect(1000)
@@ -159,15 +339,23 @@ func newMessageHandler(h SubHandler, c *Client) paho.MessageHandler {
 			c.mu.Unlock()
 			return
 		}
-		defer wc.Close()
 
 		_, err := wc.Write(msg.Payload())
 		if err != nil {
+			wc.Close()
 			c.mu.Lock()
 			c.lastError = fmt.Errorf("error writing to writer: %v", err)
 			c.mu.Unlock()
 			return
 		}
+		if err := wc.Close(); err != nil {
+			slog.Warn("MQTT message not accepted, leaving it unacknowledged", "topic", msg.Topic(), "err", err)
+			c.mu.Lock()
+			c.lastError = fmt.Errorf("error accepting message: %v", err)
+			c.mu.Unlock()
+			return
+		}
+		msg.Ack()
 
 		c.mu.Lock()
 		c.lastError = nil
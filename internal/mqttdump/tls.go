@@ -0,0 +1,59 @@
+package mqttdump
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// TLSConfig configures mqtts:// and wss:// connections. Empty fields use the system defaults.
+type TLSConfig struct {
+	// CAFile is a PEM bundle of CAs trusted in place of the system roots.
+	CAFile string
+	// CertFile and KeyFile are a PEM client certificate and key for mutual TLS.
+	CertFile string
+	KeyFile  string
+	// InsecureSkipVerify disables broker certificate verification.
+	InsecureSkipVerify bool
+}
+
+// WithTLS sets the TLS settings used when the broker URI is mqtts:// or wss://.
+func WithTLS(t TLSConfig) Option {
+	return func(c *Client, _ *paho.ClientOptions) {
+		c.tls = t
+	}
+}
+
+func (t TLSConfig) build() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read MQTT CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in MQTT CA file %s", t.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if t.CertFile != "" || t.KeyFile != "" {
+		if t.CertFile == "" || t.KeyFile == "" {
+			return nil, fmt.Errorf("MQTT client certificate needs both a cert and a key file")
+		}
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load MQTT client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
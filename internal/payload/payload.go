@@ -0,0 +1,238 @@
+// Package payload decodes camera messages into an image and the metadata the
+// camera sent along with it.
+package payload
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Payload formats.
+const (
+	FormatAuto   = "auto"   // JSON envelope, raw image or base64, whichever fits
+	FormatRaw    = "raw"    // the message is the image itself
+	FormatBase64 = "base64" // the message is a base64 (or data: URL) encoded image
+	FormatJSON   = "json"   // the message is a JSON object carrying the image and metadata
+)
+
+// ErrNotImage is returned when the decoded bytes are not a JPEG, PNG or WebP image.
+var ErrNotImage = errors.New("payload is not a JPEG, PNG or WebP image")
+
+// Payload is a decoded camera message.
+type Payload struct {
+	Image      []byte
+	MIMEType   string
+	DeviceID   string
+	CapturedAt time.Time // zero if the camera did not send it
+}
+
+// Decoder turns a message into a Payload.
+type Decoder interface {
+	Decode(msg []byte) (Payload, error)
+}
+
+// Config selects the format and, for JSON envelopes, where to find each field.
+// Field paths are dot separated, e.g. "data.jpeg".
+type Config struct {
+	Format string `yaml:"format"`
+	// Image is the path of the base64 image in a JSON envelope (default "image").
+	Image string `yaml:"image"`
+	// Device is the path of the device id (default "device").
+	Device string `yaml:"device"`
+	// Timestamp is the path of the capture time: RFC3339 or Unix seconds or
+	// milliseconds (default "ts").
+	Timestamp string `yaml:"timestamp"`
+}
+
+// New builds the decoder described by cfg.
+func New(cfg Config) (Decoder, error) {
+	env := Envelope{ImagePath: cfg.Image, DevicePath: cfg.Device, TimestampPath: cfg.Timestamp}
+	if env.ImagePath == "" {
+		env.ImagePath = "image"
+	}
+	if env.DevicePath == "" {
+		env.DevicePath = "device"
+	}
+	if env.TimestampPath == "" {
+		env.TimestampPath = "ts"
+	}
+
+	switch cfg.Format {
+	case FormatAuto, "":
+		return Auto{Envelope: env}, nil
+	case FormatRaw:
+		return Raw{}, nil
+	case FormatBase64:
+		return Base64{}, nil
+	case FormatJSON:
+		return env, nil
+	default:
+		return nil, fmt.Errorf("unknown payload format %q", cfg.Format)
+	}
+}
+
+// Raw takes the message as the image.
+type Raw struct{}
+
+func (Raw) Decode(msg []byte) (Payload, error) {
+	return sniffed(msg)
+}
+
+// Base64 decodes a base64 image, optionally wrapped in a data: URL.
+type Base64 struct{}
+
+func (Base64) Decode(msg []byte) (Payload, error) {
+	img, err := decodeBase64(string(msg))
+	if err != nil {
+		return Payload{}, err
+	}
+	return sniffed(img)
+}
+
+// Envelope reads a JSON object with a base64 image, a device id and a capture time.
+type Envelope struct {
+	ImagePath     string
+	DevicePath    string
+	TimestampPath string
+}
+
+func (e Envelope) Decode(msg []byte) (Payload, error) {
+	dec := json.NewDecoder(bytes.NewReader(msg))
+	dec.UseNumber()
+	var doc map[string]any
+	if err := dec.Decode(&doc); err != nil {
+		return Payload{}, fmt.Errorf("decode JSON envelope: %w", err)
+	}
+
+	s, ok := lookup(doc, e.ImagePath).(string)
+	if !ok || s == "" {
+		return Payload{}, fmt.Errorf("JSON envelope has no image at %q", e.ImagePath)
+	}
+	img, err := decodeBase64(s)
+	if err != nil {
+		return Payload{}, err
+	}
+	p, err := sniffed(img)
+	if err != nil {
+		return Payload{}, err
+	}
+
+	switch v := lookup(doc, e.DevicePath).(type) {
+	case string:
+		p.DeviceID = v
+	case json.Number:
+		p.DeviceID = v.String()
+	}
+	if v := lookup(doc, e.TimestampPath); v != nil {
+		ts, err := parseTimestamp(v)
+		if err != nil {
+			return Payload{}, fmt.Errorf("JSON envelope %q: %w", e.TimestampPath, err)
+		}
+		p.CapturedAt = ts
+	}
+	return p, nil
+}
+
+// Auto tells the formats apart: an object is an Envelope, a sniffable image is
+// Raw and anything else is tried as Base64.
+type Auto struct {
+	Envelope Envelope
+}
+
+func (a Auto) Decode(msg []byte) (Payload, error) {
+	trimmed := bytes.TrimSpace(msg)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		return a.Envelope.Decode(trimmed)
+	}
+	if SniffMIME(msg) != "" {
+		return Raw{}.Decode(msg)
+	}
+	return Base64{}.Decode(trimmed)
+}
+
+// SniffMIME returns the MIME type of a JPEG, PNG or WebP image, or "" for anything else.
+func SniffMIME(b []byte) string {
+	switch t := http.DetectContentType(b); t {
+	case "image/jpeg", "image/png", "image/webp":
+		return t
+	default:
+		return ""
+	}
+}
+
+func sniffed(b []byte) (Payload, error) {
+	mimeType := SniffMIME(b)
+	if mimeType == "" {
+		return Payload{}, ErrNotImage
+	}
+	return Payload{Image: b, MIMEType: mimeType}, nil
+}
+
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "data:") {
+		_, data, ok := strings.Cut(s, ",")
+		if !ok {
+			return nil, fmt.Errorf("malformed data URL")
+		}
+		s = data
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if b, err := enc.DecodeString(s); err == nil {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("payload is not valid base64")
+}
+
+// lookup follows a dot separated path through nested objects.
+func lookup(doc map[string]any, path string) any {
+	var cur any = doc
+	for _, key := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil
+		}
+		cur = m[key]
+	}
+	return cur
+}
+
+// parseTimestamp accepts RFC3339 strings and Unix times in seconds or
+// milliseconds, telling the latter apart by magnitude.
+func parseTimestamp(v any) (time.Time, error) {
+	var n float64
+	switch v := v.(type) {
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t, nil
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unrecognized timestamp %q", v)
+		}
+		n = f
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unrecognized timestamp %q", v)
+		}
+		n = f
+	default:
+		return time.Time{}, fmt.Errorf("unrecognized timestamp %v", v)
+	}
+
+	if n > 1e12 {
+		n /= 1000
+	}
+	sec, frac := math.Modf(n)
+	return time.Unix(int64(sec), int64(frac*1e9)), nil
+}
@@ -0,0 +1,120 @@
+package payload
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDecode(t *testing.T) {
+	t.Parallel()
+
+	jpg, err := os.ReadFile(filepath.Join("..", "..", "sample", "ok.jpg"))
+	if err != nil {
+		t.Fatalf("read sample: %v", err)
+	}
+	var pngBuf bytes.Buffer
+	if err := png.Encode(&pngBuf, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	pngBytes := pngBuf.Bytes()
+	b64 := base64.StdEncoding.EncodeToString(jpg)
+
+	tests := []struct {
+		name       string
+		cfg        Config
+		msg        string
+		wantImage  []byte
+		wantMIME   string
+		wantDevice string
+		wantAt     time.Time
+		wantErr    error
+	}{
+		{name: "raw jpeg", cfg: Config{Format: FormatRaw}, msg: string(jpg), wantImage: jpg, wantMIME: "image/jpeg"},
+		{name: "raw text", cfg: Config{Format: FormatRaw}, msg: "hello", wantErr: ErrNotImage},
+		{name: "auto png", msg: string(pngBytes), wantImage: pngBytes, wantMIME: "image/png"},
+		{name: "auto base64", msg: b64 + "\n", wantImage: jpg, wantMIME: "image/jpeg"},
+		{name: "base64 data url", cfg: Config{Format: FormatBase64}, msg: "data:image/jpeg;base64," + b64, wantImage: jpg, wantMIME: "image/jpeg"},
+		{
+			name:       "auto envelope",
+			msg:        `{"device":"cam1","ts":1762460000,"image":"` + b64 + `"}`,
+			wantImage:  jpg,
+			wantMIME:   "image/jpeg",
+			wantDevice: "cam1",
+			wantAt:     time.Unix(1762460000, 0),
+		},
+		{
+			name:       "envelope with paths and millis",
+			cfg:        Config{Format: FormatJSON, Image: "data.jpeg", Device: "meta.id", Timestamp: "meta.at"},
+			msg:        `{"meta":{"id":7,"at":1762460000500},"data":{"jpeg":"` + b64 + `"}}`,
+			wantImage:  jpg,
+			wantMIME:   "image/jpeg",
+			wantDevice: "7",
+			wantAt:     time.UnixMilli(1762460000500),
+		},
+		{
+			name:      "envelope with RFC3339 time",
+			msg:       `{"ts":"2025-11-07T05:13:17+09:00","image":"` + b64 + `"}`,
+			wantImage: jpg,
+			wantMIME:  "image/jpeg",
+			wantAt:    time.Date(2025, 11, 7, 5, 13, 17, 0, time.FixedZone("", 9*3600)),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			d, err := New(tt.cfg)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			p, err := d.Decode([]byte(tt.msg))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if !bytes.Equal(p.Image, tt.wantImage) {
+				t.Errorf("image differs (%d bytes, want %d)", len(p.Image), len(tt.wantImage))
+			}
+			if p.MIMEType != tt.wantMIME {
+				t.Errorf("MIMEType = %q, want %q", p.MIMEType, tt.wantMIME)
+			}
+			if p.DeviceID != tt.wantDevice {
+				t.Errorf("DeviceID = %q, want %q", p.DeviceID, tt.wantDevice)
+			}
+			if !p.CapturedAt.Equal(tt.wantAt) {
+				t.Errorf("CapturedAt = %v, want %v", p.CapturedAt, tt.wantAt)
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	t.Parallel()
+
+	if _, err := New(Config{Format: "xml"}); err == nil {
+		t.Error("New accepted an unknown format")
+	}
+
+	d, _ := New(Config{})
+	for _, msg := range []string{
+		`{"device":"cam1"}`,
+		`{"image":"!!!"}`,
+		`{"image":"aGVsbG8="}`,
+		`not base64 at all!`,
+	} {
+		if _, err := d.Decode([]byte(msg)); err == nil {
+			t.Errorf("Decode(%q) succeeded", msg)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Package quality scores camera frames with cheap pixel statistics so the best
+// frame of a burst can be chosen before paying for a vision call.
+package quality
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+
+	_ "golang.org/x/image/webp"
+)
+
+// maxSide bounds the sampled grid; larger images are subsampled.
+const maxSide = 320
+
+// Exposure thresholds on the mean luma (0-255).
+const (
+	darkBelow   = 60
+	brightAbove = 200
+)
+
+// blurBelow is the Laplacian variance under which a frame counts as blurry.
+// JPEG noise and the imprinted timestamp keep even soft frames well above
+// zero; the value is tuned on the esp32 camera images under sample/.
+const blurBelow = 1500
+
+// Report describes one frame.
+type Report struct {
+	// Brightness is the mean luma, 0 (black) to 255 (white).
+	Brightness float64 `json:"brightness" bson:"brightness"`
+	// Sharpness is the variance of the Laplacian of the luma; higher is sharper.
+	Sharpness float64 `json:"sharpness" bson:"sharpness"`
+	// Score ranks frames of the same camera; higher is better.
+	Score     float64 `json:"score" bson:"score"`
+	TooDark   bool    `json:"too_dark,omitempty" bson:"too_dark,omitempty"`
+	TooBright bool    `json:"too_bright,omitempty" bson:"too_bright,omitempty"`
+	Blurry    bool    `json:"blurry,omitempty" bson:"blurry,omitempty"`
+}
+
+// OK reports whether no problem was detected.
+func (r Report) OK() bool {
+	return !r.TooDark && !r.TooBright && !r.Blurry
+}
+
+// Analyze decodes a JPEG or PNG image and scores it.
+func Analyze(data []byte) (Report, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Report{}, fmt.Errorf("decode image: %w", err)
+	}
+	return AnalyzeImage(img), nil
+}
+
+// AnalyzeImage scores an already decoded image.
+func AnalyzeImage(img image.Image) Report {
+	luma, w, h := sampleLuma(img)
+
+	var sum float64
+	for _, v := range luma {
+		sum += v
+	}
+	mean := sum / float64(len(luma))
+
+	r := Report{
+		Brightness: mean,
+		Sharpness:  laplacianVariance(luma, w, h),
+	}
+	r.TooDark = mean < darkBelow
+	r.TooBright = mean > brightAbove
+	r.Blurry = r.Sharpness < blurBelow
+
+	// Sharpness dominates; a well exposed frame keeps it whole and the score
+	// fades linearly as the mean drifts toward black or white.
+	exposure := 1 - math.Abs(mean-128)/128
+	r.Score = r.Sharpness * exposure
+	return r
+}
+
+// sampleLuma returns the luma of img on a grid no larger than maxSide per side.
+func sampleLuma(img image.Image) ([]float64, int, int) {
+	b := img.Bounds()
+	step := 1
+	if s := max(b.Dx(), b.Dy()); s > maxSide {
+		step = (s + maxSide - 1) / maxSide
+	}
+	w := (b.Dx() + step - 1) / step
+	h := (b.Dy() + step - 1) / step
+
+	luma := make([]float64, 0, w*h)
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		for x := b.Min.X; x < b.Max.X; x += step {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			// ITU-R BT.601 on 16-bit channels, scaled to 0-255.
+			luma = append(luma, (0.299*float64(r)+0.587*float64(g)+0.114*float64(bl))/257)
+		}
+	}
+	return luma, w, h
+}
+
+// laplacianVariance is the variance of the 4-neighbour Laplacian over the interior of the grid.
+func laplacianVariance(luma []float64, w, h int) float64 {
+	if w < 3 || h < 3 {
+		return 0
+	}
+	var sum, sumSq float64
+	n := 0
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			i := y*w + x
+			l := luma[i-w] + luma[i+w] + luma[i-1] + luma[i+1] - 4*luma[i]
+			sum += l
+			sumSq += l * l
+			n++
+		}
+	}
+	m := sum / float64(n)
+	return sumSq/float64(n) - m*m
+}
@@ -0,0 +1,58 @@
+package quality
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func analyzeSample(t *testing.T, name string) Report {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "..", "sample", name))
+	if err != nil {
+		t.Fatalf("read sample: %v", err)
+	}
+	r, err := Analyze(data)
+	if err != nil {
+		t.Fatalf("Analyze(%s): %v", name, err)
+	}
+	return r
+}
+
+func TestAnalyzeSamples(t *testing.T) {
+	t.Parallel()
+
+	ok := analyzeSample(t, "ok.jpg")
+	if !ok.OK() {
+		t.Fatalf("ok.jpg flagged: %+v", ok)
+	}
+
+	tests := []struct {
+		name  string
+		check func(Report) bool
+	}{
+		{name: "too_dark.jpg", check: func(r Report) bool { return r.TooDark }},
+		{name: "too_bright.jpg", check: func(r Report) bool { return r.TooBright }},
+		{name: "blur_image.jpg", check: func(r Report) bool { return r.Blurry }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			r := analyzeSample(t, tt.name)
+			if !tt.check(r) {
+				t.Fatalf("%s not flagged: %+v", tt.name, r)
+			}
+			if r.Score >= ok.Score {
+				t.Fatalf("%s scored %.1f, not below ok.jpg's %.1f", tt.name, r.Score, ok.Score)
+			}
+		})
+	}
+}
+
+func TestAnalyzeRejectsGarbage(t *testing.T) {
+	t.Parallel()
+
+	if _, err := Analyze([]byte("not an image")); err == nil {
+		t.Fatal("expected decode error")
+	}
+}
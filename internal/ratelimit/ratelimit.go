@@ -0,0 +1,113 @@
+// Package ratelimit implements a token bucket combined with a daily call quota.
+package ratelimit
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrRateLimited means the token bucket is empty.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrQuotaExceeded means the daily quota is used up.
+	ErrQuotaExceeded = errors.New("daily quota exceeded")
+)
+
+// Config describes a limit. A zero Every disables the token bucket and a zero
+// DailyQuota disables the quota.
+type Config struct {
+	// Every is the interval at which one token is added to the bucket.
+	Every time.Duration `yaml:"every"`
+	// Burst is the bucket size; values below 1 are treated as 1.
+	Burst int `yaml:"burst"`
+	// DailyQuota caps the calls per local calendar day.
+	DailyQuota int `yaml:"daily_quota"`
+}
+
+// Limiter is a token bucket plus a daily quota.
+// It is not safe for concurrent use; callers serialize access.
+type Limiter struct {
+	cfg Config
+
+	tokens float64
+	last   time.Time
+
+	day  string
+	used int
+}
+
+// Status is a snapshot of a Limiter for health reporting.
+type Status struct {
+	Tokens     float64 `json:"tokens"`
+	Burst      int     `json:"burst"`
+	Every      string  `json:"every,omitempty"`
+	DailyQuota int     `json:"daily_quota"`
+	UsedToday  int     `json:"used_today"`
+}
+
+// New returns a Limiter with a full bucket.
+func New(cfg Config) *Limiter {
+	if cfg.Burst < 1 {
+		cfg.Burst = 1
+	}
+	return &Limiter{
+		cfg:    cfg,
+		tokens: float64(cfg.Burst),
+	}
+}
+
+// Check reports whether a call at now would be allowed, without consuming anything.
+func (l *Limiter) Check(now time.Time) error {
+	l.refill(now)
+	if l.cfg.DailyQuota > 0 && l.used >= l.cfg.DailyQuota {
+		return ErrQuotaExceeded
+	}
+	if l.cfg.Every > 0 && l.tokens < 1 {
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// Take consumes one token and one unit of the daily quota.
+// Callers should Check first; Take does not refuse.
+func (l *Limiter) Take(now time.Time) {
+	l.refill(now)
+	if l.cfg.Every > 0 {
+		l.tokens--
+	}
+	l.used++
+}
+
+// Status returns the limiter state at now.
+func (l *Limiter) Status(now time.Time) Status {
+	l.refill(now)
+	st := Status{
+		Tokens:     l.tokens,
+		Burst:      l.cfg.Burst,
+		DailyQuota: l.cfg.DailyQuota,
+		UsedToday:  l.used,
+	}
+	if l.cfg.Every > 0 {
+		st.Every = l.cfg.Every.String()
+	}
+	return st
+}
+
+func (l *Limiter) refill(now time.Time) {
+	if d := now.Format("2006-01-02"); d != l.day {
+		l.day, l.used = d, 0
+	}
+
+	if l.cfg.Every <= 0 {
+		return
+	}
+	if !l.last.IsZero() && now.After(l.last) {
+		l.tokens += float64(now.Sub(l.last)) / float64(l.cfg.Every)
+		if max := float64(l.cfg.Burst); l.tokens > max {
+			l.tokens = max
+		}
+	}
+	if now.After(l.last) {
+		l.last = now
+	}
+}
@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiterBucket(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 11, 7, 12, 0, 0, 0, time.Local)
+	l := New(Config{Every: time.Minute, Burst: 2})
+
+	for i := 0; i < 2; i++ {
+		if err := l.Check(now); err != nil {
+			t.Fatalf("call %d: Check() = %v", i, err)
+		}
+		l.Take(now)
+	}
+	if err := l.Check(now); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Check() = %v, want ErrRateLimited", err)
+	}
+
+	now = now.Add(30 * time.Second)
+	if err := l.Check(now); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("after half interval Check() = %v, want ErrRateLimited", err)
+	}
+
+	now = now.Add(30 * time.Second)
+	if err := l.Check(now); err != nil {
+		t.Fatalf("after one interval Check() = %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	if st := l.Status(now); st.Tokens != 2 {
+		t.Fatalf("tokens = %v, want bucket capped at 2", st.Tokens)
+	}
+}
+
+func TestLimiterDailyQuota(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 11, 7, 23, 0, 0, 0, time.Local)
+	l := New(Config{DailyQuota: 2})
+
+	for i := 0; i < 2; i++ {
+		if err := l.Check(now); err != nil {
+			t.Fatalf("call %d: Check() = %v", i, err)
+		}
+		l.Take(now)
+	}
+	if err := l.Check(now); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Check() = %v, want ErrQuotaExceeded", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if err := l.Check(now); err != nil {
+		t.Fatalf("next day Check() = %v", err)
+	}
+	if st := l.Status(now); st.UsedToday != 0 {
+		t.Fatalf("UsedToday = %d, want 0 after midnight", st.UsedToday)
+	}
+}
+
+func TestLimiterZeroConfigAllows(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	l := New(Config{})
+	for i := 0; i < 100; i++ {
+		if err := l.Check(now); err != nil {
+			t.Fatalf("call %d: Check() = %v", i, err)
+		}
+		l.Take(now)
+	}
+}
@@ -0,0 +1,98 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Dir watches a drop directory, e.g. an FTP upload folder, and moves each file
+// the handler accepts to Processed. Rejected files stay and are retried.
+type Dir struct {
+	Path string
+	// Processed receives accepted files (default <Path>/processed).
+	Processed string
+	// Interval between scans (default 5s).
+	Interval time.Duration
+	// Settle skips files modified more recently, so half-uploaded files are
+	// left alone (default 2s).
+	Settle time.Duration
+}
+
+func (d Dir) Run(ctx context.Context, h Handler) error {
+	if d.Processed == "" {
+		d.Processed = filepath.Join(d.Path, "processed")
+	}
+	if d.Interval <= 0 {
+		d.Interval = 5 * time.Second
+	}
+	if d.Settle <= 0 {
+		d.Settle = 2 * time.Second
+	}
+	if err := os.MkdirAll(d.Processed, 0o755); err != nil {
+		return fmt.Errorf("create processed dir: %w", err)
+	}
+
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	for {
+		if err := d.scan(h, time.Now()); err != nil {
+			slog.Error("Error scanning directory", "path", d.Path, "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// scan hands every settled file to h, oldest first.
+func (d Dir) scan(h Handler, now time.Time) error {
+	entries, err := os.ReadDir(d.Path)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".part") || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || now.Sub(info.ModTime()) < d.Settle {
+			continue
+		}
+
+		p := filepath.Join(d.Path, name)
+		img, err := os.ReadFile(p)
+		if err != nil {
+			slog.Error("Error reading image file", "file", p, "err", err)
+			continue
+		}
+		if err := h(img); err != nil {
+			slog.Warn("Image file not accepted, will retry", "file", p, "err", err)
+			continue
+		}
+		if err := os.Rename(p, d.processedPath(name, now)); err != nil {
+			// Leaving it would feed it again on the next scan.
+			slog.Error("Error moving image file; removing it", "file", p, "dir", d.Processed, "err", err)
+			os.Remove(p)
+		}
+	}
+	return nil
+}
+
+// processedPath keeps earlier files of the same name by prefixing the time.
+func (d Dir) processedPath(name string, now time.Time) string {
+	dst := filepath.Join(d.Processed, name)
+	if _, err := os.Stat(dst); err == nil {
+		dst = filepath.Join(d.Processed, now.Format("20060102_150405.000000000_")+name)
+	}
+	return dst
+}
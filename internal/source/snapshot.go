@@ -0,0 +1,81 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// maxSnapshotSize caps a downloaded snapshot.
+const maxSnapshotSize = 10 << 20
+
+// Snapshot polls a camera's still image URL, e.g. http://cam/snapshot.jpg.
+type Snapshot struct {
+	URL      string
+	Interval time.Duration
+	// Username and Password are sent as HTTP basic auth when Username is set.
+	Username string
+	Password string
+	// Client defaults to one with a 30s timeout.
+	Client *http.Client
+}
+
+// Run fetches a snapshot right away and then every Interval. A failed fetch or
+// a rejected image waits for the next tick.
+func (s Snapshot) Run(ctx context.Context, h Handler) error {
+	if s.Interval <= 0 {
+		return fmt.Errorf("snapshot %s: interval must be positive", s.URL)
+	}
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		img, err := s.fetch(ctx, client)
+		if err != nil {
+			slog.Error("Error fetching snapshot", "url", s.URL, "err", err)
+		} else if err := h(img); err != nil {
+			slog.Warn("Snapshot not accepted", "url", s.URL, "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s Snapshot) fetch(ctx context.Context, client *http.Client) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.Username != "" {
+		req.SetBasicAuth(s.Username, s.Password)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("camera returned status %d", resp.StatusCode)
+	}
+	img, err := io.ReadAll(io.LimitReader(resp.Body, maxSnapshotSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot: %w", err)
+	}
+	if len(img) > maxSnapshotSize {
+		return nil, fmt.Errorf("snapshot larger than %d bytes", maxSnapshotSize)
+	}
+	return img, nil
+}
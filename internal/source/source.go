@@ -0,0 +1,45 @@
+// Package source delivers camera images to mqvision: MQTT subscriptions,
+// polled HTTP snapshot URLs and watched drop directories.
+package source
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/suapapa/mqvision/internal/mqttdump"
+)
+
+// Handler processes one message of a source. A non-nil error means the message
+// was not accepted and the source should deliver it again if it can.
+type Handler func(msg []byte) error
+
+// Source feeds messages to a handler until ctx is done.
+type Source interface {
+	Run(ctx context.Context, h Handler) error
+}
+
+// MQTT subscribes to Topic on a shared client. Messages are acknowledged only
+// when the handler accepts them, so QoS 1 and 2 messages are redelivered otherwise.
+type MQTT struct {
+	Client *mqttdump.Client
+	Topic  string
+}
+
+func (s MQTT) Run(ctx context.Context, h Handler) error {
+	s.Client.Handle(s.Topic, func() io.WriteCloser {
+		return &messageWriter{h: h}
+	})
+	<-ctx.Done()
+	return nil
+}
+
+// messageWriter buffers one MQTT payload and hands it to the handler on Close.
+type messageWriter struct {
+	bytes.Buffer
+	h Handler
+}
+
+func (w *messageWriter) Close() error {
+	return w.h(w.Bytes())
+}
@@ -0,0 +1,119 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != "admin" || p != "pw" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("jpeg"))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var got []string
+	done := make(chan struct{})
+	go func() {
+		Snapshot{URL: srv.URL, Interval: 10 * time.Millisecond, Username: "admin", Password: "pw"}.Run(ctx, func(msg []byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+			got = append(got, string(msg))
+			if len(got) == 2 {
+				close(done)
+			}
+			return nil
+		})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("snapshot not polled twice")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if got[0] != "jpeg" {
+		t.Errorf("got %q, want the snapshot body", got[0])
+	}
+}
+
+func TestSnapshotUnauthorized(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	if _, err := (Snapshot{URL: srv.URL}).fetch(context.Background(), srv.Client()); err == nil {
+		t.Error("fetch accepted a 401")
+	}
+}
+
+func TestDirScan(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	d := Dir{Path: dir, Processed: filepath.Join(dir, "processed"), Settle: time.Second}
+	if err := os.MkdirAll(d.Processed, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-time.Minute)
+	for name, body := range map[string]string{
+		"a.jpg":      "accepted",
+		"b.jpg":      "rejected",
+		"c.jpg.part": "uploading",
+		".hidden":    "hidden",
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(p, old, old)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "fresh.jpg"), []byte("fresh"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var seen []string
+	err := d.scan(func(msg []byte) error {
+		seen = append(seen, string(msg))
+		if string(msg) == "rejected" {
+			return errors.New("busy")
+		}
+		return nil
+	}, time.Now())
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+
+	if len(seen) != 2 || seen[0] != "accepted" || seen[1] != "rejected" {
+		t.Fatalf("handled %q, want the two settled images", seen)
+	}
+	if _, err := os.Stat(filepath.Join(d.Processed, "a.jpg")); err != nil {
+		t.Errorf("accepted file not moved: %v", err)
+	}
+	for _, name := range []string{"b.jpg", "fresh.jpg", "c.jpg.part"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s should stay: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Package thumbnail makes the small derivatives of a camera image that the
+// dashboard shows instead of the full frame.
+package thumbnail
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+)
+
+// jpegQuality keeps the meter digits legible at a fraction of the camera's size.
+const jpegQuality = 80
+
+// Rect is a region of an image in fractions of its width and height, so it
+// still fits after the camera resolution changes.
+type Rect struct {
+	X float64 `yaml:"x"`
+	Y float64 `yaml:"y"`
+	W float64 `yaml:"width"`
+	H float64 `yaml:"height"`
+}
+
+// Validate checks that r is a non-empty region inside the image.
+func (r Rect) Validate() error {
+	if r.X < 0 || r.Y < 0 || r.W <= 0 || r.H <= 0 || r.X+r.W > 1 || r.Y+r.H > 1 {
+		return fmt.Errorf("region %+v must lie within 0..1", r)
+	}
+	return nil
+}
+
+// Decode decodes a JPEG, PNG or WebP image.
+func Decode(b []byte) (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+	return img, nil
+}
+
+// Crop cuts r out of img.
+func Crop(img image.Image, r Rect) image.Image {
+	b := img.Bounds()
+	rect := image.Rect(
+		b.Min.X+int(r.X*float64(b.Dx())),
+		b.Min.Y+int(r.Y*float64(b.Dy())),
+		b.Min.X+int((r.X+r.W)*float64(b.Dx())),
+		b.Min.Y+int((r.Y+r.H)*float64(b.Dy())),
+	)
+	dst := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, rect.Min, draw.Src)
+	return dst
+}
+
+// Fit scales img down, averaging the pixels each output pixel covers, so its
+// longer side is at most maxSide. Smaller images are returned as they are.
+func Fit(img image.Image, maxSide int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxSide && h <= maxSide {
+		return img
+	}
+	dw, dh := maxSide, h*maxSide/w
+	if h > w {
+		dw, dh = w*maxSide/h, maxSide
+	}
+	dw, dh = max(dw, 1), max(dh, 1)
+
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*h/dh, max((y+1)*h/dh, y*h/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*w/dw, max((x+1)*w/dw, x*w/dw+1)
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					for c := range sum {
+						sum[c] += int(row[sx*4+c])
+					}
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			o := dst.PixOffset(x, y)
+			for c := range sum {
+				dst.Pix[o+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
+
+// JPEG encodes img for archiving.
+func JPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,123 @@
+package thumbnail
+
+import (
+	"encoding/base64"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFit(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		w, h, side int
+		wantW      int
+		wantH      int
+	}{
+		{name: "landscape", w: 1600, h: 1200, side: 320, wantW: 320, wantH: 240},
+		{name: "portrait", w: 600, h: 800, side: 320, wantW: 240, wantH: 320},
+		{name: "already small", w: 200, h: 100, side: 320, wantW: 200, wantH: 100},
+		{name: "thin", w: 2000, h: 2, side: 100, wantW: 100, wantH: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got := Fit(image.NewRGBA(image.Rect(0, 0, tt.w, tt.h)), tt.side).Bounds()
+			if got.Dx() != tt.wantW || got.Dy() != tt.wantH {
+				t.Errorf("Fit = %dx%d, want %dx%d", got.Dx(), got.Dy(), tt.wantW, tt.wantH)
+			}
+		})
+	}
+}
+
+func TestFitAverages(t *testing.T) {
+	t.Parallel()
+
+	// Alternating black and white columns average to grey.
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 2; y++ {
+			if x%2 == 0 {
+				src.Set(x, y, color.White)
+			} else {
+				src.Set(x, y, color.Black)
+			}
+		}
+	}
+	got := Fit(src, 2).(*image.RGBA).RGBAAt(0, 0)
+	if got.R != 127 || got.A != 255 {
+		t.Errorf("averaged pixel = %v, want grey", got)
+	}
+}
+
+func TestCrop(t *testing.T) {
+	t.Parallel()
+
+	src := image.NewRGBA(image.Rect(0, 0, 100, 50))
+	src.Set(60, 20, color.White)
+	got := Crop(src, Rect{X: 0.5, Y: 0.2, W: 0.5, H: 0.4})
+	if b := got.Bounds(); b.Dx() != 50 || b.Dy() != 20 {
+		t.Fatalf("crop is %v, want 50x20", b)
+	}
+	if r, _, _, _ := got.At(10, 10).RGBA(); r != 0xffff {
+		t.Error("crop is not aligned with the region")
+	}
+}
+
+func TestRectValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		r       Rect
+		wantErr bool
+	}{
+		{r: Rect{X: 0.1, Y: 0.2, W: 0.5, H: 0.3}},
+		{r: Rect{W: 1, H: 1}},
+		{r: Rect{X: 0.6, W: 0.5, H: 0.5}, wantErr: true},
+		{r: Rect{X: -0.1, W: 0.5, H: 0.5}, wantErr: true},
+		{r: Rect{W: 0.5}, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := tt.r.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%+v: err = %v, wantErr %v", tt.r, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSample(t *testing.T) {
+	t.Parallel()
+
+	b, err := os.ReadFile(filepath.Join("..", "..", "sample", "ok.jpg"))
+	if err != nil {
+		t.Fatalf("read sample: %v", err)
+	}
+	img, err := Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumb, err := JPEG(Fit(img, 320))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thumb) >= len(b) {
+		t.Errorf("thumbnail is %d bytes, original %d", len(thumb), len(b))
+	}
+}
+
+func TestDecodeWebP(t *testing.T) {
+	t.Parallel()
+
+	// A 1x1 lossless WebP, as cameras may send.
+	b, _ := base64.StdEncoding.DecodeString("UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA==")
+	img, err := Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.Bounds().Size(); got != image.Pt(1, 1) {
+		t.Errorf("size = %v, want 1x1", got)
+	}
+}
@@ -0,0 +1,51 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSetup(t *testing.T) {
+	t.Parallel()
+
+	for _, exporter := range []string{"", ExporterNone} {
+		stop, err := Setup(context.Background(), exporter, "test")
+		if err != nil {
+			t.Fatalf("Setup(%q): %v", exporter, err)
+		}
+		if err := stop(context.Background()); err != nil {
+			t.Errorf("stop: %v", err)
+		}
+	}
+	if _, err := Setup(context.Background(), "jaeger", "test"); err == nil {
+		t.Error("unknown exporter accepted")
+	}
+}
+
+func TestEnd(t *testing.T) {
+	t.Parallel()
+
+	rec := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)).Tracer("test")
+
+	ctx, ok := tracer.Start(context.Background(), "ok")
+	End(ok, nil)
+	_, failed := tracer.Start(ctx, "failed")
+	End(failed, errors.New("boom"))
+
+	spans := rec.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("ended %d spans, want 2", len(spans))
+	}
+	if spans[0].Status().Code != codes.Unset || spans[1].Status().Code != codes.Error {
+		t.Errorf("statuses %v, %v", spans[0].Status(), spans[1].Status())
+	}
+	if TraceID(ctx) == "" || TraceID(context.Background()) != "" {
+		t.Error("TraceID does not follow the span context")
+	}
+}
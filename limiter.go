@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/suapapa/mqvision/internal/ratelimit"
+)
+
+// What to do with a frame that arrives while its meter or the global limit is exhausted.
+const (
+	onExceedDrop   = "drop"   // discard the frame
+	onExceedQueue  = "queue"  // keep frames in arrival order and read them when allowed
+	onExceedLatest = "latest" // keep only the newest frame and read it when allowed
+)
+
+// visionQueueSize bounds the frames a meter may keep waiting in queue mode.
+const visionQueueSize = 10
+
+// LimitConfig is a rate limit with its overflow behaviour.
+type LimitConfig struct {
+	ratelimit.Config `yaml:",inline"`
+	OnExceed         string `yaml:"on_exceed"`
+}
+
+type meterGate struct {
+	limiter  *ratelimit.Limiter
+	onExceed string
+	pending  []*Frame
+	dropped  int
+	lastErr  error
+}
+
+// VisionGate admits frames to the vision client under a global and a per-meter
+// token bucket and daily quota, so a misbehaving camera cannot run up the bill.
+type VisionGate struct {
+	mu       sync.Mutex
+	global   *ratelimit.Limiter
+	onExceed string
+	meters   map[string]*meterGate
+	run      func(*Frame)
+}
+
+// NewVisionGate builds the limiters. run is called for every admitted frame.
+func NewVisionGate(global LimitConfig, meters []MeterConfig, run func(*Frame)) *VisionGate {
+	onExceed := global.OnExceed
+	if onExceed == "" {
+		onExceed = onExceedDrop
+	}
+
+	g := &VisionGate{
+		global:   ratelimit.New(global.Config),
+		onExceed: onExceed,
+		meters:   make(map[string]*meterGate),
+		run:      run,
+	}
+	for _, m := range meters {
+		g.meters[m.ID] = g.newMeterGate(m.Limit)
+	}
+	return g
+}
+
+func (g *VisionGate) newMeterGate(c LimitConfig) *meterGate {
+	onExceed := c.OnExceed
+	if onExceed == "" {
+		onExceed = g.onExceed
+	}
+	return &meterGate{
+		limiter:  ratelimit.New(c.Config),
+		onExceed: onExceed,
+	}
+}
+
+// meter returns the gate of id, creating an unlimited one for unknown meters. g.mu must be held.
+func (g *VisionGate) meter(id string) *meterGate {
+	m, ok := g.meters[id]
+	if !ok {
+		m = g.newMeterGate(LimitConfig{})
+		g.meters[id] = m
+	}
+	return m
+}
+
+// take consumes a token from both limiters if both allow it. g.mu must be held.
+func (g *VisionGate) take(m *meterGate, now time.Time) error {
+	if err := m.limiter.Check(now); err != nil {
+		return err
+	}
+	if err := g.global.Check(now); err != nil {
+		return err
+	}
+	m.limiter.Take(now)
+	g.global.Take(now)
+	return nil
+}
+
+// Submit runs f on the calling goroutine when the limits allow it.
+// Otherwise f is dropped, queued or kept as the latest frame of its meter.
+func (g *VisionGate) Submit(f *Frame) {
+	g.mu.Lock()
+	m := g.meter(f.MeterID)
+	err := g.take(m, time.Now())
+	if err == nil {
+		g.mu.Unlock()
+		g.run(f)
+		return
+	}
+	m.lastErr = err
+
+	var dropped []*Frame
+	switch m.onExceed {
+	case onExceedQueue:
+		if len(m.pending) >= visionQueueSize {
+			dropped = append(dropped, m.pending[0])
+			m.pending = m.pending[1:]
+		}
+		m.pending = append(m.pending, f)
+	case onExceedLatest:
+		dropped = m.pending
+		m.pending = []*Frame{f}
+	default:
+		dropped = []*Frame{f}
+	}
+	m.dropped += len(dropped)
+	onExceed := m.onExceed
+	g.mu.Unlock()
+
+	for _, d := range dropped {
+		d.settle(errFrameDropped)
+	}
+	slog.WarnContext(f.context(), "Vision call not allowed now", "on_exceed", onExceed, "err", err)
+}
+
+// Admit takes a call of meterID that is not a frame, such as a reread of an
+// archived image, under the same limits. Frames waiting for the limits go
+// first, so it fails with ratelimit.ErrRateLimited while any is pending. A nil
+// gate admits every call.
+func (g *VisionGate) Admit(meterID string) error {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, m := range g.meters {
+		if len(m.pending) > 0 {
+			return ratelimit.ErrRateLimited
+		}
+	}
+	return g.take(g.meter(meterID), time.Now())
+}
+
+// Wait admits a call of meterID like Admit, waiting while it is rate limited.
+// It fails once a daily quota is used up or ctx is done.
+func (g *VisionGate) Wait(ctx context.Context, meterID string) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		err := g.Admit(meterID)
+		if !errors.Is(err, ratelimit.ErrRateLimited) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Run reads deferred frames as the limits allow until ctx is done.
+func (g *VisionGate) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, f := range g.admitPending(time.Now()) {
+				go g.run(f)
+			}
+		}
+	}
+}
+
+// admitPending pops at most one waiting frame per meter that the limits now allow.
+func (g *VisionGate) admitPending(now time.Time) []*Frame {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var admitted []*Frame
+	for _, m := range g.meters {
+		if len(m.pending) == 0 {
+			continue
+		}
+		if err := g.take(m, now); err != nil {
+			m.lastErr = err
+			continue
+		}
+		admitted = append(admitted, m.pending[0])
+		m.pending = m.pending[1:]
+	}
+	return admitted
+}
+
+// Pending counts the frames waiting for the limit across all meters.
+func (g *VisionGate) Pending() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var n int
+	for _, m := range g.meters {
+		n += len(m.pending)
+	}
+	return n
+}
+
+// GateStatus reports the vision call limits for /api/health.
+type GateStatus struct {
+	Global ratelimit.Status           `json:"global"`
+	Meters map[string]MeterGateStatus `json:"meters"`
+}
+
+// MeterGateStatus is the limit of one meter and the frames it holds back.
+type MeterGateStatus struct {
+	Limit     ratelimit.Status `json:"limit"`
+	OnExceed  string           `json:"on_exceed"`
+	Pending   int              `json:"pending"`
+	Dropped   int              `json:"dropped"`
+	LastError *string          `json:"last_error"`
+}
+
+// Status reports the limiter state for /api/health.
+func (g *VisionGate) Status() GateStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	meters := make(map[string]MeterGateStatus, len(g.meters))
+	for id, m := range g.meters {
+		var lastErr *string
+		if m.lastErr != nil {
+			s := m.lastErr.Error()
+			lastErr = &s
+		}
+		meters[id] = MeterGateStatus{
+			Limit:     m.limiter.Status(now),
+			OnExceed:  m.onExceed,
+			Pending:   len(m.pending),
+			Dropped:   m.dropped,
+			LastError: lastErr,
+		}
+	}
+	return GateStatus{
+		Global: g.global.Status(now),
+		Meters: meters,
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/suapapa/mqvision/internal/ratelimit"
+)
+
+func TestVisionGateOnExceed(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		onExceed    string
+		wantPending []string
+		wantDropped int
+	}{
+		{name: "drop", onExceed: onExceedDrop, wantPending: nil, wantDropped: 2},
+		{name: "queue", onExceed: onExceedQueue, wantPending: []string{"b", "c"}, wantDropped: 0},
+		{name: "latest", onExceed: onExceedLatest, wantPending: []string{"c"}, wantDropped: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var ran []string
+			g := NewVisionGate(
+				LimitConfig{OnExceed: tt.onExceed},
+				[]MeterConfig{{ID: "gas", Limit: LimitConfig{Config: ratelimit.Config{Every: time.Hour, Burst: 1}}}},
+				func(f *Frame) { ran = append(ran, string(f.Image)) },
+			)
+
+			for _, img := range []string{"a", "b", "c"} {
+				g.Submit(&Frame{MeterID: "gas", Image: []byte(img)})
+			}
+
+			if len(ran) != 1 || ran[0] != "a" {
+				t.Fatalf("ran = %v, want only the first frame", ran)
+			}
+			m := g.meters["gas"]
+			var pending []string
+			for _, f := range m.pending {
+				pending = append(pending, string(f.Image))
+			}
+			if len(pending) != len(tt.wantPending) {
+				t.Fatalf("pending = %v, want %v", pending, tt.wantPending)
+			}
+			for i := range pending {
+				if pending[i] != tt.wantPending[i] {
+					t.Fatalf("pending = %v, want %v", pending, tt.wantPending)
+				}
+			}
+			if m.dropped != tt.wantDropped {
+				t.Fatalf("dropped = %d, want %d", m.dropped, tt.wantDropped)
+			}
+
+			if got := g.admitPending(time.Now().Add(time.Hour)); len(tt.wantPending) > 0 && len(got) != 1 {
+				t.Fatalf("admitPending after refill = %d frames, want 1", len(got))
+			}
+		})
+	}
+}
+
+func TestVisionGateGlobalLimit(t *testing.T) {
+	t.Parallel()
+
+	var ran int
+	g := NewVisionGate(
+		LimitConfig{Config: ratelimit.Config{DailyQuota: 1}},
+		[]MeterConfig{{ID: "a"}, {ID: "b"}},
+		func(*Frame) { ran++ },
+	)
+
+	g.Submit(&Frame{MeterID: "a"})
+	g.Submit(&Frame{MeterID: "b"})
+	g.Submit(&Frame{MeterID: "unknown"})
+
+	if ran != 1 {
+		t.Fatalf("ran %d frames, want 1 under a global quota of 1", ran)
+	}
+	if g.meters["b"].dropped != 1 || g.meters["unknown"].dropped != 1 {
+		t.Fatalf("expected the later frames to be dropped: %+v %+v", g.meters["b"], g.meters["unknown"])
+	}
+}
+
+func TestVisionGateAdmit(t *testing.T) {
+	t.Parallel()
+
+	g := NewVisionGate(
+		LimitConfig{OnExceed: onExceedQueue, Config: ratelimit.Config{DailyQuota: 2}},
+		[]MeterConfig{{ID: "gas", Limit: LimitConfig{Config: ratelimit.Config{Every: time.Hour, Burst: 1}}}, {ID: "water"}},
+		func(*Frame) {},
+	)
+
+	if err := g.Admit("gas"); err != nil {
+		t.Fatalf("first reread: %v", err)
+	}
+	g.Submit(&Frame{MeterID: "gas"})
+	if err := g.Admit("water"); !errors.Is(err, ratelimit.ErrRateLimited) {
+		t.Fatalf("reread while a frame waits: %v, want ErrRateLimited", err)
+	}
+
+	g.meters["gas"].pending = nil
+	if err := g.Admit("water"); err != nil {
+		t.Fatalf("reread of another meter: %v", err)
+	}
+	if err := g.Wait(context.Background(), "water"); !errors.Is(err, ratelimit.ErrQuotaExceeded) {
+		t.Fatalf("reread past the global quota: %v, want ErrQuotaExceeded", err)
+	}
+
+	var none *VisionGate
+	if err := none.Admit("gas"); err != nil {
+		t.Fatalf("nil gate: %v", err)
+	}
+}
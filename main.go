@@ -1,12 +1,11 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"flag"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -17,10 +16,15 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/suapapa/mqvision/internal/concierge"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/suapapa/mqvision/internal/genai"
 	"github.com/suapapa/mqvision/internal/genai/openaicompat"
+	"github.com/suapapa/mqvision/internal/imagestore"
+	"github.com/suapapa/mqvision/internal/logctx"
 	"github.com/suapapa/mqvision/internal/mqttdump"
+	"github.com/suapapa/mqvision/internal/payload"
+	"github.com/suapapa/mqvision/internal/quality"
+	"github.com/suapapa/mqvision/internal/tracing"
 	// "github.com/suapapa/mqvision/internal/genai/googleai"
 )
 
@@ -33,10 +37,19 @@ var (
 
 	config *Config
 
-	sensorServer    *SensorServer
-	genaiClient     genai.VisionClient
-	conciergeClient *concierge.Client
-	mqttClient      *mqttdump.Client
+	sensorServer   *SensorServer
+	genaiClient    genai.VisionClient
+	imageStore     imagestore.Store
+	mqttClient     *mqttdump.Client
+	costTracker    *CostTracker
+	visionGate     *VisionGate
+	frameWindow    *FrameWindow
+	captures       *CaptureScheduler
+	publisher      *ReadingPublisher
+	jobs           = NewJobRegistry()
+	reprocessJobs  = NewReprocessJobs()
+	payloadDecoder payload.Decoder
+	imageSinks     []Sink
 
 	chLuggage chan *Luggage
 
@@ -44,6 +57,12 @@ var (
 	appCtx context.Context
 )
 
+// fatal logs err and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
+
 // watchMQTTDisconnect closes exitCh if MQTT stays disconnected for longer than threshold.
 func watchMQTTDisconnect(ctx context.Context, threshold time.Duration, exitCh chan<- struct{}) {
 	ticker := time.NewTicker(10 * time.Second)
@@ -81,7 +100,7 @@ func newVisionClient(ctx context.Context, c *Config) (genai.VisionClient, error)
 	if base == "" || key == "" {
 		return nil, fmt.Errorf("configure OPENAI_BASE_URL and OPENAI_API_KEY")
 	}
-	log.Println("Creating OpenAI-compatible vision client")
+	slog.Info("Creating OpenAI-compatible vision client", "model", c.OpenAICompat.Model)
 	return openaicompat.NewClient(
 		c.OpenAICompat.BaseURL,
 		c.OpenAICompat.APIKey,
@@ -107,7 +126,34 @@ func newVisionClient(ctx context.Context, c *Config) (genai.VisionClient, error)
 
 type Luggage struct {
 	*genai.GasMeterReadResult `bson:",inline"`
-	SrcImageURL               string `json:"src_image_url" bson:"src_image_url"`
+	MeterID                   string          `json:"meter_id" bson:"meter_id"`
+	SrcImageURL               string          `json:"src_image_url" bson:"src_image_url"`
+	Cost                      float64         `json:"cost" bson:"cost"`
+	Quality                   *quality.Report `json:"quality,omitempty" bson:"quality,omitempty"`
+	FramesSkipped             int             `json:"frames_skipped" bson:"frames_skipped"`
+	// ThumbnailURL and CropURL are small archived derivatives of the source image.
+	ThumbnailURL string `json:"thumbnail_url,omitempty" bson:"thumbnail_url,omitempty"`
+	CropURL      string `json:"crop_url,omitempty" bson:"crop_url,omitempty"`
+	// DeviceID and DeviceCapturedAt come from the camera's payload envelope, when it has one.
+	DeviceID         string     `json:"device_id,omitempty" bson:"device_id,omitempty"`
+	DeviceCapturedAt *time.Time `json:"device_captured_at,omitempty" bson:"device_captured_at,omitempty"`
+	// TraceID is the OpenTelemetry trace of the image, when tracing is on.
+	TraceID string `json:"trace_id,omitempty" bson:"trace_id,omitempty"`
+	// PromptVersion is a hash of the prompts the model read the image with.
+	PromptVersion string `json:"prompt_version,omitempty" bson:"prompt_version,omitempty"`
+	// Timings break down how long the image took to read.
+	Timings *Timings `json:"timings,omitempty" bson:"timings,omitempty"`
+	// CapturedAt is Date parsed in the camera's timezone.
+	CapturedAt *time.Time `json:"captured_at,omitempty" bson:"captured_at,omitempty"`
+	// ClockDrift is ReadAt minus CapturedAt in seconds.
+	ClockDrift float64 `json:"clock_drift_seconds,omitempty" bson:"clock_drift_seconds,omitempty"`
+	// TimestampTrusted is set when CapturedAt is close enough to ReadAt to time the reading.
+	TimestampTrusted bool   `json:"timestamp_trusted" bson:"timestamp_trusted"`
+	TimestampIssue   string `json:"timestamp_issue,omitempty" bson:"timestamp_issue,omitempty"`
+
+	frame *Frame // settled once the reading is stored
+	// lateImages delivers the archived images that were not ready in time.
+	lateImages <-chan imageURLs
 }
 
 func main() {
@@ -123,32 +169,89 @@ func main() {
 
 	config, err = LoadConfig(flagConfigFile)
 	if err != nil {
-		log.Fatalf("Error loading config: %v", err)
+		fatal("Error loading config", err)
+	}
+	logger, err := logctx.New(os.Stderr, config.Log.Format, config.Log.Level)
+	if err != nil {
+		fatal("Error setting up logging", err)
+	}
+	slog.SetDefault(logger)
+
+	stopTracing, err := tracing.Setup(ctx, config.Tracing.Exporter, config.Tracing.ServiceName)
+	if err != nil {
+		fatal("Error setting up tracing", err)
 	}
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := stopTracing(shutdownCtx); err != nil {
+			slog.Error("Error flushing traces", "err", err)
+		}
+	}()
 
 	genaiClient, err = newVisionClient(ctx, config)
 	if err != nil {
-		log.Fatalf("Error creating vision client: %v", err)
+		fatal("Error creating vision client", err)
 	}
 
-	log.Println("Creating concierge client")
-	conciergeClient = concierge.NewClient(config.Concierge.Addr, config.Concierge.Token)
+	imageStore = newImageStore(config.Archive)
+	if imageStore != nil {
+		slog.Info("Archiving images", "store", config.Archive.Store)
+	}
 
-	log.Println("Creating sensor server (MongoDB)")
+	slog.Info("Creating sensor server (MongoDB)")
 	sensorServer, err = NewSensorServer(ctx, config.Mongo.URI, config.Mongo.DB)
 	if err != nil {
-		log.Fatalf("Error creating sensor server: %v", err)
+		fatal("Error creating sensor server", err)
 	}
 	defer func() {
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 		defer cancel()
 		if err := sensorServer.Close(shutdownCtx); err != nil {
-			log.Printf("Error closing sensor server: %v", err)
+			slog.Error("Error closing sensor server", "err", err)
 		}
 	}()
+	usePreviousReads(ctx, sensorServer)
+	// Exchanges behind rejected frames go to the collection whatever the store.
+	if config.Exchanges.Store != exchangesNone {
+		if err := sensorServer.SetupExchanges(ctx, config.Exchanges.Retention); err != nil {
+			fatal("Error setting up model exchange collection", err)
+		}
+	}
+
+	costTracker, err = NewCostTracker(ctx, sensorServer.db,
+		config.Pricing.Models, config.Pricing.Currency, config.Pricing.MonthlyBudget)
+	if err != nil {
+		fatal("Error creating cost tracker", err)
+	}
+
+	imageSinks = configuredSinks(config.Sinks)
+	payloadDecoder, err = payload.New(config.Payload)
+	if err != nil {
+		fatal("Error creating payload decoder", err)
+	}
+	visionGate = NewVisionGate(config.Limit, config.Meters, processFrame)
+	frameWindow = NewFrameWindow(config.Meters, visionGate.Submit)
+	captures, err = NewCaptureScheduler(config.Meters, config.MQTT.BaseTopic, func(topic string, payload []byte) error {
+		return mqttClient.Publish(topic, payload, false)
+	}, frameWindow.Submit)
+	if err != nil {
+		fatal("Error creating capture scheduler", err)
+	}
 
 	chLuggage = make(chan *Luggage, 10)
 	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		visionGate.Run(ctx)
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runImageStore(ctx, imageStore)
+	}()
+
 	wg.Add(1)
 	go func(ctx context.Context) {
 		defer wg.Done()
@@ -168,85 +271,123 @@ func main() {
 				// os.Stdout.Write(jsonBytes)
 				// os.Stdout.WriteString("\n")
 
+				frameCtx := readResult.frame.context()
+				_, span := tracer.Start(frameCtx, "validate")
 				read, err := strconv.ParseFloat(readResult.Read, 64)
+				tracing.End(span, err)
 				if err != nil {
-					log.Printf("Error parsing read value: %v", err)
+					slog.ErrorContext(frameCtx, "Error parsing read value", "read", readResult.Read, "err", err)
+					publisher.PublishError(readResult.MeterID, err)
+					err = fmt.Errorf("%w: %v", errInvalidRead, err)
+					keepRejectedExchange(frameCtx, readResult.frame, readResult.Exchange, err)
+					readResult.frame.settle(err)
 					continue
 				}
 
-				if err := sensorServer.SetValue(ctx, read, readResult); err != nil {
-					log.Printf("Error updating sensor value in MongoDB: %v", err)
+				// Prefer the camera's own clock; fall back to now when it is off.
+				var updatedAt time.Time
+				if readResult.TimestampTrusted {
+					updatedAt = *readResult.CapturedAt
+				}
+
+				ex := detachExchange(readResult, config.Exchanges.Store)
+				start := time.Now()
+				storeCtx, span := tracer.Start(frameCtx, "SetValue")
+				id, err := sensorServer.SetValue(storeCtx, read, updatedAt, readResult)
+				tracing.End(span, err)
+				storageSeconds.Observe(time.Since(start).Seconds())
+				if err != nil {
+					storageErrors.Inc()
+					slog.ErrorContext(frameCtx, "Error updating sensor value in MongoDB", "err", err)
+					readResult.frame.settle(fmt.Errorf("%w: %v", errNotStored, err))
 					continue
 				}
-				log.Printf("Updated sensor value: %s (%.3f)", readResult.Read, read)
+				if readResult.lateImages != nil {
+					go attachLateImages(context.WithoutCancel(frameCtx), sensorServer, id, readResult.lateImages)
+				}
+				if ex != nil {
+					if err := sensorServer.StoreExchange(frameCtx, id, readResult.MeterID, ex); err != nil {
+						slog.ErrorContext(frameCtx, "Error storing model exchange", "err", err)
+					}
+				}
+				readResult.frame.settle(nil)
+				// A late frame timed by the camera is kept but is not the meter's state.
+				if latest, ok := usePreviousRead(frameCtx, sensorServer, readResult.MeterID); ok && latest.ID != id {
+					slog.InfoContext(frameCtx, "Stored a reading older than the latest", "read", readResult.Read, "value", read)
+					continue
+				}
+				slog.InfoContext(frameCtx, "Updated sensor value", "read", readResult.Read, "value", read)
+				if updatedAt.IsZero() {
+					updatedAt = time.Now()
+				}
+				publisher.PublishReading(read, updatedAt, readResult)
 			}
 		}
 	}(ctx)
 
-	mqttClient, err = mqttdump.NewClient(config.MQTT.Host, config.MQTT.Topic)
-	if err != nil {
-		log.Fatalf("Error creating MQTT client: %v", err)
+	// Single-shot runs and MQTT-less setups take images without a broker.
+	if flagSingleShot == "" && config.MQTT.Host != "" {
+		publisher = NewReadingPublisher(config.MQTT.BaseTopic, config.MQTT.DiscoveryPrefix, config.Meters)
+		// Image topics are subscribed by the meters' mqtt sources.
+		mqttClient, err = mqttdump.NewClient(config.MQTT.Host, "",
+			mqttdump.WithTLS(config.MQTT.TLS),
+			mqttdump.WithQoS(config.MQTT.QoS),
+			mqttdump.WithSession(config.MQTT.ClientID, config.MQTT.CleanSession),
+			mqttdump.WithWill(publisher.Will()),
+			mqttdump.WithOnConnect(func() {
+				publishMQTT(nil)
+				publisher.Announce()
+			}),
+			mqttdump.WithOnConnectionLost(publishMQTT),
+		)
+		if err != nil {
+			fatal("Error creating MQTT client", err)
+		}
+		publisher.client = mqttClient
 	}
-	defer mqttClient.Stop()
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		if flagSingleShot != "" {
 			imgFileName := flagSingleShot
-			log.Printf("Reading image file: %s", imgFileName)
+			slog.Info("Reading image file", "file", imgFileName)
 			img, err := os.Open(imgFileName)
 			if err != nil {
-				log.Fatalf("Error opening image file: %v", err)
+				fatal("Error opening image file", err)
 			}
 			defer img.Close()
 
 			imgBytes, err := io.ReadAll(img)
 			if err != nil {
-				log.Fatalf("Error reading image file: %v", err)
+				fatal("Error reading image file", err)
 			}
 
-			var srcImgStoredURL string
-			var readResult *genai.GasMeterReadResult
-
-			if strings.TrimSpace(config.Concierge.Addr) != "" && strings.TrimSpace(config.Concierge.Token) != "" {
-				srcImgStoredURL, err = conciergeClient.PostImage(bytes.NewReader(imgBytes), "image/jpeg")
-				if err != nil {
-					log.Printf("Error posting image to concierge: %v", err)
-				} else {
-					log.Printf("Posted image to concierge: %s", srcImgStoredURL)
-				}
-			}
-
-			if srcImgStoredURL != "" {
-				readResult, err = genaiClient.ReadGasGaugePicFromURL(appCtx, srcImgStoredURL)
-			} else {
-				readResult, err = genaiClient.ReadGasGaugePic(appCtx, bytes.NewReader(imgBytes))
-			}
+			f, err := receive(config.Meters[0].ID, sourceFile, payload.Raw{}, imgBytes)
 			if err != nil {
-				log.Printf("Error reading gauge image: %v", err)
-				return
-			}
-
-			if readResult == nil {
-				log.Printf("Read result is nil for image: %s", imgFileName)
-				return
+				fatal("Error decoding image file", err)
 			}
-
-			l := &Luggage{
-				GasMeterReadResult: readResult,
-				SrcImageURL:        srcImgStoredURL,
+			if err := ingest(f); err != nil {
+				slog.Error("Error ingesting image file", "file", imgFileName, "err", err)
 			}
-			chLuggage <- l
 		} else {
-			log.Println("Running MQTT client")
+			startSources(ctx, &wg)
+		}
+
+		if mqttClient != nil {
+			slog.Info("Running MQTT client")
 
-			hdlr := mqttReadGaugeSubHandler
-			if err := mqttClient.Run(hdlr); err != nil {
-				log.Fatalf("Error running MQTT client: %v", err)
+			if err := mqttClient.Run(nil); err != nil {
+				fatal("Error running MQTT client", err)
 			}
 
-			log.Println("MQTT client running")
+			slog.Info("MQTT client running")
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				captures.Run(ctx)
+			}()
 		}
 	}()
 
@@ -255,13 +396,15 @@ func main() {
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
 	// gin.SetMode(gin.ReleaseMode)
-	log.Printf("Starting Gin server on port %s", flagPort)
+	slog.Info("Starting Gin server", "port", flagPort)
 	router := gin.New()
 	router.Use(gin.Recovery())
 	// router.Use(gin.Logger())
-	router.GET("/api/sensor", sensorServer.GetValueHandler)
-	router.GET("/api/sensors", sensorServer.GetHistoryHandler)
-	router.GET("/api/health", healthHandler)
+	auth := newAuthenticator(config.Auth, config.API.Token)
+	if !auth.HasTokens() {
+		slog.Warn("No API tokens configured; upload, readings and admin APIs refuse every request")
+	}
+	routes(router, auth.require)
 	mountWebUI(router, "web/dist")
 
 	// Create HTTP server with graceful shutdown support
@@ -273,24 +416,24 @@ func main() {
 	// Start server in a goroutine
 	go func() {
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Error running Gin server: %v", err)
+			fatal("Error running Gin server", err)
 		}
 	}()
 
-	log.Println("Server started. Press Ctrl+C to stop.")
+	slog.Info("Server started. Press Ctrl+C to stop.")
 
 	// If MQTT stays down long enough, exit so Docker restart:unless-stopped can recover.
 	watchdogExit := make(chan struct{})
-	if flagSingleShot == "" {
+	if mqttClient != nil {
 		go watchMQTTDisconnect(ctx, mqttDisconnectExitAfter, watchdogExit)
 	}
 
 	exitCode := 0
 	select {
 	case <-sigChan:
-		log.Println("Shutting down server...")
+		slog.Info("Shutting down server...")
 	case <-watchdogExit:
-		log.Printf("MQTT disconnected for %v; exiting for container restart", mqttDisconnectExitAfter)
+		slog.Error("MQTT disconnected too long; exiting for container restart", "after", mqttDisconnectExitAfter)
 		exitCode = 1
 	}
 
@@ -299,9 +442,9 @@ func main() {
 
 	// Stop MQTT client first
 	if mqttClient != nil {
-		log.Println("Stopping MQTT client...")
+		slog.Info("Stopping MQTT client...")
 		if err := mqttClient.Stop(); err != nil {
-			log.Printf("Error stopping MQTT client: %v", err)
+			slog.Error("Error stopping MQTT client", "err", err)
 		}
 	}
 
@@ -313,11 +456,11 @@ func main() {
 	defer shutdownCancel()
 
 	if err := srv.Shutdown(shutdownCtx); err != nil {
-		log.Printf("Server forced to shutdown: %v", err)
+		slog.Error("Server forced to shutdown", "err", err)
 	}
 
 	// Wait for all goroutines to finish
-	log.Println("Waiting for goroutines to finish...")
+	slog.Info("Waiting for goroutines to finish...")
 	done := make(chan struct{})
 	go func() {
 		wg.Wait()
@@ -326,114 +469,105 @@ func main() {
 
 	select {
 	case <-done:
-		log.Println("All goroutines finished")
+		slog.Info("All goroutines finished")
 	case <-time.After(5 * time.Second):
-		log.Println("Timeout waiting for goroutines to finish")
+		slog.Warn("Timeout waiting for goroutines to finish")
 	}
 
-	log.Println("Server stopped")
+	slog.Info("Server stopped")
 	if exitCode != 0 {
 		os.Exit(exitCode)
 	}
 }
 
-func mqttReadGaugeSubHandler() io.WriteCloser {
-	pr, pw := io.Pipe()
-
-	go func() {
-		defer pr.Close()
-
-		imgBytes, err := io.ReadAll(pr)
-		if err != nil {
-			log.Printf("Error reading MQTT image stream: %v", err)
-			return
-		}
-
-		var srcImgStoredURL string
-		var readResult *genai.GasMeterReadResult
-
-		if strings.TrimSpace(config.Concierge.Addr) != "" && strings.TrimSpace(config.Concierge.Token) != "" {
-			srcImgStoredURL, err = conciergeClient.PostImage(bytes.NewReader(imgBytes), "image/jpeg")
-			if err != nil {
-				log.Printf("Error posting image to concierge: %v", err)
-			} else {
-				log.Printf("Posted image to concierge: %s", srcImgStoredURL)
-			}
-		}
+// routes registers the API on router, guarding each endpoint with guard(scope) for the scope it needs.
+func routes(router *gin.Engine, guard func(scope string) gin.HandlerFunc) {
+	router.GET("/api/openapi.json", openAPIHandler)
+	// The spec and the health check stay open, the latter for container healthchecks.
+	router.GET("/api/health", healthHandler)
+	read := router.Group("", guard(scopeRead))
+	read.GET("/api/sensor", sensorServer.GetValueHandler)
+	read.GET("/api/sensors", sensorServer.GetHistoryHandler)
+	read.GET("/api/readings/:id", sensorServer.GetReadingHandler)
+	read.GET("/api/events", eventsHandler)
+	read.GET("/api/costs", costTracker.GetCostsHandler)
+	read.GET("/api/images/:id", getImageHandler)
+	read.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	write := router.Group("/api", guard(scopeWrite))
+	write.POST("/meters/:id/images", uploadImageHandler)
+	write.GET("/jobs/:id", getJobHandler)
+	write.POST("/readings", sensorServer.CreateReadingHandler)
+	write.PATCH("/readings/:id", sensorServer.CorrectReadingHandler)
+	write.DELETE("/readings/:id", sensorServer.DeleteReadingHandler)
+	write.POST("/readings/:id/reread", sensorServer.RereadHandler)
+	write.GET("/candidates", sensorServer.GetCandidatesHandler)
+	write.POST("/candidates/:id/apply", sensorServer.ApplyCandidateHandler)
+	admin := router.Group("/api", guard(scopeAdmin))
+	admin.POST("/reprocess", sensorServer.StartReprocessHandler)
+	admin.GET("/reprocess/:id", GetReprocessHandler)
+	admin.GET("/audit", sensorServer.GetAuditHandler)
+	admin.GET("/examples", sensorServer.GetExamplesHandler)
+}
 
-		if srcImgStoredURL != "" {
-			readResult, err = genaiClient.ReadGasGaugePicFromURL(appCtx, srcImgStoredURL)
-		} else {
-			readResult, err = genaiClient.ReadGasGaugePic(appCtx, bytes.NewReader(imgBytes))
-		}
-		if err != nil {
-			log.Printf("Error reading gauge image: %v", err)
-			return
-		}
-		if readResult == nil {
-			log.Printf("Read result is nil")
-			return
-		}
-		log.Printf("Read result: %+v", readResult)
+// Health is the response of /api/health.
+type Health struct {
+	Status   string        `json:"status"`
+	MQTT     MQTTHealth    `json:"mqtt"`
+	Sensor   SensorHealth  `json:"sensor"`
+	LLM      CostStatus    `json:"llm"`
+	Limits   GateStatus    `json:"limits"`
+	Captures CaptureStatus `json:"captures"`
+}
 
-		l := &Luggage{
-			GasMeterReadResult: readResult,
-			SrcImageURL:        srcImgStoredURL,
-		}
-		chLuggage <- l
-	}()
+// MQTTHealth is the MQTT connection; Connected and LastError stay empty when MQTT is off.
+type MQTTHealth struct {
+	Enabled   bool    `json:"enabled"`
+	Connected bool    `json:"connected"`
+	LastError *string `json:"last_error"`
+}
 
-	return pw
+type SensorHealth struct {
+	LastUpdated *string `json:"last_updated"`
 }
 
 func healthHandler(c *gin.Context) {
-	if mqttClient == nil {
-		c.JSON(http.StatusOK, gin.H{
-			"status": "ok",
-			"info":   "single-shot mode or mqtt client not initialized",
-		})
-		return
-	}
+	health := Health{Status: "ok"}
+	httpStatus := http.StatusOK
 
-	isConnected, lastErr := mqttClient.Status()
+	if mqttClient != nil {
+		isConnected, lastErr := mqttClient.Status()
 
-	status := "ok"
-	httpStatus := http.StatusOK
-	var errMsg *string
-	if lastErr != nil {
-		s := lastErr.Error()
-		errMsg = &s
-	}
+		var errMsg *string
+		if lastErr != nil {
+			s := lastErr.Error()
+			errMsg = &s
+		}
 
-	if !isConnected {
-		if flagSingleShot == "" {
-			status = "fail"
+		if !isConnected {
+			health.Status = "fail"
 			httpStatus = http.StatusServiceUnavailable
 		}
+		health.MQTT = MQTTHealth{
+			Enabled:   true,
+			Connected: isConnected,
+			LastError: errMsg,
+		}
 	}
 
 	sensorServer.RLock()
 	lastUpdated := sensorServer.UpdatedAt
 	sensorServer.RUnlock()
 
-	var lastUpdatedStr *string
 	if !lastUpdated.IsZero() {
 		s := lastUpdated.Format(time.RFC3339)
-		lastUpdatedStr = &s
+		health.Sensor.LastUpdated = &s
 	}
 
-	response := gin.H{
-		"status": status,
-		"mqtt": gin.H{
-			"connected":  isConnected,
-			"last_error": errMsg,
-		},
-		"sensor": gin.H{
-			"last_updated": lastUpdatedStr,
-		},
-	}
+	health.LLM = costTracker.Status()
+	health.Limits = visionGate.Status()
+	health.Captures = captures.Status()
 
-	c.JSON(httpStatus, response)
+	c.JSON(httpStatus, health)
 }
 
 // func mqttFileDumpSubHandler() io.WriteCloser {
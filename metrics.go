@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/suapapa/mqvision/internal/genai"
+)
+
+// visionProvider labels the vision metrics; it names the client built by newVisionClient.
+const visionProvider = "openai_compat"
+
+var (
+	messagesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mqvision_messages_received_total",
+		Help: "Images or messages received, by meter and source (mqtt, http, dir, upload).",
+	}, []string{"meter", "source"})
+	payloadBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mqvision_payload_bytes",
+		Help:    "Size of received messages before decoding.",
+		Buckets: prometheus.ExponentialBuckets(16<<10, 2, 10), // 16 KiB to 8 MiB
+	}, []string{"meter", "source"})
+
+	archiveSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mqvision_archive_upload_seconds",
+		Help:    "Time to store an image in the archive, by store (concierge, fs, s3).",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"store"})
+	archiveErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mqvision_archive_upload_errors_total",
+		Help: "Images the archive failed to store.",
+	}, []string{"store"})
+
+	visionSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mqvision_vision_call_seconds",
+		Help:    "Time to read one image, including the fix_ambiguous follow-up.",
+		Buckets: []float64{0.5, 1, 2, 3, 5, 8, 13, 21, 34, 60},
+	}, []string{"provider", "model"})
+	visionErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mqvision_vision_call_errors_total",
+		Help: "Images the vision client failed to read.",
+	}, []string{"provider", "model"})
+	visionReads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mqvision_vision_reads_total",
+		Help: "Images the vision client was called for, by outcome (ok, error).",
+	}, []string{"meter", "outcome"})
+	ambiguousReads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mqvision_vision_ambiguous_reads_total",
+		Help: "Reads whose first answer had ambiguous digits; divide by mqvision_vision_reads_total of any outcome for the rate.",
+	}, []string{"meter"})
+	fixAmbiguousCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mqvision_fix_ambiguous_calls_total",
+		Help: "fix_ambiguous follow-up calls, by outcome (ok, error).",
+	}, []string{"provider", "model", "outcome"})
+
+	llmTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mqvision_llm_tokens_total",
+		Help: "Tokens billed by the LLM, failed reads included, by model and kind (prompt, completion).",
+	}, []string{"model", "kind"})
+	llmCost = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mqvision_llm_cost_total",
+		Help: "Priced cost of the LLM calls, by currency.",
+	}, []string{"currency"})
+
+	readingsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mqvision_readings_rejected_total",
+		Help: "Frames that ended without a stored reading, by reason.",
+	}, []string{"meter", "reason"})
+
+	storageSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "mqvision_storage_write_seconds",
+		Help:    "Time to store a reading in MongoDB.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 12),
+	})
+	storageErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "mqvision_storage_write_errors_total",
+		Help: "Readings MongoDB failed to store.",
+	})
+
+	meterValue = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mqvision_meter_value",
+		Help: "Latest stored value per meter.",
+	}, []string{"meter"})
+)
+
+func init() {
+	queueDepth := func(queue string, depth func() int) {
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "mqvision_queue_depth",
+			Help:        "Frames or readings waiting in a pipeline queue.",
+			ConstLabels: prometheus.Labels{"queue": queue},
+		}, func() float64 { return float64(depth()) })
+	}
+	queueDepth("vision", func() int {
+		if visionGate == nil {
+			return 0
+		}
+		return visionGate.Pending()
+	})
+	queueDepth("storage", func() int { return len(chLuggage) })
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "mqvision_llm_cost_month",
+		Help: "LLM cost of the current month so far, as budgeted, in the currency of mqvision_llm_cost_total.",
+	}, func() float64 {
+		if costTracker == nil {
+			return 0
+		}
+		return costTracker.Status().CostMonth
+	})
+}
+
+// rejectReason labels why a frame ended without a stored reading.
+func rejectReason(err error) string {
+	switch {
+	case errors.Is(err, errFrameSkipped):
+		return "burst_skipped"
+	case errors.Is(err, errFrameDropped):
+		return "rate_limited"
+	case errors.Is(err, errFrameRetaken):
+		return "too_dark"
+	case errors.Is(err, errBudgetExceeded):
+		return "budget_exceeded"
+	case errors.Is(err, errInvalidRead):
+		return "invalid_read"
+	case errors.Is(err, errNotStored):
+		return "not_stored"
+	case errors.Is(err, context.Canceled):
+		return "shutdown"
+	default:
+		return "vision_error"
+	}
+}
+
+// observeVision records one call of the vision client for meterID.
+func observeVision(meterID string, took time.Duration, res *genai.GasMeterReadResult, err error) {
+	model := config.OpenAICompat.Model
+	visionSeconds.WithLabelValues(visionProvider, model).Observe(took.Seconds())
+	if err != nil {
+		visionErrors.WithLabelValues(visionProvider, model).Inc()
+		visionReads.WithLabelValues(meterID, "error").Inc()
+		if errors.Is(err, genai.ErrFixAmbiguous) {
+			ambiguousReads.WithLabelValues(meterID).Inc()
+			fixAmbiguousCalls.WithLabelValues(visionProvider, model, "error").Inc()
+		}
+		return
+	}
+	visionReads.WithLabelValues(meterID, "ok").Inc()
+	if res != nil && res.Ambiguous != "" {
+		ambiguousReads.WithLabelValues(meterID).Inc()
+		fixAmbiguousCalls.WithLabelValues(visionProvider, model, "ok").Inc()
+	}
+}
+
+// observeLLMUsage records the tokens billed for model and what they cost.
+func observeLLMUsage(model, currency string, u genai.Usage, cost float64) {
+	llmTokens.WithLabelValues(model, "prompt").Add(float64(u.PromptTokens))
+	llmTokens.WithLabelValues(model, "completion").Add(float64(u.CompletionTokens))
+	llmCost.WithLabelValues(currency).Add(cost)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/suapapa/mqvision/internal/genai"
+)
+
+func TestRejectReason(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{err: errFrameSkipped, want: "burst_skipped"},
+		{err: errFrameDropped, want: "rate_limited"},
+		{err: errFrameRetaken, want: "too_dark"},
+		{err: fmt.Errorf("read: %w", errBudgetExceeded), want: "budget_exceeded"},
+		{err: fmt.Errorf("%w: strconv", errInvalidRead), want: "invalid_read"},
+		{err: fmt.Errorf("%w: mongo down", errNotStored), want: "not_stored"},
+		{err: context.Canceled, want: "shutdown"},
+		{err: errors.New("model said no"), want: "vision_error"},
+	}
+	for _, tt := range tests {
+		if got := rejectReason(tt.err); got != tt.want {
+			t.Errorf("rejectReason(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestSettleCountsRejection(t *testing.T) {
+	t.Parallel()
+
+	rejected := readingsRejected.WithLabelValues("settle-test", "rate_limited")
+	f := &Frame{MeterID: "settle-test", done: make(chan error, 1)}
+	f.settle(errFrameDropped)
+	f.settle(errFrameSkipped) // only the first fate counts
+
+	if got := testutil.ToFloat64(rejected); got != 1 {
+		t.Errorf("rejections = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(readingsRejected.WithLabelValues("settle-test", "burst_skipped")); got != 0 {
+		t.Errorf("second settle counted: %v", got)
+	}
+}
+
+// TestObserveVision swaps the config, so it must not run in parallel.
+func TestObserveVision(t *testing.T) {
+	saved := config
+	t.Cleanup(func() { config = saved })
+	config = &Config{}
+	config.OpenAICompat.Model = "observe-test"
+
+	observeVision("observe-test", time.Second, &genai.GasMeterReadResult{Read: "1"}, nil)
+	observeVision("observe-test", time.Second, &genai.GasMeterReadResult{Read: "12", Ambiguous: "1?"}, nil)
+	observeVision("observe-test", time.Second, nil, fmt.Errorf("%w: timeout", genai.ErrFixAmbiguous))
+
+	tests := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"reads", testutil.ToFloat64(visionReads.WithLabelValues("observe-test", "ok")), 2},
+		{"failed reads", testutil.ToFloat64(visionReads.WithLabelValues("observe-test", "error")), 1},
+		{"ambiguous", testutil.ToFloat64(ambiguousReads.WithLabelValues("observe-test")), 2},
+		{"errors", testutil.ToFloat64(visionErrors.WithLabelValues(visionProvider, "observe-test")), 1},
+		{"fix ok", testutil.ToFloat64(fixAmbiguousCalls.WithLabelValues(visionProvider, "observe-test", "ok")), 1},
+		{"fix error", testutil.ToFloat64(fixAmbiguousCalls.WithLabelValues(visionProvider, "observe-test", "error")), 1},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+// TestLLMMetrics swaps the cost tracker, so it must not run in parallel.
+func TestLLMMetrics(t *testing.T) {
+	saved := costTracker
+	t.Cleanup(func() { costTracker = saved })
+
+	observeLLMUsage("llm-test", "KRW", genai.Usage{PromptTokens: 1200, CompletionTokens: 30, Calls: 2}, 1.5)
+	observeLLMUsage("llm-test", "KRW", genai.Usage{PromptTokens: 800, CompletionTokens: 20, Calls: 1}, 0.5)
+	if got := testutil.ToFloat64(llmTokens.WithLabelValues("llm-test", "prompt")); got != 2000 {
+		t.Errorf("prompt tokens = %v, want 2000", got)
+	}
+	if got := testutil.ToFloat64(llmTokens.WithLabelValues("llm-test", "completion")); got != 50 {
+		t.Errorf("completion tokens = %v, want 50", got)
+	}
+	if got := testutil.ToFloat64(llmCost.WithLabelValues("KRW")); got != 2 {
+		t.Errorf("cost = %v, want 2", got)
+	}
+
+	now := time.Now()
+	costTracker = &CostTracker{currency: "KRW", day: now.Format("2006-01-02"), month: now.Format("2006-01"), monthTotal: 12.5}
+	const want = `
+# HELP mqvision_llm_cost_month LLM cost of the current month so far, as budgeted, in the currency of mqvision_llm_cost_total.
+# TYPE mqvision_llm_cost_month gauge
+mqvision_llm_cost_month 12.5
+`
+	if err := testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(want), "mqvision_llm_cost_month"); err != nil {
+		t.Error(err)
+	}
+}
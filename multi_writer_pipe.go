@@ -1,10 +1,18 @@
 package main
 
 import (
+	"errors"
 	"io"
 	"sync"
+	"time"
 )
 
+// ErrReaderStalled is returned to a reader that fell too far behind the writer.
+var ErrReaderStalled = errors.New("pipe reader stalled")
+
+// pipeQueueLen bounds the writes buffered for one reader before it counts as stalled.
+const pipeQueueLen = 64
+
 type SingleInMultiOutPipeReader struct {
 	io.Reader
 	closer io.Closer
@@ -17,13 +25,70 @@ func (r *SingleInMultiOutPipeReader) Close() error {
 	return nil
 }
 
+// CloseWithError closes the reader; the writer then skips it and err is what
+// a concurrent or later Read returns.
+func (r *SingleInMultiOutPipeReader) CloseWithError(err error) error {
+	if pr, ok := r.closer.(*io.PipeReader); ok {
+		return pr.CloseWithError(err)
+	}
+	return r.Close()
+}
+
+// pipeOut feeds one reader from its own goroutine, so readers never wait on each other.
+type pipeOut struct {
+	pw    *io.PipeWriter
+	queue chan []byte
+	done  chan struct{}
+}
+
+func (o *pipeOut) run(stallTimeout time.Duration) {
+	defer close(o.done)
+
+	var failed bool
+	for p := range o.queue {
+		if failed {
+			continue
+		}
+		if err := o.write(p, stallTimeout); err != nil {
+			o.pw.CloseWithError(err)
+			failed = true
+		}
+	}
+	if !failed {
+		o.pw.Close()
+	}
+}
+
+func (o *pipeOut) write(p []byte, stallTimeout time.Duration) error {
+	if stallTimeout > 0 {
+		t := time.AfterFunc(stallTimeout, func() { o.pw.CloseWithError(ErrReaderStalled) })
+		defer t.Stop()
+	}
+	_, err := o.pw.Write(p)
+	return err
+}
+
+// SingleInMultiOutPipeWriter broadcasts writes to every reader. Each reader is
+// fed independently: one that stops reading, falls behind by more than
+// pipeQueueLen writes or stays blocked longer than the stall timeout is cut off
+// with ErrReaderStalled, and one that closes early is skipped, while the others
+// keep receiving the stream.
 type SingleInMultiOutPipeWriter struct {
-	writers []io.Writer
-	closers []io.Closer
-	mu      sync.Mutex
-	closed  bool
+	outs         []*pipeOut
+	stallTimeout time.Duration
+	mu           sync.Mutex
+	closed       bool
 }
 
+// SetStallTimeout cuts off a reader that blocks a single write for longer than d.
+// Zero waits forever. It must be called before the first Write.
+func (w *SingleInMultiOutPipeWriter) SetStallTimeout(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stallTimeout = d
+}
+
+// Write queues p for every reader and returns without waiting for them.
 func (w *SingleInMultiOutPipeWriter) Write(p []byte) (int, error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
@@ -31,26 +96,33 @@ func (w *SingleInMultiOutPipeWriter) Write(p []byte) (int, error) {
 	if w.closed {
 		return 0, io.ErrClosedPipe
 	}
-
-	// Write to all writers
-	// If any writer fails, we still write to others but return the error
-	var firstErr error
-	for _, writer := range w.writers {
-		n, err := writer.Write(p)
-		if err != nil && firstErr == nil {
-			firstErr = err
-		}
-		if n != len(p) && firstErr == nil {
-			firstErr = io.ErrShortWrite
+	w.start()
+
+	// Readers consume asynchronously, so they need their own copy.
+	buf := make([]byte, len(p))
+	copy(buf, p)
+	for _, o := range w.outs {
+		select {
+		case o.queue <- buf:
+		default:
+			o.pw.CloseWithError(ErrReaderStalled)
 		}
 	}
+	return len(p), nil
+}
 
-	if firstErr != nil {
-		return len(p), firstErr
+// start launches the reader goroutines on first use. w.mu must be held.
+func (w *SingleInMultiOutPipeWriter) start() {
+	for _, o := range w.outs {
+		if o.done == nil {
+			o.done = make(chan struct{})
+			go o.run(w.stallTimeout)
+		}
 	}
-	return len(p), nil
 }
 
+// Close ends the stream: each reader gets EOF once it has read what was written.
+// It does not wait for slow readers; use Wait for that.
 func (w *SingleInMultiOutPipeWriter) Close() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
@@ -59,43 +131,35 @@ func (w *SingleInMultiOutPipeWriter) Close() error {
 		return nil
 	}
 	w.closed = true
-
-	var errs []error
-	for _, closer := range w.closers {
-		if err := closer.Close(); err != nil {
-			errs = append(errs, err)
-		}
+	w.start()
+	for _, o := range w.outs {
+		close(o.queue)
 	}
+	return nil
+}
 
-	if len(errs) > 0 {
-		return errs[0] // Return first error
+// Wait blocks until every reader was handed the whole stream or cut off. Call it after Close.
+func (w *SingleInMultiOutPipeWriter) Wait() {
+	for _, o := range w.outs {
+		<-o.done
 	}
-	return nil
 }
 
 // SingleInMultiOutPipe creates a single Writer and multiple Readers.
 // Writing to the Writer will broadcast the data to all Readers.
 // readerCount specifies how many Readers to create.
 func SingleInMultiOutPipe(readerCount int) (*SingleInMultiOutPipeWriter, []*SingleInMultiOutPipeReader) {
-	writers := make([]io.Writer, readerCount)
-	closers := make([]io.Closer, readerCount)
+	outs := make([]*pipeOut, readerCount)
 	readers := make([]*SingleInMultiOutPipeReader, readerCount)
 
 	for i := 0; i < readerCount; i++ {
 		pr, pw := io.Pipe()
-		writers[i] = pw
-		closers[i] = pw
+		outs[i] = &pipeOut{pw: pw, queue: make(chan []byte, pipeQueueLen)}
 		readers[i] = &SingleInMultiOutPipeReader{
 			Reader: pr,
 			closer: pr,
 		}
 	}
 
-	writer := &SingleInMultiOutPipeWriter{
-		writers: writers,
-		closers: closers,
-		closed:  false,
-	}
-
-	return writer, readers
+	return &SingleInMultiOutPipeWriter{outs: outs}, readers
 }
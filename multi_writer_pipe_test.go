@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestSingleInMultiOutPipe(t *testing.T) {
+	t.Parallel()
+
+	w, readers := SingleInMultiOutPipe(3)
+	got := make(chan []byte, len(readers))
+	for _, r := range readers {
+		go func() {
+			b, _ := io.ReadAll(r)
+			got <- b
+		}()
+	}
+
+	w.Write([]byte("hello "))
+	w.Write([]byte("world"))
+	w.Close()
+	w.Wait()
+
+	for range readers {
+		if b := <-got; string(b) != "hello world" {
+			t.Errorf("reader got %q, want the whole stream", b)
+		}
+	}
+}
+
+func TestSingleInMultiOutPipeStalledReader(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		writes int
+		stall  time.Duration
+	}{
+		{name: "blocked in a write", writes: 1, stall: 50 * time.Millisecond},
+		{name: "queue overflow", writes: pipeQueueLen + 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			w, readers := SingleInMultiOutPipe(2)
+			w.SetStallTimeout(tt.stall)
+
+			// The healthy reader hands back every chunk, so the writer can
+			// keep pace with it while the other reader never reads.
+			chunks := make(chan []byte)
+			go func() {
+				defer close(chunks)
+				buf := make([]byte, 16)
+				for {
+					n, err := readers[0].Read(buf)
+					if n > 0 {
+						chunks <- append([]byte(nil), buf[:n]...)
+					}
+					if err != nil {
+						return
+					}
+				}
+			}()
+
+			for i := 0; i < tt.writes; i++ {
+				if _, err := w.Write([]byte("x")); err != nil {
+					t.Fatalf("write: %v", err)
+				}
+				select {
+				case b := <-chunks:
+					if string(b) != "x" {
+						t.Fatalf("healthy reader got %q", b)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("healthy reader held up by the stalled one")
+				}
+			}
+			w.Close()
+			if _, ok := <-chunks; ok {
+				t.Error("healthy reader got data after the stream")
+			}
+
+			// The stalled reader comes back later and finds itself cut off.
+			if tt.stall > 0 {
+				time.Sleep(2 * tt.stall)
+			}
+			if _, err := io.ReadAll(readers[1]); !errors.Is(err, ErrReaderStalled) {
+				t.Errorf("stalled reader got %v, want ErrReaderStalled", err)
+			}
+			w.Wait()
+		})
+	}
+}
+
+func TestSingleInMultiOutPipeClosedReader(t *testing.T) {
+	t.Parallel()
+
+	w, readers := SingleInMultiOutPipe(2)
+	readers[1].Close()
+
+	got := make(chan []byte, 1)
+	go func() {
+		b, _ := io.ReadAll(readers[0])
+		got <- b
+	}()
+
+	w.Write([]byte("data"))
+	w.Close()
+	w.Wait()
+
+	if b := <-got; string(b) != "data" {
+		t.Errorf("reader got %q, want %q", b, "data")
+	}
+	if _, err := w.Write([]byte("late")); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("write after close: %v, want io.ErrClosedPipe", err)
+	}
+}
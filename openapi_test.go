@@ -0,0 +1,714 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/suapapa/mqvision/internal/genai"
+	"github.com/suapapa/mqvision/internal/imagestore"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type specDoc struct {
+	Paths      map[string]map[string]specOperation `json:"paths"`
+	Components struct {
+		Responses map[string]specContent `json:"responses"`
+		Schemas   map[string]*specSchema `json:"schemas"`
+	} `json:"components"`
+}
+
+// response returns the response of op for status, following $ref.
+func (d *specDoc) response(op specOperation, status int) (specContent, bool) {
+	res, ok := op.Responses[strconv.Itoa(status)]
+	if name, found := strings.CutPrefix(res.Ref, "#/components/responses/"); found {
+		res, ok = d.Components.Responses[name]
+	}
+	return res, ok
+}
+
+type specOperation struct {
+	Scope       string       `json:"x-scope"`
+	RequestBody *specContent `json:"requestBody"`
+	Responses   map[string]specContent
+}
+
+type specContent struct {
+	Ref     string `json:"$ref"`
+	Content map[string]struct {
+		Schema *specSchema `json:"schema"`
+	} `json:"content"`
+}
+
+// json returns the schema of the application/json content, or nil.
+func (c *specContent) json() *specSchema {
+	if c == nil {
+		return nil
+	}
+	return c.Content["application/json"].Schema
+}
+
+type specSchema struct {
+	Ref                  string                 `json:"$ref"`
+	Type                 any                    `json:"type"` // a type or a list of them
+	Properties           map[string]*specSchema `json:"properties"`
+	AdditionalProperties *specSchema            `json:"additionalProperties"`
+	Items                *specSchema            `json:"items"`
+	AllOf                []*specSchema          `json:"allOf"`
+	OneOf                []*specSchema          `json:"oneOf"`
+	Required             []string               `json:"required"`
+	Enum                 []any                  `json:"enum"`
+	Description          string                 `json:"description"`
+}
+
+func (s *specSchema) is(typ string) bool {
+	switch t := s.Type.(type) {
+	case string:
+		return t == typ
+	case []any:
+		return slices.Contains(t, any(typ))
+	}
+	return false
+}
+
+// apiContract is what each route needs and answers with; response and request
+// are the JSON types of the success response and the body, nil for none.
+var apiContract = map[string]struct {
+	scope    string
+	status   int
+	response reflect.Type
+	request  reflect.Type
+}{
+	"GET /api/openapi.json":          {"", http.StatusOK, nil, nil},
+	"GET /api/health":                {"", http.StatusOK, reflect.TypeFor[Health](), nil},
+	"GET /api/sensor":                {scopeRead, http.StatusOK, reflect.TypeFor[SensorServer](), nil},
+	"GET /api/sensors":               {scopeRead, http.StatusOK, reflect.TypeFor[[]SensorReading](), nil},
+	"GET /api/readings/:id":          {scopeRead, http.StatusOK, reflect.TypeFor[readingDetail](), nil},
+	"GET /api/events":                {scopeRead, http.StatusOK, nil, nil},
+	"GET /api/costs":                 {scopeRead, http.StatusOK, reflect.TypeFor[Costs](), nil},
+	"GET /api/images/:id":            {scopeRead, http.StatusOK, nil, nil},
+	"GET /metrics":                   {scopeRead, http.StatusOK, nil, nil},
+	"POST /api/meters/:id/images":    {scopeWrite, http.StatusCreated, reflect.TypeFor[Job](), nil},
+	"GET /api/jobs/:id":              {scopeWrite, http.StatusOK, reflect.TypeFor[Job](), nil},
+	"POST /api/readings":             {scopeWrite, http.StatusCreated, reflect.TypeFor[SensorReading](), reflect.TypeFor[readingEdit]()},
+	"PATCH /api/readings/:id":        {scopeWrite, http.StatusOK, reflect.TypeFor[SensorReading](), reflect.TypeFor[readingEdit]()},
+	"DELETE /api/readings/:id":       {scopeWrite, http.StatusNoContent, nil, nil},
+	"POST /api/readings/:id/reread":  {scopeWrite, http.StatusOK, reflect.TypeFor[Candidate](), reflect.TypeFor[rereadRequest]()},
+	"GET /api/candidates":            {scopeWrite, http.StatusOK, reflect.TypeFor[[]Candidate](), nil},
+	"POST /api/candidates/:id/apply": {scopeWrite, http.StatusOK, reflect.TypeFor[Candidate](), reflect.TypeFor[rereadRequest]()},
+	"POST /api/reprocess":            {scopeAdmin, http.StatusAccepted, reflect.TypeFor[ReprocessJob](), reflect.TypeFor[reprocessRequest]()},
+	"GET /api/reprocess/:id":         {scopeAdmin, http.StatusOK, reflect.TypeFor[ReprocessJob](), nil},
+	"GET /api/audit":                 {scopeAdmin, http.StatusOK, reflect.TypeFor[[]AuditEntry](), nil},
+	"GET /api/examples":              {scopeAdmin, http.StatusOK, reflect.TypeFor[[]SensorReading](), nil},
+}
+
+// schemaTypes binds schemas that no typed field reaches, such as metadata held as any.
+var schemaTypes = map[string]reflect.Type{
+	"Error": reflect.TypeFor[struct {
+		Error string `json:"error"`
+	}](),
+	"SensorMetadata": reflect.TypeFor[Luggage](),
+}
+
+// TestOpenAPIContract checks that every registered route is in openapi.json
+// with the scope it is guarded by, and that the types the handlers use match its schemas.
+func TestOpenAPIContract(t *testing.T) {
+	t.Parallel()
+
+	var doc specDoc
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatalf("parse openapi.json: %v", err)
+	}
+	c := &schemaChecker{t: t, schemas: doc.Components.Schemas, seen: map[string]bool{}}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	// The guard answers with the scope it was made for instead of calling the handler.
+	routes(router, func(scope string) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.Header("X-Scope", scope)
+			c.AbortWithStatus(http.StatusNoContent)
+		}
+	})
+
+	registered := map[string]bool{}
+	for _, r := range router.Routes() {
+		key := r.Method + " " + r.Path
+		registered[key] = true
+		want, ok := apiContract[key]
+		if !ok {
+			t.Errorf("%s is not in apiContract", key)
+			continue
+		}
+		path := specPath(r.Path)
+		op, ok := doc.Paths[path][strings.ToLower(r.Method)]
+		if !ok {
+			t.Errorf("%s %s is not in openapi.json", r.Method, path)
+			continue
+		}
+		if op.Scope != want.scope {
+			t.Errorf("%s: x-scope %q, want %q", key, op.Scope, want.scope)
+		}
+		if want.scope != "" {
+			for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+				if res, ok := doc.response(op, status); !ok || res.json() == nil {
+					t.Errorf("%s: no %d response with an error body", key, status)
+				}
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(r.Method, strings.ReplaceAll(r.Path, ":id", "x"), nil))
+			if got := w.Header().Get("X-Scope"); got != want.scope {
+				t.Errorf("%s is guarded by %q, want %q", key, got, want.scope)
+			}
+		}
+
+		res, ok := doc.response(op, want.status)
+		if !ok {
+			t.Errorf("%s: no %d response", key, want.status)
+		}
+		if want.response != nil {
+			c.check(key+" response", res.json(), want.response)
+		}
+		if want.request != nil {
+			c.check(key+" request", op.RequestBody.json(), want.request)
+		}
+	}
+
+	for path, ops := range doc.Paths {
+		for method := range ops {
+			key := strings.ToUpper(method) + " " + ginPath(path)
+			if !registered[key] {
+				t.Errorf("openapi.json has %s, which is not registered", key)
+			}
+		}
+	}
+	for name, typ := range schemaTypes {
+		c.check(name, &specSchema{Ref: "#/components/schemas/" + name}, typ)
+	}
+	for name := range doc.Components.Schemas {
+		if !c.seen[name] {
+			t.Errorf("schema %s is not checked against any type", name)
+		}
+	}
+}
+
+func specPath(ginPath string) string {
+	parts := strings.Split(ginPath, "/")
+	for i, p := range parts {
+		if name, ok := strings.CutPrefix(p, ":"); ok {
+			parts[i] = "{" + name + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func ginPath(specPath string) string {
+	parts := strings.Split(specPath, "/")
+	for i, p := range parts {
+		if strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}") {
+			parts[i] = ":" + p[1:len(p)-1]
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// schemaChecker compares schemas with the JSON encoding of Go types.
+type schemaChecker struct {
+	t       *testing.T
+	schemas map[string]*specSchema
+	seen    map[string]bool // schemas checked
+	checked map[string]bool // schema and type pairs checked, to stop at cycles
+}
+
+// resolve follows $ref, and past a oneOf that only adds null.
+func (c *schemaChecker) resolve(s *specSchema) (*specSchema, string) {
+	var name string
+	for s != nil {
+		switch {
+		case s.Ref != "":
+			name = strings.TrimPrefix(s.Ref, "#/components/schemas/")
+			c.seen[name] = true
+			s = c.schemas[name]
+		case len(s.OneOf) > 0:
+			s = s.OneOf[0]
+		default:
+			return s, name
+		}
+	}
+	return nil, name
+}
+
+// properties gathers the properties of s and of the schemas it is allOf.
+func (c *schemaChecker) properties(s *specSchema) map[string]*specSchema {
+	props := map[string]*specSchema{}
+	for _, part := range s.AllOf {
+		p, _ := c.resolve(part)
+		if p != nil {
+			for k, v := range c.properties(p) {
+				props[k] = v
+			}
+		}
+	}
+	for k, v := range s.Properties {
+		props[k] = v
+	}
+	return props
+}
+
+func (c *schemaChecker) check(at string, s *specSchema, typ reflect.Type) {
+	c.t.Helper()
+	s, name := c.resolve(s)
+	if s == nil {
+		c.t.Errorf("%s: no schema for %s", at, typ)
+		return
+	}
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if name != "" {
+		if c.checked == nil {
+			c.checked = map[string]bool{}
+		}
+		key := name + " " + typ.String()
+		if c.checked[key] {
+			return
+		}
+		c.checked[key] = true
+		at = name
+	}
+
+	want := ""
+	switch {
+	case typ == reflect.TypeFor[time.Time](), typ == reflect.TypeFor[bson.ObjectID]():
+		want = "string"
+	case typ.Kind() == reflect.Interface:
+		return
+	case typ.Kind() == reflect.Struct:
+		fields := jsonFields(typ)
+		props := c.properties(s)
+		for name := range fields {
+			if _, ok := props[name]; !ok {
+				c.t.Errorf("%s: %s.%s is not in the schema", at, typ, name)
+			}
+		}
+		for name, p := range props {
+			f, ok := fields[name]
+			if !ok {
+				c.t.Errorf("%s: property %s is not in %s", at, name, typ)
+				continue
+			}
+			c.check(at+"."+name, p, f)
+		}
+		return
+	case typ.Kind() == reflect.Map:
+		if s.AdditionalProperties == nil {
+			c.t.Errorf("%s: %s needs additionalProperties", at, typ)
+			return
+		}
+		c.check(at+"[]", s.AdditionalProperties, typ.Elem())
+		return
+	case typ.Kind() == reflect.Slice:
+		if !s.is("array") || s.Items == nil {
+			c.t.Errorf("%s: %s needs an array of items", at, typ)
+			return
+		}
+		c.check(at+"[]", s.Items, typ.Elem())
+		return
+	case typ.Kind() == reflect.String:
+		want = "string"
+	case typ.Kind() == reflect.Bool:
+		want = "boolean"
+	case typ.Kind() >= reflect.Int && typ.Kind() <= reflect.Uint64:
+		want = "integer"
+	case typ.Kind() == reflect.Float32 || typ.Kind() == reflect.Float64:
+		want = "number"
+	default:
+		c.t.Errorf("%s: unexpected %s", at, typ)
+		return
+	}
+	if !s.is(want) {
+		c.t.Errorf("%s: type %v, want %s for %s", at, s.Type, want, typ)
+	}
+}
+
+// jsonFields returns the fields encoding/json writes for a struct type, by name.
+func jsonFields(typ reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := range typ.NumField() {
+		f := typ.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for k, v := range jsonFields(ft) {
+					fields[k] = v
+				}
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// apiSpec is openapi.json, parsed once for the response checks.
+var apiSpec = sync.OnceValues(func() (specDoc, error) {
+	var doc specDoc
+	err := json.Unmarshal(openAPISpec, &doc)
+	return doc, err
+})
+
+// bodyRecorder keeps a copy of the response body for conformsToSpec.
+type bodyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// conformsToSpec checks every response of a route in openapi.json: its status
+// must be declared there and a JSON body must match the schema of that status.
+// checked, if not nil, collects the routes it checked.
+func conformsToSpec(t *testing.T, checked map[string]bool) gin.HandlerFunc {
+	var mu sync.Mutex
+	return func(c *gin.Context) {
+		w := &bodyRecorder{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+
+		if c.FullPath() == "" {
+			return
+		}
+		key := c.Request.Method + " " + c.FullPath()
+		doc, err := apiSpec()
+		if err != nil {
+			t.Errorf("parse openapi.json: %v", err)
+			return
+		}
+		op, ok := doc.Paths[specPath(c.FullPath())][strings.ToLower(c.Request.Method)]
+		if !ok {
+			t.Errorf("%s is not in openapi.json", key)
+			return
+		}
+		res, ok := doc.response(op, w.Status())
+		if !ok {
+			t.Errorf("%s answered %d, which openapi.json does not declare: %s", key, w.Status(), &w.body)
+			return
+		}
+		if s := res.json(); s != nil {
+			var body any
+			if err := json.Unmarshal(w.body.Bytes(), &body); err != nil {
+				t.Errorf("%s %d: body is not JSON: %v", key, w.Status(), err)
+				return
+			}
+			v := valueChecker{schemas: doc.Components.Schemas}
+			v.check(fmt.Sprintf("%s %d", key, w.Status()), s, body)
+			for _, e := range v.errs {
+				t.Error(e)
+			}
+		}
+		if checked != nil {
+			mu.Lock()
+			checked[key] = true
+			mu.Unlock()
+		}
+	}
+}
+
+// valueChecker compares a decoded JSON value with a schema. Objects may only
+// have the properties their schema names, so that nothing is served undocumented.
+type valueChecker struct {
+	schemas map[string]*specSchema
+	errs    []string
+}
+
+func (c *valueChecker) errorf(format string, args ...any) {
+	c.errs = append(c.errs, fmt.Sprintf(format, args...))
+}
+
+// ref follows $ref.
+func (c *valueChecker) ref(s *specSchema) *specSchema {
+	for s != nil && s.Ref != "" {
+		s = c.schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	return s
+}
+
+// object gathers the properties and required names of s and of the schemas it is allOf.
+func (c *valueChecker) object(s *specSchema, props map[string]*specSchema, required map[string]bool) {
+	for _, part := range s.AllOf {
+		if p := c.ref(part); p != nil {
+			c.object(p, props, required)
+		}
+	}
+	for k, v := range s.Properties {
+		props[k] = v
+	}
+	for _, k := range s.Required {
+		required[k] = true
+	}
+}
+
+func (c *valueChecker) check(at string, s *specSchema, v any) {
+	s = c.ref(s)
+	if s == nil {
+		c.errorf("%s: no schema", at)
+		return
+	}
+	if len(s.OneOf) > 0 {
+		for _, alt := range s.OneOf {
+			try := valueChecker{schemas: c.schemas}
+			try.check(at, alt, v)
+			if len(try.errs) == 0 {
+				return
+			}
+		}
+		c.errorf("%s: %v matches no oneOf alternative", at, v)
+		return
+	}
+	if v == nil {
+		if !s.is("null") {
+			c.errorf("%s: null is not allowed", at)
+		}
+		return
+	}
+	if len(s.Enum) > 0 && !slices.Contains(s.Enum, v) {
+		c.errorf("%s: %v is not one of %v", at, v, s.Enum)
+	}
+	if s.Type == nil && len(s.AllOf) == 0 && len(s.Properties) == 0 {
+		return // any value
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		if s.Type != nil && !s.is("object") {
+			c.errorf("%s: object, want %v", at, s.Type)
+			return
+		}
+		props, required := map[string]*specSchema{}, map[string]bool{}
+		c.object(s, props, required)
+		for k := range required {
+			if _, ok := v[k]; !ok {
+				c.errorf("%s: required property %s is missing", at, k)
+			}
+		}
+		for k, e := range v {
+			switch p, ok := props[k]; {
+			case ok:
+				c.check(at+"."+k, p, e)
+			case s.AdditionalProperties != nil:
+				c.check(at+"["+k+"]", s.AdditionalProperties, e)
+			case len(props) > 0:
+				c.errorf("%s: property %s is not in the schema", at, k)
+			}
+		}
+	case []any:
+		if !s.is("array") {
+			c.errorf("%s: array, want %v", at, s.Type)
+			return
+		}
+		for i, e := range v {
+			c.check(fmt.Sprintf("%s[%d]", at, i), s.Items, e)
+		}
+	case string:
+		if !s.is("string") {
+			c.errorf("%s: string %q, want %v", at, v, s.Type)
+		}
+	case bool:
+		if !s.is("boolean") {
+			c.errorf("%s: boolean, want %v", at, s.Type)
+		}
+	case float64:
+		if !s.is("number") && !(s.is("integer") && v == math.Trunc(v)) {
+			c.errorf("%s: number %v, want %v", at, v, s.Type)
+		}
+	}
+}
+
+// stubVision answers every image with the same read.
+type stubVision struct{ read string }
+
+func (v stubVision) ReadGasGaugePic(ctx context.Context, r io.Reader, previous string) (*genai.GasMeterReadResult, error) {
+	return &genai.GasMeterReadResult{Read: v.read, Model: "stub", Usage: genai.Usage{PromptTokens: 1000, CompletionTokens: 10, Calls: 1}}, nil
+}
+
+func (v stubVision) ReadGasGaugePicFromURL(ctx context.Context, imageURL, previous string) (*genai.GasMeterReadResult, error) {
+	return v.ReadGasGaugePic(ctx, nil, previous)
+}
+
+// TestOpenAPIResponses sends requests to every route and checks the real
+// answers against openapi.json. The routes backed by MongoDB are only reached
+// when it is available. It sets the server globals, so it must not run in parallel.
+func TestOpenAPIResponses(t *testing.T) {
+	jpg, err := os.ReadFile(filepath.Join("sample", "ok.jpg"))
+	if err != nil {
+		t.Fatalf("read sample: %v", err)
+	}
+
+	oldConfig, oldCtx, oldCaptures, oldSensor := config, appCtx, captures, sensorServer
+	oldCosts, oldGate, oldStore, oldClient := costTracker, visionGate, imageStore, genaiClient
+	t.Cleanup(func() {
+		config, appCtx, captures, sensorServer = oldConfig, oldCtx, oldCaptures, oldSensor
+		costTracker, visionGate, imageStore, genaiClient = oldCosts, oldGate, oldStore, oldClient
+	})
+	config = &Config{Meters: []MeterConfig{{ID: "gas"}}}
+	appCtx = context.Background()
+	captures = &CaptureScheduler{next: func(f *Frame) {
+		f.reading = &Luggage{GasMeterReadResult: &genai.GasMeterReadResult{Read: "02924.457"}, MeterID: f.MeterID}
+		f.settle(nil)
+	}}
+	costTracker = &CostTracker{currency: "KRW"}
+	visionGate = NewVisionGate(LimitConfig{}, config.Meters, func(*Frame) {})
+	imageStore = imagestore.FS{Dir: t.TempDir()}
+	genaiClient = stubVision{read: "02924.5"}
+
+	// routes binds the handlers of the globals, so it is built again when they change.
+	checked := map[string]bool{}
+	var router *gin.Engine
+	newRouter := func() {
+		gin.SetMode(gin.TestMode)
+		router = gin.New()
+		router.Use(conformsToSpec(t, checked))
+		routes(router, func(string) gin.HandlerFunc {
+			return func(c *gin.Context) { c.Set(tokenRankKey, rank(scopeAdmin)) }
+		})
+	}
+	send := func(method, target, contentType string, body []byte) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, target, bytes.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	sendJSON := func(method, target, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		return send(method, target, "application/json", []byte(body))
+	}
+
+	// Readings stored before the metadata was flattened are served in the current shape.
+	sensorServer = &SensorServer{}
+	newRouter()
+	sendJSON(http.MethodGet, "/api/sensor", "")
+	sensorServer.Value, sensorServer.UpdatedAt, sensorServer.ID = 2924.457, time.Now(), bson.NewObjectID()
+	sensorServer.Metadata = normalizeMetadata(bson.D{
+		{Key: "gasmeterreadresult", Value: bson.D{
+			{Key: "read", Value: "02924.457"},
+			{Key: "date", Value: "2025-11-07 05:13:14"},
+			{Key: "readat", Value: bson.NewDateTimeFromTime(time.Now())},
+			{Key: "ittakes", Value: "2.5s"},
+		}},
+		{Key: "srcimageurl", Value: "/api/images/5e88.jpg"},
+	})
+	sendJSON(http.MethodGet, "/api/sensor", "")
+	sendJSON(http.MethodGet, "/api/health", "")
+	sendJSON(http.MethodGet, "/api/openapi.json", "")
+	sendJSON(http.MethodGet, "/metrics", "")
+	id, _ := imageStore.Put(context.Background(), bytes.NewReader(jpg), "image/jpeg")
+	sendJSON(http.MethodGet, "/api/images/"+id, "")
+	sendJSON(http.MethodGet, "/api/images/nope.jpg", "")
+	w := send(http.MethodPost, "/api/meters/gas/images", "image/jpeg", jpg)
+	var job Job
+	json.Unmarshal(w.Body.Bytes(), &job)
+	sendJSON(http.MethodGet, "/api/jobs/"+job.ID, "")
+	sendJSON(http.MethodGet, "/api/reprocess/nope", "")
+
+	mongoURI := os.Getenv("MONGO_URI")
+	if mongoURI == "" {
+		mongoURI = "mongodb://localhost:27017"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s, err := NewSensorServer(ctx, mongoURI, "mqvision_openapi_test")
+	if err != nil {
+		t.Skipf("Skipping the MongoDB routes: connection failed: %v", err)
+	}
+	defer func() {
+		_ = s.db.Drop(ctx)
+		_ = s.Close(ctx)
+	}()
+	sensorServer = s
+	if costTracker, err = NewCostTracker(ctx, s.db, genai.PriceTable{}, "KRW", 0); err != nil {
+		t.Fatalf("cost tracker: %v", err)
+	}
+	newRouter()
+
+	readID, err := s.SetValue(ctx, 2924.457, time.Now().Add(-time.Hour), &Luggage{
+		GasMeterReadResult: &genai.GasMeterReadResult{Read: "02924.457", ReadAt: time.Now()},
+		MeterID:            "gas",
+		SrcImageURL:        imageURL(id),
+		Timings:            &Timings{ReceivedAt: time.Now()},
+	})
+	if err != nil {
+		t.Fatalf("SetValue: %v", err)
+	}
+	reading := "/api/readings/" + readID.Hex()
+	sendJSON(http.MethodGet, "/api/sensor", "")
+	sendJSON(http.MethodGet, "/api/sensors", "")
+	sendJSON(http.MethodGet, "/api/costs", "")
+	sendJSON(http.MethodPatch, reading, `{"value": 2924.487, "reason": "8 read as 5"}`)
+	w = sendJSON(http.MethodPost, "/api/readings", `{"meter_id": "gas", "value": 2925.1, "reason": "read on site"}`)
+	var manual SensorReading
+	json.Unmarshal(w.Body.Bytes(), &manual)
+	sendJSON(http.MethodDelete, "/api/readings/"+manual.ID.Hex()+"?reason=duplicate", "")
+	sendJSON(http.MethodGet, reading, "")
+	sendJSON(http.MethodGet, "/api/audit", "")
+	sendJSON(http.MethodGet, "/api/examples", "")
+
+	w = sendJSON(http.MethodPost, reading+"/reread", "")
+	var cand Candidate
+	json.Unmarshal(w.Body.Bytes(), &cand)
+	sendJSON(http.MethodGet, "/api/candidates", "")
+	sendJSON(http.MethodPost, "/api/candidates/"+cand.ID.Hex()+"/apply", `{"reason": "reread"}`)
+	w = sendJSON(http.MethodPost, "/api/reprocess", fmt.Sprintf(`{"from": %q}`, time.Now().Add(-time.Minute).Format(time.RFC3339)))
+	var rj ReprocessJob
+	json.Unmarshal(w.Body.Bytes(), &rj)
+	sendJSON(http.MethodGet, "/api/reprocess/"+rj.ID, "")
+
+	for _, r := range router.Routes() {
+		key := r.Method + " " + r.Path
+		if key == "GET /api/events" {
+			continue // a stream that does not end; see TestEventsHandler
+		}
+		if !checked[key] {
+			t.Errorf("%s was not checked against openapi.json", key)
+		}
+	}
+}
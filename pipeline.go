@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/suapapa/mqvision/internal/genai"
+	"github.com/suapapa/mqvision/internal/logctx"
+	"github.com/suapapa/mqvision/internal/payload"
+	"github.com/suapapa/mqvision/internal/quality"
+	"github.com/suapapa/mqvision/internal/source"
+	"github.com/suapapa/mqvision/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Frame is one camera image on its way to the vision client.
+type Frame struct {
+	MeterID    string
+	Image      []byte
+	MIMEType   string
+	ReceivedAt time.Time
+	DeviceID   string          // camera that sent the frame, if its payload says so
+	DeviceTime time.Time       // capture time reported in the payload; zero if absent
+	Quality    *quality.Report // nil if the image could not be decoded
+	Skipped    int             // frames of the same burst dropped in favour of this one
+
+	ctx     context.Context // carries the frame's trace, which settle ends, and its log attributes
+	image   string          // hex sha256 of Image, as logged and archived
+	done    chan error      // receives the frame's fate once; nil when nobody waits
+	reading *Luggage        // the reading, once the vision client read the frame
+}
+
+var tracer = otel.Tracer("github.com/suapapa/mqvision")
+
+// context returns the context of f's trace and log attributes, or appCtx for a frame made without one.
+func (f *Frame) context() context.Context {
+	if f == nil || f.ctx == nil {
+		return appCtx
+	}
+	return f.ctx
+}
+
+// Fates of a frame that end without a reading but need no redelivery (see ignored).
+var (
+	errFrameSkipped = errors.New("frame skipped for a better one of its burst")
+	errFrameDropped = errors.New("frame dropped by the vision rate limit")
+)
+
+// errNotStored marks a frame whose reading could not be stored; redelivering it may succeed.
+var errNotStored = errors.New("reading not stored")
+
+// errInvalidRead marks a read the model returned that is not a number.
+var errInvalidRead = errors.New("read is not a number")
+
+// settle reports the fate of f to whoever waits in ingest. Only the first call counts.
+func (f *Frame) settle(err error) {
+	if f == nil || f.done == nil {
+		return
+	}
+	select {
+	case f.done <- err:
+		span := trace.SpanFromContext(f.context())
+		if err != nil {
+			reason := rejectReason(err)
+			readingsRejected.WithLabelValues(f.MeterID, reason).Inc()
+			span.SetAttributes(attribute.String("mqvision.rejected", reason))
+			bus.Publish(eventRejected, rejection{MeterID: f.MeterID, Image: f.image, Reason: reason, Error: err.Error()})
+			if ignored(err) {
+				// Set aside by policy; the trace did not fail.
+				err = nil
+			}
+		}
+		tracing.End(span, err)
+	default:
+	}
+}
+
+// ignored reports whether a frame was set aside by policy rather than failed.
+func ignored(err error) bool {
+	return errors.Is(err, errFrameSkipped) || errors.Is(err, errFrameDropped) || errors.Is(err, errFrameRetaken)
+}
+
+// ingestFrame hands f to the ingestion stage and waits until it is settled.
+// It returns nil once the reading is stored, and otherwise why f ended without one.
+func ingestFrame(f *Frame) error {
+	if err := appCtx.Err(); err != nil {
+		return err
+	}
+	f.done = make(chan error, 1)
+	go captures.Submit(f)
+
+	select {
+	case err := <-f.done:
+		return err
+	case <-appCtx.Done():
+		return appCtx.Err()
+	}
+}
+
+// ingest is ingestFrame for sources that can redeliver. Only failures a redelivery
+// could fix, storing and shutting down, are returned so the source keeps the image;
+// frames set aside by policy or that failed to read are accepted.
+func ingest(f *Frame) error {
+	err := ingestFrame(f)
+	if err != nil && (errors.Is(err, errNotStored) || appCtx.Err() != nil) {
+		return err
+	}
+	return nil
+}
+
+// receiver is where every source of meterID delivers: it decodes a message and
+// ingests it, returning an error only when the source should deliver it again.
+func receiver(meterID, sourceType string, decoder payload.Decoder) source.Handler {
+	return func(msg []byte) error {
+		if len(msg) == 0 {
+			slog.Warn("Ignoring empty message", "meter", meterID, "source", sourceType)
+			return nil
+		}
+		f, err := receive(meterID, sourceType, decoder, msg)
+		if err != nil {
+			// A malformed message stays malformed; accept it instead of having it redelivered.
+			err = fmt.Errorf("decode payload: %w", err)
+			slog.Error("Error decoding message", "meter", meterID, "source", sourceType, "err", err)
+			publisher.PublishError(meterID, err)
+			return nil
+		}
+		return ingest(f)
+	}
+}
+
+// receive counts a message of meterID, starts the trace of its image and decodes
+// it into a frame. The trace ends when the frame settles, or here if msg cannot be decoded.
+// Records logged with the frame's context carry the meter, source and image hash.
+func receive(meterID, sourceType string, decoder payload.Decoder, msg []byte) (*Frame, error) {
+	messagesReceived.WithLabelValues(meterID, sourceType).Inc()
+	payloadBytes.WithLabelValues(meterID, sourceType).Observe(float64(len(msg)))
+
+	ctx, span := tracer.Start(appCtx, "image", trace.WithAttributes(
+		attribute.String("mqvision.meter_id", meterID),
+		attribute.String("mqvision.source", sourceType),
+		attribute.Int("mqvision.payload_bytes", len(msg)),
+	))
+	_, decodeSpan := tracer.Start(ctx, "payload.Decode")
+	p, err := decoder.Decode(msg)
+	tracing.End(decodeSpan, err)
+	if err != nil {
+		tracing.End(span, err)
+		return nil, err
+	}
+	return newFrame(logctx.With(ctx, slog.String("source", sourceType)), meterID, p), nil
+}
+
+// newFrame makes a frame of meterID from a decoded payload and reports it received; ctx carries its trace.
+// The image hash it logs is the one its archived copy is named after.
+func newFrame(ctx context.Context, meterID string, p payload.Payload) *Frame {
+	sum := sha256.Sum256(p.Image)
+	image := hex.EncodeToString(sum[:])
+	bus.Publish(eventProgress, progress{MeterID: meterID, Image: image, Stage: stageReceived})
+	return &Frame{
+		ctx:        logctx.With(ctx, slog.String("meter", meterID), slog.String("image", image)),
+		image:      image,
+		MeterID:    meterID,
+		Image:      p.Image,
+		MIMEType:   p.MIMEType,
+		ReceivedAt: time.Now(),
+		DeviceID:   p.DeviceID,
+		DeviceTime: p.CapturedAt,
+	}
+}
+
+// Timings break down how long a frame took to read, in seconds.
+type Timings struct {
+	ReceivedAt time.Time `json:"received_at" bson:"received_at"`
+	// Queued is the wait for the burst window and the vision rate limit.
+	Queued  float64 `json:"queued_seconds" bson:"queued_seconds"`
+	Archive float64 `json:"archive_seconds,omitempty" bson:"archive_seconds,omitempty"`
+	Vision  float64 `json:"vision_seconds" bson:"vision_seconds"`
+}
+
+// processFrame reads the gauge in f and hands the result to the storage goroutine.
+func processFrame(f *Frame) {
+	f.ctx = logctx.With(f.context(), slog.String("model", config.OpenAICompat.Model))
+	ctx := f.ctx
+	bus.Publish(eventProgress, progress{MeterID: f.MeterID, Image: f.image, Stage: stageReading})
+	l, err := readImage(ctx, f)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error reading gauge image", "err", err)
+		if res := genai.Partial(err); res != nil {
+			keepRejectedExchange(ctx, f, res.Exchange, err)
+		}
+		publisher.PublishError(f.MeterID, err)
+		f.settle(err)
+		return
+	}
+	l.frame = f
+	f.reading = l
+	l.MeterID = f.MeterID
+	l.Quality = f.Quality
+	l.FramesSkipped = f.Skipped
+	l.DeviceID = f.DeviceID
+	l.TraceID = tracing.TraceID(ctx)
+	l.PromptVersion = config.promptVersion
+	if !f.DeviceTime.IsZero() {
+		l.DeviceCapturedAt = &f.DeviceTime
+	}
+	checkCameraClock(ctx, l, config.CameraClock.location, config.CameraClock.MaxDrift)
+	slog.InfoContext(ctx, "Read gauge",
+		"read", l.Read, "date", l.Date, "ambiguous", l.Ambiguous, "took", l.ItTakes,
+		"prompt_tokens", l.Usage.PromptTokens, "completion_tokens", l.Usage.CompletionTokens, "cost", l.Cost)
+
+	chLuggage <- l
+}
+
+// Reasons the on-image timestamp is not used as the reading's time.
+const (
+	timestampUnparsable = "unparsable"
+	timestampStale      = "stale"       // older than the read: a delayed frame or a slow camera clock
+	timestampClockAhead = "clock_ahead" // newer than the read: the camera clock runs fast
+)
+
+// checkCameraClock parses the date the model transcribed from the image and
+// compares it with the time of reading to decide whether it can time the reading.
+func checkCameraClock(ctx context.Context, l *Luggage, loc *time.Location, maxDrift time.Duration) {
+	capturedAt, err := genai.ParseMeterDate(l.Date, loc)
+	if err != nil {
+		slog.WarnContext(ctx, "Cannot use on-image date", "date", l.Date, "err", err)
+		l.TimestampIssue = timestampUnparsable
+		return
+	}
+	l.CapturedAt = &capturedAt
+
+	readAt := l.ReadAt
+	if readAt.IsZero() {
+		readAt = time.Now()
+	}
+	drift := readAt.Sub(capturedAt)
+	l.ClockDrift = drift.Seconds()
+
+	switch {
+	case drift > maxDrift:
+		l.TimestampIssue = timestampStale
+	case drift < -maxDrift:
+		l.TimestampIssue = timestampClockAhead
+	default:
+		l.TimestampTrusted = true
+		return
+	}
+	slog.WarnContext(ctx, "On-image time is off the read time",
+		"captured_at", capturedAt.Format(time.RFC3339), "drift", drift.Round(time.Second), "issue", l.TimestampIssue)
+}
+
+// readImage streams the frame to the vision client and the configured sinks at
+// once and prices the vision calls; src_image_url comes from the archive sink,
+// waited for only up to archiveGrace after the vision call.
+// It refuses to call the model once the monthly budget is spent.
+func readImage(ctx context.Context, f *Frame) (*Luggage, error) {
+	if costTracker.Paused() {
+		return nil, errBudgetExceeded
+	}
+	if f.MIMEType == "" {
+		f.MIMEType = genai.ImageMIMEType(f.Image)
+	}
+
+	start := time.Now()
+	timings := &Timings{ReceivedAt: f.ReceivedAt, Queued: start.Sub(f.ReceivedAt).Seconds()}
+	vision := Sink{Name: sinkVision, Wait: true, Consume: func(ctx context.Context, f *Frame, r io.Reader) (any, error) {
+		res, err := genaiClient.ReadGasGaugePic(ctx, r, previousReads.Get(f.MeterID))
+		took := time.Since(start)
+		observeVision(f.MeterID, took, res, err)
+		timings.Vision = took.Seconds()
+		return res, err
+	}}
+	results, background := fanOut(ctx, f, append([]Sink{vision}, imageSinks...))
+	var images <-chan imageURLs
+	if done, ok := background[sinkArchive]; ok {
+		images = archivedImages(done)
+	}
+
+	r := results[sinkVision]
+	if r.Err != nil {
+		// A failed read may still have been billed.
+		if _, err := costTracker.Record(ctx, genai.Partial(r.Err)); err != nil {
+			slog.ErrorContext(ctx, "Error recording LLM cost", "err", err)
+		}
+		return nil, r.Err
+	}
+	readResult, _ := r.Value.(*genai.GasMeterReadResult)
+	if readResult == nil {
+		return nil, fmt.Errorf("read result is nil")
+	}
+
+	cost, err := costTracker.Record(ctx, readResult)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error recording LLM cost", "err", err)
+	}
+
+	l := &Luggage{
+		GasMeterReadResult: readResult,
+		Cost:               cost,
+		Timings:            timings,
+	}
+	l.awaitImages(ctx, images, archiveGrace)
+	return l, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/suapapa/mqvision/internal/genai"
+	"github.com/suapapa/mqvision/internal/payload"
+	"github.com/suapapa/mqvision/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestCheckCameraClock(t *testing.T) {
+	t.Parallel()
+
+	kst := time.FixedZone("KST", 9*60*60)
+	readAt := time.Date(2025, 11, 7, 5, 14, 0, 0, kst)
+
+	tests := []struct {
+		name        string
+		date        string
+		wantTrusted bool
+		wantIssue   string
+	}{
+		{name: "in sync", date: "2025-11-07T05:13:17+09:00", wantTrusted: true},
+		{name: "stale frame", date: "2025-11-07T04:13:17+09:00", wantIssue: timestampStale},
+		{name: "camera clock ahead", date: "2025-11-07T06:13:17+09:00", wantIssue: timestampClockAhead},
+		{name: "unreadable", date: "??", wantIssue: timestampUnparsable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			l := &Luggage{GasMeterReadResult: &genai.GasMeterReadResult{Date: tt.date, ReadAt: readAt}}
+			checkCameraClock(context.Background(), l, kst, 10*time.Minute)
+			if l.TimestampTrusted != tt.wantTrusted || l.TimestampIssue != tt.wantIssue {
+				t.Fatalf("trusted=%v issue=%q, want trusted=%v issue=%q",
+					l.TimestampTrusted, l.TimestampIssue, tt.wantTrusted, tt.wantIssue)
+			}
+			if tt.wantIssue != timestampUnparsable && l.CapturedAt == nil {
+				t.Fatal("CapturedAt not set")
+			}
+		})
+	}
+}
+
+// TestFrameTrace installs a global tracer provider, so it must not run in parallel.
+func TestFrameTrace(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	otel.SetTracerProvider(tp)
+	defer tp.Shutdown(context.Background())
+	appCtx = context.Background()
+
+	tests := []struct {
+		name       string
+		msg        []byte
+		fate       error
+		wantStatus codes.Code
+	}{
+		{name: "stored", msg: []byte("\xff\xd8\xff\xe0 jpeg"), wantStatus: codes.Unset},
+		{name: "skipped", msg: []byte("\xff\xd8\xff\xe0 jpeg"), fate: errFrameSkipped, wantStatus: codes.Unset},
+		{name: "failed", msg: []byte("\xff\xd8\xff\xe0 jpeg"), fate: errors.New("model down"), wantStatus: codes.Error},
+		{name: "undecodable", msg: []byte("hello"), wantStatus: codes.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec.Reset()
+			f, err := receive("trace-test", sourceUpload, payload.Raw{}, tt.msg)
+			if err == nil {
+				if tracing.TraceID(f.context()) == "" {
+					t.Fatal("frame is not traced")
+				}
+				f.done = make(chan error, 1)
+				f.settle(tt.fate)
+			}
+
+			spans := rec.Ended()
+			if len(spans) != 2 {
+				t.Fatalf("ended %d spans, want decode and image", len(spans))
+			}
+			decode, root := spans[0], spans[1]
+			if decode.Name() != "payload.Decode" || root.Name() != "image" {
+				t.Fatalf("spans %s, %s", decode.Name(), root.Name())
+			}
+			if decode.Parent().SpanID() != root.SpanContext().SpanID() {
+				t.Error("decode span is not a child of the image span")
+			}
+			if got := root.Status().Code; got != tt.wantStatus {
+				t.Errorf("image span status = %v, want %v", got, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,567 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/suapapa/mqvision/internal/genai"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// auditCollection records every manual change of the readings.
+const auditCollection = "audit_log"
+
+// Audit actions.
+const (
+	auditCreate  = "create"
+	auditCorrect = "correct"
+	auditDelete  = "delete"
+)
+
+// auditLimit caps the entries and examples returned at once.
+const auditLimit = 100
+
+// notDeleted matches the deleted_at of readings that are not soft-deleted.
+var notDeleted = bson.M{"$exists": false}
+
+var errReadingNotFound = errors.New("reading not found")
+
+// Correction records who corrected a reading by hand, when and why.
+type Correction struct {
+	// OriginalValue is the value before the first correction.
+	OriginalValue float64 `json:"original_value" bson:"original_value"`
+	// By is the name of the token the correction was made with; Note is
+	// what the request said about its author.
+	By     string    `json:"by" bson:"by"`
+	Note   string    `json:"note,omitempty" bson:"note,omitempty"`
+	At     time.Time `json:"at" bson:"at"`
+	Reason string    `json:"reason" bson:"reason"`
+}
+
+// AuditEntry is one manual change of a reading.
+type AuditEntry struct {
+	ID        bson.ObjectID `json:"id" bson:"_id,omitempty"`
+	At        time.Time     `json:"at" bson:"at"`
+	By        string        `json:"by" bson:"by"`                         // name of the token
+	Note      string        `json:"note,omitempty" bson:"note,omitempty"` // the by of the request
+	Action    string        `json:"action" bson:"action"`
+	ReadingID bson.ObjectID `json:"reading_id" bson:"reading_id"`
+	MeterID   string        `json:"meter_id,omitempty" bson:"meter_id,omitempty"`
+	Reason    string        `json:"reason" bson:"reason"`
+	Before    *float64      `json:"before,omitempty" bson:"before,omitempty"`
+	After     *float64      `json:"after,omitempty" bson:"after,omitempty"`
+}
+
+// manualReading is the metadata of a reading entered by hand.
+type manualReading struct {
+	MeterID string `json:"meter_id" bson:"meter_id"`
+	Read    string `json:"read" bson:"read"`
+}
+
+// readingEdit is the body of the readings API. MeterID and ReadAt only apply to new readings.
+type readingEdit struct {
+	MeterID string     `json:"meter_id"`
+	Value   *float64   `json:"value"`
+	ReadAt  *time.Time `json:"read_at"`
+	By      string     `json:"by"`
+	Reason  string     `json:"reason"`
+}
+
+// editor is who changes a reading: the name of the token it is changed with,
+// which a request cannot choose, and the free-text by of the request.
+type editor struct {
+	By   string
+	Note string
+}
+
+// editor returns who makes the edit in c.
+func (e readingEdit) editor(c *gin.Context) editor {
+	return editor{By: tokenName(c), Note: strings.TrimSpace(e.By)}
+}
+
+// Reading returns the reading id unless it is deleted.
+func (s *SensorServer) Reading(ctx context.Context, id bson.ObjectID) (SensorReading, error) {
+	var r SensorReading
+	err := s.collection.FindOne(ctx, bson.M{"_id": id, "deleted_at": notDeleted}).Decode(&r)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return r, errReadingNotFound
+	}
+	if err != nil {
+		return r, fmt.Errorf("find reading: %w", err)
+	}
+	r.Metadata = normalizeMetadata(r.Metadata)
+	return r, nil
+}
+
+// CorrectReading replaces the value of reading id, keeping the value the model read,
+// and records the change in the audit log.
+func (s *SensorServer) CorrectReading(ctx context.Context, id bson.ObjectID, value float64, by editor, reason string) (SensorReading, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	r, err := s.Reading(ctx, id)
+	if err != nil {
+		return r, err
+	}
+	before := r.Value
+	c := Correction{OriginalValue: r.Value, By: by.By, Note: by.Note, At: time.Now(), Reason: reason}
+	if r.Correction != nil {
+		c.OriginalValue = r.Correction.OriginalValue
+	}
+	// Time series collections only take multi-document updates.
+	if _, err := s.collection.UpdateMany(ctx, bson.M{"_id": id},
+		bson.M{"$set": bson.M{"value": value, "correction": c}}); err != nil {
+		return r, fmt.Errorf("update reading: %w", err)
+	}
+	r.Value, r.Correction = value, &c
+
+	if err := s.loadLatest(ctx); err != nil {
+		return r, err
+	}
+	return r, s.record(ctx, AuditEntry{At: c.At, By: by.By, Note: by.Note, Action: auditCorrect, ReadingID: id,
+		MeterID: readingMeter(r), Reason: reason, Before: &before, After: &value})
+}
+
+// SetImages records the archived images of reading id, which were not ready
+// when it was stored.
+func (s *SensorServer) SetImages(ctx context.Context, id bson.ObjectID, u imageURLs) error {
+	set := bson.M{"metadata.src_image_url": u.Source, "metadata.timings.archive_seconds": u.Took.Seconds()}
+	if u.Thumbnail != "" {
+		set["metadata.thumbnail_url"] = u.Thumbnail
+	}
+	if u.Crop != "" {
+		set["metadata.crop_url"] = u.Crop
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	// Time series collections only take multi-document updates.
+	if _, err := s.collection.UpdateMany(ctx, bson.M{"_id": id}, bson.M{"$set": set}); err != nil {
+		return fmt.Errorf("update reading images: %w", err)
+	}
+	if s.ID == id {
+		return s.loadLatest(ctx)
+	}
+	return nil
+}
+
+// DeleteReading soft-deletes reading id, records it in the audit log and
+// returns the deleted reading.
+func (s *SensorServer) DeleteReading(ctx context.Context, id bson.ObjectID, by editor, reason string) (SensorReading, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	r, err := s.Reading(ctx, id)
+	if err != nil {
+		return r, err
+	}
+	now := time.Now()
+	if _, err := s.collection.UpdateMany(ctx, bson.M{"_id": id},
+		bson.M{"$set": bson.M{"deleted_at": now}}); err != nil {
+		return r, fmt.Errorf("delete reading: %w", err)
+	}
+
+	if err := s.loadLatest(ctx); err != nil {
+		return r, err
+	}
+	return r, s.record(ctx, AuditEntry{At: now, By: by.By, Note: by.Note, Action: auditDelete, ReadingID: id,
+		MeterID: readingMeter(r), Reason: reason, Before: &r.Value})
+}
+
+// AddManualReading stores a reading taken by hand from meterID and records it in the audit log.
+func (s *SensorServer) AddManualReading(ctx context.Context, meterID string, value float64, at time.Time, by editor, reason string) (SensorReading, error) {
+	r := SensorReading{
+		ID:        bson.NewObjectID(),
+		Value:     value,
+		UpdatedAt: at,
+		Metadata:  manualReading{MeterID: meterID, Read: formatRead(value)},
+		Manual:    true,
+	}
+	if _, err := s.insert(ctx, r); err != nil {
+		return r, err
+	}
+	return r, s.record(ctx, AuditEntry{At: time.Now(), By: by.By, Note: by.Note, Action: auditCreate, ReadingID: r.ID,
+		MeterID: meterID, Reason: reason, After: &value})
+}
+
+func (s *SensorServer) record(ctx context.Context, e AuditEntry) error {
+	if _, err := s.audit.InsertOne(ctx, e); err != nil {
+		return fmt.Errorf("record audit entry: %w", err)
+	}
+	slog.InfoContext(ctx, "Reading changed by hand", "action", e.Action, "reading", e.ReadingID.Hex(),
+		"meter", e.MeterID, "by", e.By, "note", e.Note, "reason", e.Reason)
+	return nil
+}
+
+// AuditLog returns the latest audit entries, of reading id only unless it is zero.
+func (s *SensorServer) AuditLog(ctx context.Context, id bson.ObjectID) ([]AuditEntry, error) {
+	filter := bson.M{}
+	if !id.IsZero() {
+		filter["reading_id"] = id
+	}
+	cursor, err := s.audit.Find(ctx, filter, options.Find().SetSort(bson.M{"at": -1}).SetLimit(auditLimit))
+	if err != nil {
+		return nil, fmt.Errorf("find audit entries: %w", err)
+	}
+	entries := []AuditEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, fmt.Errorf("decode audit entries: %w", err)
+	}
+	return entries, nil
+}
+
+// Examples returns the latest corrected readings that have a source image,
+// candidates for few-shot examples of how the meter reads, of meterID unless empty.
+func (s *SensorServer) Examples(ctx context.Context, meterID string) ([]SensorReading, error) {
+	filter := bson.M{
+		"correction":             bson.M{"$exists": true},
+		"deleted_at":             notDeleted,
+		"metadata.src_image_url": bson.M{"$nin": bson.A{nil, ""}},
+	}
+	if meterID != "" {
+		filter["metadata.meter_id"] = meterID
+	}
+	cursor, err := s.collection.Find(ctx, filter, options.Find().SetSort(bson.M{"updated_at": -1}).SetLimit(auditLimit))
+	if err != nil {
+		return nil, fmt.Errorf("find examples: %w", err)
+	}
+	readings := []SensorReading{}
+	if err := cursor.All(ctx, &readings); err != nil {
+		return nil, fmt.Errorf("decode examples: %w", err)
+	}
+	for i := range readings {
+		readings[i].Metadata = normalizeMetadata(readings[i].Metadata)
+	}
+	return readings, nil
+}
+
+// Latest returns the cached latest reading.
+func (s *SensorServer) Latest() SensorReading {
+	s.RLock()
+	defer s.RUnlock()
+	return SensorReading{ID: s.ID, Value: s.Value, UpdatedAt: s.UpdatedAt, Metadata: s.Metadata}
+}
+
+// readingMeter returns the meter of a reading from its normalized metadata.
+func readingMeter(r SensorReading) string {
+	if m, ok := r.Metadata.(map[string]any); ok {
+		id, _ := m["meter_id"].(string)
+		return id
+	}
+	return ""
+}
+
+func formatRead(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// LatestReading returns the latest reading of meterID that is not deleted,
+// taken before before unless it is zero.
+func (s *SensorServer) LatestReading(ctx context.Context, meterID string, before time.Time) (SensorReading, error) {
+	filter := bson.M{"metadata.meter_id": meterID, "deleted_at": notDeleted}
+	if !before.IsZero() {
+		filter["updated_at"] = bson.M{"$lt": before}
+	}
+	var r SensorReading
+	err := s.collection.FindOne(ctx, filter, options.FindOne().SetSort(bson.M{"updated_at": -1})).Decode(&r)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return r, errReadingNotFound
+	}
+	if err != nil {
+		return r, fmt.Errorf("find latest reading: %w", err)
+	}
+	r.Metadata = normalizeMetadata(r.Metadata)
+	return r, nil
+}
+
+// PreviousReads holds the read of each meter that the vision client settles
+// the ambiguous digits of the meter's next image against.
+type PreviousReads struct {
+	mu    sync.Mutex
+	reads map[string]string
+}
+
+var previousReads = &PreviousReads{reads: make(map[string]string)}
+
+// Get returns the previous read of meterID, empty if there is none.
+func (p *PreviousReads) Get(meterID string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.reads[meterID]
+}
+
+// Set makes read the previous read of meterID.
+func (p *PreviousReads) Set(meterID, read string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reads[meterID] = read
+}
+
+// usePreviousRead makes the latest reading of meterID, corrected or entered by
+// hand, the previous read of the meter and its value in mqvision_meter_value,
+// and returns it; ok is false when the meter has no reading or it could not be
+// loaded.
+func usePreviousRead(ctx context.Context, s *SensorServer, meterID string) (r SensorReading, ok bool) {
+	r, err := s.LatestReading(ctx, meterID, time.Time{})
+	switch {
+	case errors.Is(err, errReadingNotFound):
+		previousReads.Set(meterID, "")
+		meterValue.DeleteLabelValues(meterID)
+	case err != nil:
+		slog.ErrorContext(ctx, "Error loading the previous read", "meter", meterID, "err", err)
+	default:
+		previousReads.Set(meterID, formatRead(r.Value))
+		meterValue.WithLabelValues(meterID).Set(r.Value)
+		return r, true
+	}
+	return r, false
+}
+
+// usePreviousReads loads the previous read of every configured meter.
+func usePreviousReads(ctx context.Context, s *SensorServer) {
+	for _, m := range config.Meters {
+		usePreviousRead(ctx, s, m.ID)
+	}
+}
+
+// readingID parses the :id of a readings route, answering 400 when it is malformed.
+func readingID(c *gin.Context) (bson.ObjectID, bool) {
+	id, err := bson.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid reading id"})
+		return id, false
+	}
+	return id, true
+}
+
+// readingError answers for a failed change of a reading.
+func readingError(c *gin.Context, err error) {
+	if errors.Is(err, errReadingNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// CreateReadingHandler stores a reading taken by hand from the meter.
+func (s *SensorServer) CreateReadingHandler(c *gin.Context) {
+	var e readingEdit
+	if err := c.ShouldBindJSON(&e); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if e.MeterID == "" {
+		e.MeterID = config.Meters[0].ID
+	}
+	switch {
+	case !config.hasMeter(e.MeterID):
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("unknown meter %q", e.MeterID)})
+		return
+	case e.Value == nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "value is required"})
+		return
+	}
+	at := time.Now()
+	if e.ReadAt != nil {
+		at = *e.ReadAt
+	}
+
+	r, err := s.AddManualReading(c.Request.Context(), e.MeterID, *e.Value, at, e.editor(c), e.Reason)
+	if err != nil {
+		readingError(c, err)
+		return
+	}
+	usePreviousRead(c.Request.Context(), s, e.MeterID)
+	c.Header("Location", "/api/readings/"+r.ID.Hex())
+	c.JSON(http.StatusCreated, r)
+}
+
+// CorrectReadingHandler corrects the value of a reading; a reason is required.
+func (s *SensorServer) CorrectReadingHandler(c *gin.Context) {
+	id, ok := readingID(c)
+	if !ok {
+		return
+	}
+	var e readingEdit
+	if err := c.ShouldBindJSON(&e); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	switch {
+	case e.Value == nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "value is required"})
+		return
+	case strings.TrimSpace(e.Reason) == "":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required"})
+		return
+	}
+
+	r, err := s.CorrectReading(c.Request.Context(), id, *e.Value, e.editor(c), e.Reason)
+	if err != nil {
+		readingError(c, err)
+		return
+	}
+	usePreviousRead(c.Request.Context(), s, readingMeter(r))
+	if !hasScope(c, scopeAdmin) {
+		r.Metadata = withoutExchange(r.Metadata)
+	}
+	c.JSON(http.StatusOK, r)
+}
+
+// DeleteReadingHandler soft-deletes a reading. The reason and a note on the
+// author come from the ?reason= and ?by= query parameters; a reason is required.
+func (s *SensorServer) DeleteReadingHandler(c *gin.Context) {
+	id, ok := readingID(c)
+	if !ok {
+		return
+	}
+	e := readingEdit{By: c.Query("by"), Reason: c.Query("reason")}
+	if strings.TrimSpace(e.Reason) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required"})
+		return
+	}
+
+	r, err := s.DeleteReading(c.Request.Context(), id, e.editor(c), e.Reason)
+	if err != nil {
+		readingError(c, err)
+		return
+	}
+	usePreviousRead(c.Request.Context(), s, readingMeter(r))
+	c.Status(http.StatusNoContent)
+}
+
+// GetAuditHandler lists the latest manual changes, of one reading with ?reading=<id>.
+func (s *SensorServer) GetAuditHandler(c *gin.Context) {
+	var id bson.ObjectID
+	if v := c.Query("reading"); v != "" {
+		var err error
+		if id, err = bson.ObjectIDFromHex(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid reading id"})
+			return
+		}
+	}
+	entries, err := s.AuditLog(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+// GetExamplesHandler lists corrected readings with their images, of one meter with ?meter=<id>.
+func (s *SensorServer) GetExamplesHandler(c *gin.Context) {
+	readings, err := s.Examples(c.Request.Context(), c.Query("meter"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, readings)
+}
+
+// Where the value of a reading came from.
+const (
+	readingAccepted  = "accepted" // read by the model and validated
+	readingCorrected = "corrected"
+	readingManual    = "manual"
+)
+
+// readingDetail is a reading with its image references, validation outcome,
+// raw model answers and audit trail. The parsed answer, prompt version and
+// timings are in Metadata.
+type readingDetail struct {
+	SensorReading
+	Images     readingImages     `json:"images"`
+	Validation readingValidation `json:"validation"`
+	// Exchange and Audit are left out for tokens below admin, like /api/audit.
+	Exchange *genai.Exchange `json:"exchange,omitempty"`
+	Audit    []AuditEntry    `json:"audit,omitempty"`
+}
+
+type readingImages struct {
+	Source    string `json:"source,omitempty"`
+	Thumbnail string `json:"thumbnail,omitempty"`
+	Crop      string `json:"crop,omitempty"`
+}
+
+type readingValidation struct {
+	// Status is where Value came from: accepted, corrected or manual.
+	Status            string  `json:"status"`
+	TimestampTrusted  bool    `json:"timestamp_trusted"`
+	TimestampIssue    string  `json:"timestamp_issue,omitempty"`
+	ClockDriftSeconds float64 `json:"clock_drift_seconds,omitempty"`
+}
+
+func newReadingDetail(r SensorReading, audit []AuditEntry) readingDetail {
+	m, _ := r.Metadata.(map[string]any)
+	str := func(k string) string { v, _ := m[k].(string); return v }
+
+	d := readingDetail{
+		SensorReading: r,
+		Images:        readingImages{Source: str("src_image_url"), Thumbnail: str("thumbnail_url"), Crop: str("crop_url")},
+		Audit:         audit,
+	}
+	if v, ok := m["exchange"]; ok {
+		// Lift the exchange kept with the reading out of its metadata.
+		var ex genai.Exchange
+		if raw, err := bson.Marshal(v); err == nil && bson.Unmarshal(raw, &ex) == nil {
+			d.Exchange = &ex
+			delete(m, "exchange")
+		}
+	}
+	d.Validation.TimestampTrusted, _ = m["timestamp_trusted"].(bool)
+	d.Validation.TimestampIssue = str("timestamp_issue")
+	d.Validation.ClockDriftSeconds, _ = m["clock_drift_seconds"].(float64)
+	switch {
+	case r.Manual:
+		d.Validation.Status = readingManual
+	case r.Correction != nil:
+		d.Validation.Status = readingCorrected
+	default:
+		d.Validation.Status = readingAccepted
+	}
+	return d
+}
+
+// GetReadingHandler returns one reading with everything that went into it;
+// its audit trail and model exchange only to admin tokens.
+func (s *SensorServer) GetReadingHandler(c *gin.Context) {
+	id, ok := readingID(c)
+	if !ok {
+		return
+	}
+	r, err := s.Reading(c.Request.Context(), id)
+	if err != nil {
+		readingError(c, err)
+		return
+	}
+	if !hasScope(c, scopeAdmin) {
+		d := newReadingDetail(r, nil)
+		d.Exchange = nil
+		c.JSON(http.StatusOK, d)
+		return
+	}
+	audit, err := s.AuditLog(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	d := newReadingDetail(r, audit)
+	if d.Exchange == nil {
+		// Not kept with the reading; it may be in the exchange collection.
+		if d.Exchange, err = s.Exchange(c.Request.Context(), id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, d)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/suapapa/mqvision/internal/genai"
+)
+
+func readingsRouter(t *testing.T, s *SensorServer) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(conformsToSpec(t, nil))
+	// Requests act as an admin token, which sees the audit trail.
+	router.Use(func(c *gin.Context) { c.Set(tokenRankKey, rank(scopeAdmin)) })
+	router.GET("/api/sensors", s.GetHistoryHandler)
+	router.GET("/api/readings/:id", s.GetReadingHandler)
+	router.POST("/api/readings", s.CreateReadingHandler)
+	router.PATCH("/api/readings/:id", s.CorrectReadingHandler)
+	router.DELETE("/api/readings/:id", s.DeleteReadingHandler)
+	router.GET("/api/audit", s.GetAuditHandler)
+	return router
+}
+
+func serve(router http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	var r io.Reader
+	if body != "" {
+		r = bytes.NewBufferString(body)
+	}
+	req := httptest.NewRequest(method, target, r)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestReadingsAPIRejects(t *testing.T) {
+	t.Parallel()
+
+	router := readingsRouter(t, &SensorServer{})
+	const id = "65f1c0ffee0000000000beef"
+	tests := []struct {
+		name, method, target, body string
+	}{
+		{"malformed id", http.MethodPatch, "/api/readings/nope", `{"value": 1, "reason": "typo"}`},
+		{"correction without value", http.MethodPatch, "/api/readings/" + id, `{"reason": "typo"}`},
+		{"correction without reason", http.MethodPatch, "/api/readings/" + id, `{"value": 1}`},
+		{"correction not json", http.MethodPatch, "/api/readings/" + id, `value=1`},
+		{"deletion without reason", http.MethodDelete, "/api/readings/" + id, ""},
+		{"audit of malformed id", http.MethodGet, "/api/audit?reading=nope", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if w := serve(router, tt.method, tt.target, tt.body); w.Code != http.StatusBadRequest {
+				t.Errorf("status %d, want 400: %s", w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestNewReadingDetail(t *testing.T) {
+	t.Parallel()
+
+	model := map[string]any{
+		"meter_id":            "gas",
+		"src_image_url":       "/api/images/a.jpg",
+		"thumbnail_url":       "/api/images/b.jpg",
+		"timestamp_trusted":   false,
+		"timestamp_issue":     timestampStale,
+		"clock_drift_seconds": 900.0,
+	}
+	withExchange := map[string]any{
+		"src_image_url": "/api/images/a.jpg",
+		"exchange":      map[string]any{"model": "m", "answer": `{"read":"1"}`, "finish_reason": "stop"},
+	}
+	tests := []struct {
+		name       string
+		reading    SensorReading
+		wantStatus string
+		wantImages readingImages
+		wantAnswer string
+	}{
+		{"model", SensorReading{Metadata: model}, readingAccepted,
+			readingImages{Source: "/api/images/a.jpg", Thumbnail: "/api/images/b.jpg"}, ""},
+		{"corrected", SensorReading{Metadata: model, Correction: &Correction{OriginalValue: 1}}, readingCorrected,
+			readingImages{Source: "/api/images/a.jpg", Thumbnail: "/api/images/b.jpg"}, ""},
+		{"manual", SensorReading{Metadata: manualReading{MeterID: "gas", Read: "1"}, Manual: true}, readingManual,
+			readingImages{}, ""},
+		{"exchange kept with the reading", SensorReading{Metadata: withExchange}, readingAccepted,
+			readingImages{Source: "/api/images/a.jpg"}, `{"read":"1"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			d := newReadingDetail(tt.reading, nil)
+			if d.Validation.Status != tt.wantStatus || d.Images != tt.wantImages {
+				t.Errorf("got %+v, %+v", d.Validation, d.Images)
+			}
+			var answer string
+			if d.Exchange != nil {
+				answer = d.Exchange.Answer
+				if _, ok := d.Metadata.(map[string]any)["exchange"]; ok {
+					t.Error("exchange left in metadata")
+				}
+			}
+			if answer != tt.wantAnswer {
+				t.Errorf("exchange answer %q, want %q", answer, tt.wantAnswer)
+			}
+			if tt.wantAnswer == "" && tt.wantStatus != readingManual && (d.Validation.TimestampIssue != timestampStale || d.Validation.ClockDriftSeconds != 900) {
+				t.Errorf("validation %+v", d.Validation)
+			}
+		})
+	}
+}
+
+// TestReadingsAPI sets the config and previous read globals, so it must not run in parallel.
+func TestReadingsAPI(t *testing.T) {
+	mongoURI := os.Getenv("MONGO_URI")
+	if mongoURI == "" {
+		mongoURI = "mongodb://localhost:27017"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s, err := NewSensorServer(ctx, mongoURI, "mqvision_readings_test")
+	if err != nil {
+		t.Skipf("Skipping MongoDB test: connection failed: %v", err)
+	}
+	defer func() {
+		_ = s.db.Drop(ctx)
+		_ = s.Close(ctx)
+	}()
+
+	config = &Config{Meters: []MeterConfig{{ID: "gas"}, {ID: "water"}}}
+	previousReads.Set("water", "00012.3")
+	router := readingsRouter(t, s)
+
+	readID, err := s.SetValue(ctx, 2924.457, time.Now().Add(-time.Hour), &Luggage{
+		GasMeterReadResult: &genai.GasMeterReadResult{Read: "02924.457"},
+		MeterID:            "gas",
+	})
+	if err != nil {
+		t.Fatalf("SetValue: %v", err)
+	}
+
+	w := serve(router, http.MethodPatch, "/api/readings/"+readID.Hex(), `{"value": 2924.487, "by": "kim", "reason": "8 read as 5"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("correct: status %d: %s", w.Code, w.Body)
+	}
+	var corrected SensorReading
+	json.Unmarshal(w.Body.Bytes(), &corrected)
+	if corrected.Value != 2924.487 || corrected.Correction == nil || corrected.Correction.OriginalValue != 2924.457 {
+		t.Errorf("corrected reading %+v", corrected)
+	}
+	if got := previousReads.Get("gas"); got != "2924.487" {
+		t.Errorf("previous read %q after correcting the latest reading", got)
+	}
+	if got := testutil.ToFloat64(meterValue.WithLabelValues("gas")); got != 2924.487 {
+		t.Errorf("meter value %v after correcting the latest reading", got)
+	}
+	if got := previousReads.Get("water"); got != "00012.3" {
+		t.Errorf("previous read of another meter %q after a correction", got)
+	}
+
+	w = serve(router, http.MethodPost, "/api/readings", `{"meter_id": "gas", "value": 2925.1, "reason": "read on site"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status %d: %s", w.Code, w.Body)
+	}
+	var manual SensorReading
+	json.Unmarshal(w.Body.Bytes(), &manual)
+	if !manual.Manual || s.Latest().ID != manual.ID || previousReads.Get("gas") != "2925.1" {
+		t.Errorf("manual reading %+v is not the latest one", manual)
+	}
+	if got := testutil.ToFloat64(meterValue.WithLabelValues("gas")); got != 2925.1 {
+		t.Errorf("meter value %v after adding a manual reading", got)
+	}
+	if w := serve(router, http.MethodPost, "/api/readings", `{"meter_id": "oil", "value": 1}`); w.Code != http.StatusNotFound {
+		t.Errorf("create for unknown meter: status %d", w.Code)
+	}
+
+	w = serve(router, http.MethodDelete, "/api/readings/"+manual.ID.Hex()+"?by=kim&reason=duplicate", "")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("delete: status %d: %s", w.Code, w.Body)
+	}
+	if s.Latest().ID != readID || previousReads.Get("gas") != "2924.487" {
+		t.Errorf("latest reading %+v after deleting the manual one", s.Latest())
+	}
+	if got := testutil.ToFloat64(meterValue.WithLabelValues("gas")); got != 2924.487 {
+		t.Errorf("meter value %v after deleting the manual one", got)
+	}
+	if w := serve(router, http.MethodDelete, "/api/readings/"+manual.ID.Hex()+"?reason=again", ""); w.Code != http.StatusNotFound {
+		t.Errorf("delete twice: status %d", w.Code)
+	}
+
+	var history []SensorReading
+	json.Unmarshal(serve(router, http.MethodGet, "/api/sensors", "").Body.Bytes(), &history)
+	if len(history) != 1 || history[0].ID != readID || history[0].Value != 2924.487 {
+		t.Errorf("history %+v", history)
+	}
+
+	var detail readingDetail
+	w = serve(router, http.MethodGet, "/api/readings/"+readID.Hex(), "")
+	json.Unmarshal(w.Body.Bytes(), &detail)
+	if w.Code != http.StatusOK || detail.Validation.Status != readingCorrected || len(detail.Audit) != 1 {
+		t.Errorf("detail: status %d: %s", w.Code, w.Body)
+	}
+	if w := serve(router, http.MethodGet, "/api/readings/"+manual.ID.Hex(), ""); w.Code != http.StatusNotFound {
+		t.Errorf("detail of a deleted reading: status %d", w.Code)
+	}
+
+	var entries []AuditEntry
+	json.Unmarshal(serve(router, http.MethodGet, "/api/audit", "").Body.Bytes(), &entries)
+	var actions []string
+	for _, e := range entries {
+		actions = append(actions, e.Action)
+	}
+	if len(actions) != 3 || actions[0] != auditDelete || actions[1] != auditCreate || actions[2] != auditCorrect {
+		t.Fatalf("audit actions %v", actions)
+	}
+	if entries[2].By != legacyTokenName || entries[2].Note != "kim" || entries[2].Reason != "8 read as 5" || entries[2].MeterID != "gas" {
+		t.Errorf("correction entry %+v", entries[2])
+	}
+
+	// A late reading is stored but does not become the previous read.
+	_, err = s.SetValue(ctx, 2923.9, time.Now().Add(-2*time.Hour), &Luggage{
+		GasMeterReadResult: &genai.GasMeterReadResult{Read: "02923.900"},
+		MeterID:            "gas",
+	})
+	if err != nil {
+		t.Fatalf("SetValue: %v", err)
+	}
+	if latest, ok := usePreviousRead(ctx, s, "gas"); !ok || latest.ID != readID || previousReads.Get("gas") != "2924.487" {
+		t.Errorf("latest reading %+v after storing an older one", latest)
+	}
+	if got := testutil.ToFloat64(meterValue.WithLabelValues("gas")); got != 2924.487 {
+		t.Errorf("meter value %v after storing an older reading", got)
+	}
+}
@@ -0,0 +1,536 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/suapapa/mqvision/internal/genai"
+	"github.com/suapapa/mqvision/internal/imagestore"
+	"github.com/suapapa/mqvision/internal/ratelimit"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// candidateCollection keeps rereads that disagree with the stored reading.
+const candidateCollection = "correction_candidates"
+
+// Candidate states.
+const (
+	candidatePending = "pending"
+	candidateApplied = "applied"
+)
+
+// Bounds of the reprocess job concurrency.
+const (
+	defaultReprocessConcurrency = 2
+	maxReprocessConcurrency     = 8
+)
+
+// Reprocess job states.
+const (
+	reprocessRunning = "running"
+	reprocessDone    = "done"
+	reprocessFailed  = "failed"
+)
+
+// maxReprocessErrors caps the errors a reprocess job keeps.
+const maxReprocessErrors = 20
+
+var (
+	errNoImage       = errors.New("reading has no archived image")
+	errRereadLimited = errors.New("vision limit")
+)
+
+// Candidate is a reading read again from its archived image by the current
+// vision client, next to what the reading holds. It corrects the reading only
+// once applied.
+type Candidate struct {
+	ID        bson.ObjectID `json:"id" bson:"_id,omitempty"`
+	JobID     string        `json:"job_id,omitempty" bson:"job_id,omitempty"`
+	ReadingID bson.ObjectID `json:"reading_id" bson:"reading_id"`
+	MeterID   string        `json:"meter_id" bson:"meter_id"`
+	At        time.Time     `json:"at" bson:"at"`
+	Status    string        `json:"status" bson:"status"`
+	// What the reading holds.
+	StoredValue         float64 `json:"stored_value" bson:"stored_value"`
+	StoredRead          string  `json:"stored_read" bson:"stored_read"`
+	StoredPromptVersion string  `json:"stored_prompt_version,omitempty" bson:"stored_prompt_version,omitempty"`
+	// What the current vision client reads.
+	Value         float64         `json:"value" bson:"value"`
+	Read          string          `json:"read" bson:"read"`
+	Model         string          `json:"model" bson:"model"`
+	PromptVersion string          `json:"prompt_version" bson:"prompt_version"`
+	Exchange      *genai.Exchange `json:"exchange,omitempty" bson:"exchange,omitempty"`
+	Changed       bool            `json:"changed" bson:"changed"`
+}
+
+// rereadRequest is the body of the reread and reprocess APIs. Apply corrects the
+// readings right away instead of leaving pending candidates.
+type rereadRequest struct {
+	Apply  bool   `json:"apply"`
+	By     string `json:"by"`
+	Reason string `json:"reason"`
+
+	token string // name of the token the request came with
+}
+
+// edit returns who corrects the readings of r, and why.
+func (r rereadRequest) edit() (editor, string) {
+	by := editor{By: r.token, Note: strings.TrimSpace(r.By)}
+	if by.Note == "" {
+		by.Note = "reprocess"
+	}
+	reason := r.Reason
+	if strings.TrimSpace(reason) == "" {
+		reason = "reread with prompt " + config.promptVersion
+	}
+	return by, reason
+}
+
+// reread reads the archived image of r again with the current vision client,
+// under the vision limits: it waits while they rate-limit the call when wait
+// is set, and fails at once otherwise. Ambiguous digits are settled against
+// the meter's reading before r, not the latest one.
+func (s *SensorServer) reread(ctx context.Context, r SensorReading, wait bool) (Candidate, error) {
+	m, _ := r.Metadata.(map[string]any)
+	str := func(k string) string { v, _ := m[k].(string); return v }
+	c := Candidate{
+		ReadingID:           r.ID,
+		MeterID:             readingMeter(r),
+		At:                  time.Now(),
+		StoredValue:         r.Value,
+		StoredRead:          str("read"),
+		StoredPromptVersion: str("prompt_version"),
+	}
+	src := str("src_image_url")
+	if r.Manual || src == "" {
+		return c, errNoImage
+	}
+	if costTracker.Paused() {
+		return c, errBudgetExceeded
+	}
+	var previous string
+	switch before, err := s.LatestReading(ctx, c.MeterID, r.UpdatedAt); {
+	case err == nil:
+		previous = formatRead(before.Value)
+	case !errors.Is(err, errReadingNotFound):
+		return c, err
+	}
+	admit := visionGate.Admit
+	if wait {
+		admit = func(id string) error { return visionGate.Wait(ctx, id) }
+	}
+	if err := admit(c.MeterID); err != nil {
+		return c, fmt.Errorf("%w: %w", errRereadLimited, err)
+	}
+
+	start := time.Now()
+	res, err := readArchivedImage(ctx, src, previous)
+	observeVision(c.MeterID, time.Since(start), res, err)
+	billed := res
+	if err != nil {
+		billed = genai.Partial(err) // a failed read may still have been billed
+	}
+	if _, err := costTracker.Record(ctx, billed); err != nil {
+		slog.ErrorContext(ctx, "Error recording LLM cost", "err", err)
+	}
+	if err != nil {
+		return c, err
+	}
+
+	c.Value, err = strconv.ParseFloat(res.Read, 64)
+	if err != nil {
+		return c, fmt.Errorf("%w: %v", errInvalidRead, err)
+	}
+	c.Read, c.Model, c.Exchange = res.Read, res.Model, res.Exchange
+	c.PromptVersion = config.promptVersion
+	c.Changed = c.Value != c.StoredValue
+	return c, nil
+}
+
+// readArchivedImage reads the image at src, a src_image_url, from the image
+// store, or through its URL when the store does not have it.
+func readArchivedImage(ctx context.Context, src, previous string) (*genai.GasMeterReadResult, error) {
+	if id, ok := archivedImageID(src); ok && imageStore != nil {
+		img, _, err := imageStore.Get(ctx, id)
+		if err == nil {
+			defer img.Close()
+			return genaiClient.ReadGasGaugePic(ctx, img, previous)
+		}
+		if !errors.Is(err, imagestore.ErrNotFound) {
+			return nil, fmt.Errorf("open archived image: %w", err)
+		}
+	}
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return nil, fmt.Errorf("%w: %s not found", errNoImage, src)
+	}
+	return genaiClient.ReadGasGaugePicFromURL(ctx, src, previous)
+}
+
+// settleCandidate stores a changed candidate, applying it first when asked.
+func (s *SensorServer) settleCandidate(ctx context.Context, c *Candidate, req rereadRequest) error {
+	if !c.Changed {
+		return nil
+	}
+	c.Status = candidatePending
+	if req.Apply {
+		by, reason := req.edit()
+		if _, err := s.CorrectReading(ctx, c.ReadingID, c.Value, by, reason); err != nil {
+			return err
+		}
+		c.Status = candidateApplied
+	}
+	res, err := s.candidates.InsertOne(ctx, c)
+	if err != nil {
+		return fmt.Errorf("insert candidate: %w", err)
+	}
+	c.ID, _ = res.InsertedID.(bson.ObjectID)
+	return nil
+}
+
+// RereadHandler reads the archived image of a reading again and answers with
+// the difference. A changed read is kept as a candidate, or applied when asked.
+func (s *SensorServer) RereadHandler(c *gin.Context) {
+	id, ok := readingID(c)
+	if !ok {
+		return
+	}
+	var req rereadRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	req.token = tokenName(c)
+	r, err := s.Reading(c.Request.Context(), id)
+	if err != nil {
+		readingError(c, err)
+		return
+	}
+
+	cand, err := s.reread(c.Request.Context(), r, false)
+	switch {
+	case errors.Is(err, errNoImage):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, errRereadLimited):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, errBudgetExceeded):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.settleCandidate(c.Request.Context(), &cand, req); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Apply && cand.Changed {
+		usePreviousRead(c.Request.Context(), s, cand.MeterID)
+	}
+	c.JSON(http.StatusOK, cand)
+}
+
+// GetCandidatesHandler lists the latest candidates, filtered by ?job= and ?status=.
+func (s *SensorServer) GetCandidatesHandler(c *gin.Context) {
+	filter := bson.M{}
+	if v := c.Query("job"); v != "" {
+		filter["job_id"] = v
+	}
+	if v := c.Query("status"); v != "" {
+		filter["status"] = v
+	}
+	cursor, err := s.candidates.Find(c.Request.Context(), filter,
+		options.Find().SetSort(bson.M{"at": -1}).SetLimit(auditLimit))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	candidates := []Candidate{}
+	if err := cursor.All(c.Request.Context(), &candidates); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, candidates)
+}
+
+// ApplyCandidateHandler corrects the reading of a pending candidate with its value.
+func (s *SensorServer) ApplyCandidateHandler(c *gin.Context) {
+	id, ok := readingID(c)
+	if !ok {
+		return
+	}
+	var req rereadRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	req.token = tokenName(c)
+	ctx := c.Request.Context()
+
+	var cand Candidate
+	err := s.candidates.FindOne(ctx, bson.M{"_id": id}).Decode(&cand)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "candidate not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if cand.Status != candidatePending {
+		c.JSON(http.StatusConflict, gin.H{"error": "candidate is " + cand.Status})
+		return
+	}
+
+	by, reason := req.edit()
+	if _, err := s.CorrectReading(ctx, cand.ReadingID, cand.Value, by, reason); err != nil {
+		readingError(c, err)
+		return
+	}
+	usePreviousRead(ctx, s, cand.MeterID)
+	cand.Status = candidateApplied
+	if _, err := s.candidates.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"status": cand.Status}}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, cand)
+}
+
+// ReprocessJob rereads the readings of a time range in the background.
+type ReprocessJob struct {
+	ID          string     `json:"id"`
+	From        time.Time  `json:"from"`
+	To          time.Time  `json:"to"`
+	MeterID     string     `json:"meter_id,omitempty"`
+	Apply       bool       `json:"apply"`
+	Concurrency int        `json:"concurrency"`
+	Status      string     `json:"status"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	// Counts of the readings reread so far.
+	Done    int      `json:"done"`
+	Changed int      `json:"changed"`
+	Failed  int      `json:"failed"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// reprocessRequest is the body of POST /api/reprocess. To defaults to now.
+type reprocessRequest struct {
+	rereadRequest
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	MeterID     string    `json:"meter_id"`
+	Concurrency int       `json:"concurrency"`
+}
+
+func (r *reprocessRequest) validate() error {
+	if r.To.IsZero() {
+		r.To = time.Now()
+	}
+	if r.Concurrency == 0 {
+		r.Concurrency = defaultReprocessConcurrency
+	}
+	switch {
+	case r.From.IsZero():
+		return errors.New("from is required")
+	case !r.From.Before(r.To):
+		return errors.New("from must be before to")
+	case r.Concurrency < 1 || r.Concurrency > maxReprocessConcurrency:
+		return fmt.Errorf("concurrency must be between 1 and %d", maxReprocessConcurrency)
+	case r.MeterID != "" && !config.hasMeter(r.MeterID):
+		return fmt.Errorf("unknown meter %q", r.MeterID)
+	}
+	return nil
+}
+
+// ReprocessJobs keeps reprocess jobs in memory for polling until jobTTL after they finish.
+type ReprocessJobs struct {
+	mu   sync.Mutex
+	jobs map[string]*ReprocessJob
+}
+
+func NewReprocessJobs() *ReprocessJobs {
+	return &ReprocessJobs{jobs: make(map[string]*ReprocessJob)}
+}
+
+// Start registers a running job for req and drops expired ones.
+func (r *ReprocessJobs) Start(req reprocessRequest) ReprocessJob {
+	var b [8]byte
+	rand.Read(b[:])
+	now := time.Now()
+	j := &ReprocessJob{
+		ID:          hex.EncodeToString(b[:]),
+		From:        req.From,
+		To:          req.To,
+		MeterID:     req.MeterID,
+		Apply:       req.Apply,
+		Concurrency: req.Concurrency,
+		Status:      reprocessRunning,
+		StartedAt:   now,
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, old := range r.jobs {
+		if old.FinishedAt != nil && now.Sub(*old.FinishedAt) > jobTTL {
+			delete(r.jobs, id)
+		}
+	}
+	r.jobs[j.ID] = j
+	return *j
+}
+
+// update changes job id under the lock.
+func (r *ReprocessJobs) update(id string, fn func(j *ReprocessJob)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if j, ok := r.jobs[id]; ok {
+		fn(j)
+	}
+}
+
+// Get returns a copy of the job.
+func (r *ReprocessJobs) Get(id string) (ReprocessJob, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, ok := r.jobs[id]
+	if !ok {
+		return ReprocessJob{}, false
+	}
+	cp := *j
+	cp.Errors = append([]string(nil), j.Errors...)
+	return cp, true
+}
+
+// reprocess rereads the readings of req, at most req.Concurrency at once, and
+// records the outcome in job id. Rereads wait for the vision limits; the job
+// stops when the LLM budget or a daily vision quota runs out.
+func (s *SensorServer) reprocess(ctx context.Context, id string, req reprocessRequest) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	fail := func(j *ReprocessJob, err error) {
+		j.Failed++
+		if len(j.Errors) < maxReprocessErrors {
+			j.Errors = append(j.Errors, err.Error())
+		}
+	}
+	finish := func(status string, err error) {
+		now := time.Now()
+		reprocessJobs.update(id, func(j *ReprocessJob) {
+			j.Status, j.FinishedAt = status, &now
+			if err != nil {
+				fail(j, err)
+			}
+		})
+		slog.InfoContext(ctx, "Reprocess job finished", "job", id, "status", status, "err", err)
+	}
+
+	filter := bson.M{
+		"updated_at": bson.M{"$gte": req.From, "$lt": req.To},
+		"deleted_at": notDeleted,
+		"manual":     bson.M{"$ne": true},
+	}
+	if req.MeterID != "" {
+		filter["metadata.meter_id"] = req.MeterID
+	}
+	cursor, err := s.collection.Find(ctx, filter, options.Find().SetSort(bson.M{"updated_at": 1}))
+	if err != nil {
+		finish(reprocessFailed, fmt.Errorf("find readings: %w", err))
+		return
+	}
+	defer cursor.Close(ctx)
+
+	sem := make(chan struct{}, req.Concurrency)
+	var wg sync.WaitGroup
+	for cursor.Next(ctx) {
+		var r SensorReading
+		if err := cursor.Decode(&r); err != nil {
+			reprocessJobs.update(id, func(j *ReprocessJob) { fail(j, err) })
+			continue
+		}
+		r.Metadata = normalizeMetadata(r.Metadata)
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			c, err := s.reread(ctx, r, true)
+			c.JobID = id
+			if err == nil {
+				err = s.settleCandidate(ctx, &c, req.rereadRequest)
+			}
+			if errors.Is(err, errBudgetExceeded) || errors.Is(err, ratelimit.ErrQuotaExceeded) {
+				cancel()
+			}
+			reprocessJobs.update(id, func(j *ReprocessJob) {
+				j.Done++
+				switch {
+				case err != nil:
+					fail(j, fmt.Errorf("reading %s: %w", r.ID.Hex(), err))
+				case c.Changed:
+					j.Changed++
+				}
+			})
+		}()
+	}
+	wg.Wait()
+	if req.Apply {
+		usePreviousReads(ctx, s)
+	}
+
+	switch {
+	case cursor.Err() != nil && ctx.Err() == nil:
+		finish(reprocessFailed, cursor.Err())
+	case ctx.Err() != nil:
+		finish(reprocessFailed, fmt.Errorf("stopped: %w", context.Cause(ctx)))
+	default:
+		finish(reprocessDone, nil)
+	}
+}
+
+// StartReprocessHandler starts a reprocess job and answers 202 with the job to poll.
+func (s *SensorServer) StartReprocessHandler(c *gin.Context) {
+	var req reprocessRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.token = tokenName(c)
+
+	job := reprocessJobs.Start(req)
+	go s.reprocess(appCtx, job.ID, req)
+	c.Header("Location", "/api/reprocess/"+job.ID)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// GetReprocessHandler returns a reprocess job by id.
+func GetReprocessHandler(c *gin.Context) {
+	job, ok := reprocessJobs.Get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
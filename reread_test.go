@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReprocessRequestValidate(t *testing.T) {
+	config = &Config{Meters: []MeterConfig{{ID: "gas"}}}
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		req     reprocessRequest
+		wantErr string
+	}{
+		{"defaults", reprocessRequest{From: from}, ""},
+		{"meter", reprocessRequest{From: from, To: from.Add(time.Hour), MeterID: "gas", Concurrency: 8}, ""},
+		{"no from", reprocessRequest{}, "from is required"},
+		{"empty range", reprocessRequest{From: from, To: from}, "before to"},
+		{"too concurrent", reprocessRequest{From: from, Concurrency: 9}, "concurrency"},
+		{"negative concurrency", reprocessRequest{From: from, Concurrency: -1}, "concurrency"},
+		{"unknown meter", reprocessRequest{From: from, MeterID: "water"}, "unknown meter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.req.To.IsZero() || tt.req.Concurrency < 1 {
+					t.Errorf("defaults not set: %+v", tt.req)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRereadAPIRejects(t *testing.T) {
+	t.Parallel()
+
+	s := &SensorServer{}
+	router := readingsRouter(t, s)
+	router.POST("/api/readings/:id/reread", s.RereadHandler)
+	router.POST("/api/reprocess", s.StartReprocessHandler)
+	router.GET("/api/reprocess/:id", GetReprocessHandler)
+	router.POST("/api/candidates/:id/apply", s.ApplyCandidateHandler)
+
+	tests := []struct {
+		name, method, target, body string
+		want                       int
+	}{
+		{"reread of malformed id", http.MethodPost, "/api/readings/nope/reread", "", http.StatusBadRequest},
+		{"reread not json", http.MethodPost, "/api/readings/65f1c0ffee0000000000beef/reread", `apply=1`, http.StatusBadRequest},
+		{"reprocess without body", http.MethodPost, "/api/reprocess", "", http.StatusBadRequest},
+		{"reprocess without from", http.MethodPost, "/api/reprocess", `{"to": "2026-01-01T00:00:00Z"}`, http.StatusBadRequest},
+		{"unknown job", http.MethodGet, "/api/reprocess/nope", "", http.StatusNotFound},
+		{"apply malformed candidate", http.MethodPost, "/api/candidates/nope/apply", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if w := serve(router, tt.method, tt.target, tt.body); w.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
@@ -50,16 +50,29 @@ type SensorReading struct {
 	Value     float64   `json:"value" bson:"value"`
 	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
 	Metadata  any       `json:"metadata" bson:"metadata"`
+	// ID is what the readings API refers to the reading by.
+	ID bson.ObjectID `json:"id" bson:"_id,omitempty"`
+	// Manual marks a reading entered by hand from the meter itself.
+	Manual bool `json:"manual,omitempty" bson:"manual,omitempty"`
+	// Correction is set once Value was corrected by hand; Metadata keeps the model's answer.
+	Correction *Correction `json:"correction,omitempty" bson:"correction,omitempty"`
+	// DeletedAt soft-deletes the reading: it stays in the collection but is no longer served.
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
 }
 
 type SensorServer struct {
 	Value     float64   `json:"value"`      // latest value
 	UpdatedAt time.Time `json:"updated_at"` // latest updated at
 	Metadata  any       `json:"metadata"`   // latest metadata
+	// ID is the latest reading's id.
+	ID bson.ObjectID `json:"id"`
 
 	client     *mongo.Client
 	db         *mongo.Database
 	collection *mongo.Collection
+	audit      *mongo.Collection
+	exchanges  *mongo.Collection
+	candidates *mongo.Collection
 
 	sync.RWMutex
 }
@@ -103,23 +116,39 @@ func NewSensorServer(ctx context.Context, uri, dbName string) (*SensorServer, er
 		client:     client,
 		db:         db,
 		collection: coll,
+		audit:      db.Collection(auditCollection),
+		exchanges:  db.Collection(exchangeCollection),
+		candidates: db.Collection(candidateCollection),
 	}
 
 	// Initialize in-memory cache with the latest document
-	var latest SensorReading
-	findOpts := options.FindOne().SetSort(bson.M{"updated_at": -1})
-	err = coll.FindOne(ctx, bson.M{}, findOpts).Decode(&latest)
-	if err == nil {
-		s.Value = latest.Value
-		s.UpdatedAt = latest.UpdatedAt
-		s.Metadata = normalizeMetadata(latest.Metadata)
-	} else if err != mongo.ErrNoDocuments {
-		return nil, fmt.Errorf("load latest reading: %w", err)
+	if err := s.loadLatest(ctx); err != nil {
+		return nil, err
 	}
 
 	return s, nil
 }
 
+// loadLatest caches the latest reading that is not deleted. The caller holds the lock
+// unless s is not shared yet.
+func (s *SensorServer) loadLatest(ctx context.Context) error {
+	var latest SensorReading
+	findOpts := options.FindOne().SetSort(bson.M{"updated_at": -1})
+	err := s.collection.FindOne(ctx, bson.M{"deleted_at": notDeleted}, findOpts).Decode(&latest)
+	if err == mongo.ErrNoDocuments {
+		s.Value, s.UpdatedAt, s.Metadata, s.ID = 0, time.Time{}, nil, bson.ObjectID{}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("load latest reading: %w", err)
+	}
+	s.Value = latest.Value
+	s.UpdatedAt = latest.UpdatedAt
+	s.Metadata = normalizeMetadata(latest.Metadata)
+	s.ID = latest.ID
+	return nil
+}
+
 // Close closes the MongoDB connection.
 func (s *SensorServer) Close(ctx context.Context) error {
 	if s.client != nil {
@@ -129,29 +158,43 @@ func (s *SensorServer) Close(ctx context.Context) error {
 }
 
 // SetValue stores the reading into MongoDB timeseries collection and updates the in-memory cache.
-func (s *SensorServer) SetValue(ctx context.Context, value float64, metadata any) error {
-	s.Lock()
-	defer s.Unlock()
-
-	now := time.Now()
-	reading := SensorReading{
+// updatedAt is the time of the reading; zero means now. A reading older than the cached one
+// is stored but does not replace the latest value. It returns the id of the stored reading,
+// which is also published on the event bus.
+func (s *SensorServer) SetValue(ctx context.Context, value float64, updatedAt time.Time, metadata any) (bson.ObjectID, error) {
+	if updatedAt.IsZero() {
+		updatedAt = time.Now()
+	}
+	return s.insert(ctx, SensorReading{
+		ID:        bson.NewObjectID(),
 		Value:     value,
-		UpdatedAt: now,
+		UpdatedAt: updatedAt,
 		Metadata:  metadata,
-	}
+	})
+}
+
+func (s *SensorServer) insert(ctx context.Context, reading SensorReading) (bson.ObjectID, error) {
+	s.Lock()
+	defer s.Unlock()
 
 	_, err := s.collection.InsertOne(ctx, reading)
 	if err != nil {
-		return fmt.Errorf("insert reading: %w", err)
+		return bson.ObjectID{}, fmt.Errorf("insert reading: %w", err)
 	}
+	bus.Publish(eventReading, reading)
 
-	s.Value = value
-	s.Metadata = metadata
-	s.UpdatedAt = now
+	if reading.UpdatedAt.Before(s.UpdatedAt) {
+		return reading.ID, nil
+	}
+	s.Value = reading.Value
+	s.Metadata = reading.Metadata
+	s.UpdatedAt = reading.UpdatedAt
+	s.ID = reading.ID
 
-	return nil
+	return reading.ID, nil
 }
 
+// GetValueHandler returns the latest reading; its model exchange only to admin tokens.
 func (s *SensorServer) GetValueHandler(c *gin.Context) {
 	s.RLock()
 	defer s.RUnlock()
@@ -163,15 +206,22 @@ func (s *SensorServer) GetValueHandler(c *gin.Context) {
 		return
 	}
 
+	if !hasScope(c, scopeAdmin) {
+		c.JSON(http.StatusOK, SensorReading{ID: s.ID, Value: s.Value, UpdatedAt: s.UpdatedAt, Metadata: withoutExchange(s.Metadata)})
+		return
+	}
 	c.JSON(http.StatusOK, s)
 }
 
+// GetHistoryHandler returns the readings of the last 7 days, oldest first; their
+// model exchanges only to admin tokens.
 func (s *SensorServer) GetHistoryHandler(c *gin.Context) {
 	cutoff := time.Now().Add(-7 * 24 * time.Hour)
 	filter := bson.M{
 		"updated_at": bson.M{
 			"$gte": cutoff,
 		},
+		"deleted_at": notDeleted,
 	}
 	findOpts := options.Find().SetSort(bson.M{"updated_at": 1})
 
@@ -192,8 +242,12 @@ func (s *SensorServer) GetHistoryHandler(c *gin.Context) {
 		return
 	}
 
+	admin := hasScope(c, scopeAdmin)
 	for i := range readings {
 		readings[i].Metadata = normalizeMetadata(readings[i].Metadata)
+		if !admin {
+			readings[i].Metadata = withoutExchange(readings[i].Metadata)
+		}
 	}
 
 	c.JSON(http.StatusOK, readings)
@@ -37,7 +37,7 @@ func TestSensorServerHistory(t *testing.T) {
 	now := time.Now()
 
 	// Insert test data using SetValue
-	err = s.SetValue(ctx, 10.5, "meta1")
+	_, err = s.SetValue(ctx, 10.5, time.Time{}, "meta1")
 	if err != nil {
 		t.Fatalf("failed to set value: %v", err)
 	}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/suapapa/mqvision/internal/payload"
+	"github.com/suapapa/mqvision/internal/source"
+)
+
+// Source types.
+const (
+	sourceMQTT = "mqtt"
+	sourceHTTP = "http"
+	sourceDir  = "dir"
+	// sourceUpload and sourceFile label images posted to the upload API and
+	// given with -i; they cannot be configured.
+	sourceUpload = "upload"
+	sourceFile   = "file"
+)
+
+// defaultSnapshotInterval applies to http sources without an interval.
+const defaultSnapshotInterval = 5 * time.Minute
+
+// SourceConfig declares where a meter's images come from. A meter without a
+// source takes images only through the upload API.
+type SourceConfig struct {
+	Type string `yaml:"type"`
+	// Topic is the MQTT image topic (mqtt; default MQTT_TOPIC).
+	Topic string `yaml:"topic"`
+	// URL is polled every Interval, with basic auth when Username is set (http).
+	// ${VAR} in these is expanded from the environment so secrets stay out of the file.
+	URL      string        `yaml:"url"`
+	Username string        `yaml:"username"`
+	Password string        `yaml:"password"`
+	Interval time.Duration `yaml:"interval"`
+	// Path is watched for new files, which move to Processed once stored (dir;
+	// default <path>/processed).
+	Path      string `yaml:"path"`
+	Processed string `yaml:"processed"`
+}
+
+// newSource builds the source of a meter, or nil if it has none.
+func newSource(c SourceConfig) (source.Source, error) {
+	switch c.Type {
+	case "":
+		return nil, nil
+	case sourceMQTT:
+		if mqttClient == nil {
+			return nil, fmt.Errorf("mqtt source needs MQTT_HOST")
+		}
+		return source.MQTT{Client: mqttClient, Topic: c.Topic}, nil
+	case sourceHTTP:
+		return source.Snapshot{URL: c.URL, Interval: c.Interval, Username: c.Username, Password: c.Password}, nil
+	case sourceDir:
+		return source.Dir{Path: c.Path, Processed: c.Processed}, nil
+	default:
+		return nil, fmt.Errorf("unknown source type %q", c.Type)
+	}
+}
+
+// startSources runs the source of every meter until ctx is done.
+func startSources(ctx context.Context, wg *sync.WaitGroup) {
+	for _, m := range config.Meters {
+		src, err := newSource(m.Source)
+		if err != nil {
+			fatal("Error creating source of meter "+m.ID, err)
+		}
+		if src == nil {
+			continue
+		}
+
+		// Cameras on MQTT may wrap images in envelopes; other sources carry plain images.
+		var decoder payload.Decoder = payload.Raw{}
+		if m.Source.Type == sourceMQTT {
+			decoder = payloadDecoder
+		}
+
+		slog.Info("Taking images", "meter", m.ID, "source", m.Source.Type)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := src.Run(ctx, receiver(m.ID, m.Source.Type, decoder)); err != nil {
+				slog.Error("Source stopped", "meter", m.ID, "source", m.Source.Type, "err", err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/suapapa/mqvision/internal/events"
+)
+
+// eventsKept is how many events /api/events can replay to a client resuming with Last-Event-ID.
+const eventsKept = 256
+
+// sseHeartbeat keeps idle event streams open through proxies.
+const sseHeartbeat = 15 * time.Second
+
+// Event types on the bus and in /api/events.
+const (
+	eventReading  = "reading"  // a reading was stored
+	eventRejected = "rejected" // a frame ended without a reading
+	eventMQTT     = "mqtt"     // the MQTT connection came up or dropped
+	eventProgress = "progress" // a frame reached a stage of the pipeline
+)
+
+// Stages of a progress event.
+const (
+	stageReceived = "received"
+	stageReading  = "reading"
+)
+
+// bus carries what happens in the pipeline to /api/events.
+var bus = events.New(eventsKept)
+
+type rejection struct {
+	MeterID string `json:"meter_id"`
+	Image   string `json:"image,omitempty"`
+	Reason  string `json:"reason"`
+	Error   string `json:"error"`
+}
+
+type mqttTransition struct {
+	Connected bool   `json:"connected"`
+	Error     string `json:"error,omitempty"`
+}
+
+type progress struct {
+	MeterID string `json:"meter_id"`
+	Image   string `json:"image"`
+	Stage   string `json:"stage"`
+}
+
+// publishMQTT reports a connect, or a drop when err is not nil.
+func publishMQTT(err error) {
+	t := mqttTransition{Connected: err == nil}
+	if err != nil {
+		t.Error = err.Error()
+	}
+	bus.Publish(eventMQTT, t)
+}
+
+// writeEvent writes e in the text/event-stream format.
+func writeEvent(w io.Writer, e events.Event) error {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return fmt.Errorf("marshal %s event: %w", e.Type, err)
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
+
+// eventsHandler streams bus events as Server-Sent Events. A client that sends
+// Last-Event-ID (or ?last_event_id=) first gets the kept events it missed.
+// Readings carry their model exchange only to admin tokens.
+func eventsHandler(c *gin.Context) {
+	lastID, _ := strconv.ParseUint(cmp.Or(c.GetHeader("Last-Event-ID"), c.Query("last_event_id")), 10, 64)
+	missed, ch, cancel := bus.Subscribe(lastID)
+	defer cancel()
+
+	h := c.Writer.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	h.Set("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	admin := hasScope(c, scopeAdmin)
+	send := func(e events.Event) bool {
+		if r, ok := e.Data.(SensorReading); ok && !admin {
+			r.Metadata = withoutExchange(r.Metadata)
+			e.Data = r
+		}
+		if err := writeEvent(c.Writer, e); err != nil {
+			slog.Warn("Error writing event", "type", e.Type, "err", err)
+			return false
+		}
+		return true
+	}
+	for _, e := range missed {
+		if !send(e) {
+			return
+		}
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				// Fell behind; the client reconnects with Last-Event-ID.
+				return
+			}
+			if !send(e) {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := io.WriteString(c.Writer, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-c.Request.Context().Done():
+			return
+		case <-appCtx.Done():
+			return
+		}
+		c.Writer.Flush()
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readEvents reads the ids and types of n events from an event stream.
+func readEvents(t *testing.T, r *bufio.Reader, n int) (ids []uint64, types []string) {
+	t.Helper()
+	for len(types) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read stream: %v", err)
+		}
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id, _ := strconv.ParseUint(strings.TrimSpace(line[4:]), 10, 64)
+			ids = append(ids, id)
+		case strings.HasPrefix(line, "event: "):
+			types = append(types, strings.TrimSpace(line[7:]))
+		}
+	}
+	return ids, types
+}
+
+// TestEventsHandler uses the bus and appCtx globals, so it must not run in parallel.
+func TestEventsHandler(t *testing.T) {
+	appCtx = context.Background()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/events", eventsHandler)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	before := bus.Publish(eventProgress, progress{MeterID: "gas", Image: "a", Stage: stageReceived})
+	missed := bus.Publish(eventRejected, rejection{MeterID: "gas", Reason: "invalid_read", Error: "read is not a number"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/events", nil)
+	req.Header.Set("Last-Event-ID", strconv.FormatUint(before.ID, 10))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type %q", ct)
+	}
+	r := bufio.NewReader(res.Body)
+
+	ids, types := readEvents(t, r, 1)
+	if ids[0] != missed.ID || types[0] != eventRejected {
+		t.Errorf("resumed with %v %v, want %d rejected", ids, types, missed.ID)
+	}
+
+	publishMQTT(errors.New("EOF"))
+	if _, types := readEvents(t, r, 1); types[0] != eventMQTT {
+		t.Errorf("live event %v, want mqtt", types)
+	}
+}
+
+func TestWriteEvent(t *testing.T) {
+	t.Parallel()
+
+	var b strings.Builder
+	e := bus.Publish(eventProgress, progress{MeterID: "gas", Image: "ab", Stage: stageReading})
+	if err := writeEvent(&b, e); err != nil {
+		t.Fatal(err)
+	}
+	want := "id: " + strconv.FormatUint(e.ID, 10) + "\nevent: progress\n" +
+		`data: {"meter_id":"gas","image":"ab","stage":"reading"}` + "\n\n"
+	if b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/suapapa/mqvision/internal/payload"
+)
+
+// maxUploadSize caps an uploaded image.
+const maxUploadSize = 10 << 20
+
+// jobTTL is how long a finished upload job stays pollable.
+const jobTTL = time.Hour
+
+// Job states.
+const (
+	jobPending = "pending"
+	jobDone    = "done"    // the reading is stored
+	jobIgnored = "ignored" // the frame was skipped or dropped by policy
+	jobFailed  = "failed"
+)
+
+// Job tracks one uploaded image through the pipeline.
+type Job struct {
+	ID         string     `json:"id"`
+	MeterID    string     `json:"meter_id"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Reading    *Luggage   `json:"reading,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// JobRegistry keeps upload jobs in memory for polling until jobTTL after they finish.
+type JobRegistry struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func NewJobRegistry() *JobRegistry {
+	return &JobRegistry{jobs: make(map[string]*Job)}
+}
+
+// Start registers a pending job for meterID and drops expired ones.
+func (r *JobRegistry) Start(meterID string) Job {
+	var b [8]byte
+	rand.Read(b[:])
+	now := time.Now()
+	j := &Job{ID: hex.EncodeToString(b[:]), MeterID: meterID, Status: jobPending, CreatedAt: now}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, old := range r.jobs {
+		if old.FinishedAt != nil && now.Sub(*old.FinishedAt) > jobTTL {
+			delete(r.jobs, id)
+		}
+	}
+	r.jobs[j.ID] = j
+	return *j
+}
+
+// Finish records the fate of a job as returned by ingestFrame.
+func (r *JobRegistry) Finish(id string, reading *Luggage, err error) Job {
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, ok := r.jobs[id]
+	if !ok {
+		return Job{ID: id, Status: jobFailed, Error: "job expired"}
+	}
+	j.FinishedAt = &now
+	j.Reading = reading
+	switch {
+	case err == nil:
+		j.Status = jobDone
+	case ignored(err):
+		j.Status, j.Error = jobIgnored, err.Error()
+	default:
+		j.Status, j.Error = jobFailed, err.Error()
+	}
+	return *j
+}
+
+// Get returns a copy of the job.
+func (r *JobRegistry) Get(id string) (Job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, ok := r.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+// uploadImageHandler feeds an image posted for a meter, as the multipart field
+// "image" or as the raw body, through the same pipeline as MQTT images. It
+// answers with the finished job, or with 202 and the job to poll when ?async=true.
+func uploadImageHandler(c *gin.Context) {
+	meterID := c.Param("id")
+	if !config.hasMeter(meterID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("unknown meter %q", meterID)})
+		return
+	}
+	async, _ := strconv.ParseBool(c.Query("async"))
+
+	data, err := readUpload(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	f, err := receive(meterID, sourceUpload, payload.Raw{}, data)
+	if err != nil {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+		return
+	}
+
+	job := jobs.Start(meterID)
+	if async {
+		go func() {
+			err := ingestFrame(f)
+			jobs.Finish(job.ID, f.reading, err)
+		}()
+		c.Header("Location", "/api/jobs/"+job.ID)
+		c.JSON(http.StatusAccepted, job)
+		return
+	}
+
+	err = ingestFrame(f)
+	job = jobs.Finish(job.ID, f.reading, err)
+	c.JSON(jobHTTPStatus(job, err), job)
+}
+
+// getJobHandler returns an upload job by id.
+func getJobHandler(c *gin.Context) {
+	job, ok := jobs.Get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+func readUpload(c *gin.Context) ([]byte, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadSize)
+
+	var r io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		fh, err := c.FormFile("image")
+		if err != nil {
+			return nil, fmt.Errorf("multipart field \"image\": %w", err)
+		}
+		file, err := fh.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read image: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, errors.New("empty image")
+	}
+	return data, nil
+}
+
+// jobHTTPStatus maps the fate of a synchronous upload to a response code.
+func jobHTTPStatus(j Job, err error) int {
+	switch {
+	case j.Status == jobDone:
+		return http.StatusCreated
+	case j.Status == jobIgnored:
+		return http.StatusOK
+	case errors.Is(err, errNotStored), errors.Is(err, errBudgetExceeded), appCtx.Err() != nil:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusUnprocessableEntity
+	}
+}
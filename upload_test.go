@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/suapapa/mqvision/internal/genai"
+)
+
+// TestUploadImageHandler stubs the pipeline behind ingestFrame, so it must not run in parallel.
+func TestUploadImageHandler(t *testing.T) {
+	jpg, err := os.ReadFile(filepath.Join("sample", "ok.jpg"))
+	if err != nil {
+		t.Fatalf("read sample: %v", err)
+	}
+
+	oldConfig, oldCtx, oldCaptures := config, appCtx, captures
+	t.Cleanup(func() { config, appCtx, captures = oldConfig, oldCtx, oldCaptures })
+	config = &Config{Meters: []MeterConfig{{ID: "gas"}}}
+	appCtx = context.Background()
+	captures = &CaptureScheduler{next: func(f *Frame) {
+		f.reading = &Luggage{GasMeterReadResult: &genai.GasMeterReadResult{Read: "02924.457"}, MeterID: f.MeterID}
+		f.settle(nil)
+	}}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(conformsToSpec(t, nil))
+	api := router.Group("/api", newAuthenticator(AuthConfig{Anonymous: anonymousRead}, "secret").require(scopeWrite))
+	api.POST("/meters/:id/images", uploadImageHandler)
+	api.GET("/jobs/:id", getJobHandler)
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	fw, _ := mw.CreateFormFile("image", "ok.jpg")
+	fw.Write(jpg)
+	mw.Close()
+
+	tests := []struct {
+		name        string
+		path        string
+		token       string
+		contentType string
+		body        []byte
+		wantCode    int
+		wantStatus  string
+	}{
+		{name: "no token", path: "/api/meters/gas/images", body: jpg, wantCode: http.StatusUnauthorized},
+		{name: "unknown meter", path: "/api/meters/water/images", token: "secret", body: jpg, wantCode: http.StatusNotFound},
+		{name: "not an image", path: "/api/meters/gas/images", token: "secret", body: []byte("hello"), wantCode: http.StatusUnsupportedMediaType},
+		{name: "raw body", path: "/api/meters/gas/images", token: "secret", contentType: "image/jpeg", body: jpg, wantCode: http.StatusCreated, wantStatus: jobDone},
+		{name: "multipart", path: "/api/meters/gas/images", token: "secret", contentType: mw.FormDataContentType(), body: form.Bytes(), wantCode: http.StatusCreated, wantStatus: jobDone},
+		{name: "async", path: "/api/meters/gas/images?async=true", token: "secret", contentType: "image/jpeg", body: jpg, wantCode: http.StatusAccepted, wantStatus: jobPending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, bytes.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantStatus == "" {
+				return
+			}
+			var job Job
+			if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+				t.Fatalf("decode job: %v", err)
+			}
+			if job.Status != tt.wantStatus || job.MeterID != "gas" {
+				t.Fatalf("job = %+v, want status %s", job, tt.wantStatus)
+			}
+			if job.Status == jobDone && (job.Reading == nil || job.Reading.Read != "02924.457") {
+				t.Errorf("job reading = %+v", job.Reading)
+			}
+
+			// Poll until the job is done, so no frame outlives the stubs.
+			for deadline := time.Now().Add(5 * time.Second); ; {
+				req = httptest.NewRequest(http.MethodGet, "/api/jobs/"+job.ID, nil)
+				req.Header.Set("Authorization", "Bearer secret")
+				w = httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+				if w.Code != http.StatusOK {
+					t.Fatalf("GET job code = %d, want 200", w.Code)
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+					t.Fatalf("decode job: %v", err)
+				}
+				if job.Status != jobPending {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("job %s still pending", job.ID)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
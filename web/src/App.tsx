@@ -1,8 +1,17 @@
 import { lazy, Suspense, useCallback, useEffect, useState } from 'react'
-import { fetchHealth, fetchHistory, fetchSensor } from './api'
+import {
+  ApiError,
+  fetchHealth,
+  fetchHistory,
+  fetchSensor,
+  getToken,
+  setToken,
+  subscribeEvents,
+} from './api'
 import { HealthBar } from './components/HealthBar'
 import { LatestReading } from './components/LatestReading'
 import { SourceImage } from './components/SourceImage'
+import { TokenForm } from './components/TokenForm'
 import type { HealthResponse, SensorReading, SensorResponse } from './types'
 
 const HistoryChart = lazy(() =>
@@ -26,6 +35,8 @@ export default function App() {
   const [refreshing, setRefreshing] = useState(false)
   const [error, setError] = useState<string | null>(null)
   const [lastFetchedAt, setLastFetchedAt] = useState<Date | null>(null)
+  const [token, setTokenState] = useState(getToken)
+  const [needsToken, setNeedsToken] = useState(false)
 
   const refresh = useCallback(async (manual = false) => {
     if (manual) setRefreshing(true)
@@ -56,6 +67,9 @@ export default function App() {
       errors.push(settledError(healthResult)!)
     }
 
+    setNeedsToken(
+      results.some((r) => r.status === 'rejected' && r.reason instanceof ApiError && r.reason.status === 401),
+    )
     const unique = [...new Set(errors)]
     setError(unique.length ? unique.join(' ') : null)
     if (results.some((r) => r.status === 'fulfilled')) {
@@ -73,6 +87,16 @@ export default function App() {
     return () => window.clearInterval(id)
   }, [refresh])
 
+  // Refresh as soon as a reading is stored or the MQTT link changes; polling stays as a fallback.
+  // A new token reconnects, as EventSource gives up after a 401.
+  useEffect(() => subscribeEvents(['reading', 'mqtt'], () => void refresh()), [refresh, token])
+
+  const handleToken = (t: string) => {
+    setToken(t)
+    setTokenState(t)
+    void refresh(true)
+  }
+
   return (
     <>
       <a className="skip-link" href="#main">
@@ -93,11 +117,16 @@ export default function App() {
           </p>
         )}
 
+        {needsToken && <TokenForm onSubmit={handleToken} />}
+
         <main id="main">
           <section className="reading" aria-label="최신 검침">
             <LatestReading sensor={sensor} loading={loading} />
             <SourceImage
               src={sensor?.metadata?.src_image_url}
+              preview={
+                sensor?.metadata?.crop_url ?? sensor?.metadata?.thumbnail_url
+              }
               loading={loading}
             />
           </section>
@@ -1,4 +1,10 @@
-import type { HealthResponse, SensorReading, SensorResponse } from './types'
+import type {
+  HealthResponse,
+  ReadingDetail,
+  SensorReading,
+  SensorResponse,
+  ServerEvents,
+} from './types'
 
 export class ApiError extends Error {
   readonly status?: number
@@ -14,13 +20,48 @@ async function parseJson<T>(res: Response): Promise<T> {
   return (await res.json()) as T
 }
 
-export async function fetchSensor(): Promise<SensorResponse | null> {
+const TOKEN_KEY = 'mqvision.token'
+const TOKEN_COOKIE = 'mqvision_token'
+
+export function getToken(): string | null {
+  return localStorage.getItem(TOKEN_KEY)
+}
+
+// setToken keeps the API token for this browser. It is also put in a cookie
+// because EventSource and <img> cannot send an Authorization header; the
+// server reads that cookie on GET only.
+export function setToken(token: string | null) {
+  const secure = location.protocol === 'https:' ? '; Secure' : ''
+  if (token) {
+    localStorage.setItem(TOKEN_KEY, token)
+    document.cookie = `${TOKEN_COOKIE}=${encodeURIComponent(token)}; Path=/api; SameSite=Strict${secure}`
+  } else {
+    localStorage.removeItem(TOKEN_KEY)
+    document.cookie = `${TOKEN_COOKIE}=; Path=/api; Max-Age=0; SameSite=Strict${secure}`
+  }
+}
+
+// apiFetch is fetch with the stored token. A 401 becomes an ApiError so the
+// UI can ask for a token.
+async function apiFetch(url: string): Promise<Response> {
+  const token = getToken()
   let res: Response
   try {
-    res = await fetch('/api/sensor')
+    res = await fetch(url, token ? { headers: { Authorization: `Bearer ${token}` } } : undefined)
   } catch {
     throw new ApiError('서버에 연결하지 못했습니다. 네트워크를 확인해 주세요.')
   }
+  if (res.status === 401) {
+    throw new ApiError(
+      token ? '토큰이 맞지 않습니다. 다시 입력해 주세요.' : '이 서버는 API 토큰이 있어야 볼 수 있습니다.',
+      401,
+    )
+  }
+  return res
+}
+
+export async function fetchSensor(): Promise<SensorResponse | null> {
+  const res = await apiFetch('/api/sensor')
   if (res.status === 425) return null
   if (!res.ok) {
     throw new ApiError(
@@ -32,12 +73,7 @@ export async function fetchSensor(): Promise<SensorResponse | null> {
 }
 
 export async function fetchHistory(): Promise<SensorReading[]> {
-  let res: Response
-  try {
-    res = await fetch('/api/sensors')
-  } catch {
-    throw new ApiError('서버에 연결하지 못했습니다. 네트워크를 확인해 주세요.')
-  }
+  const res = await apiFetch('/api/sensors')
   if (!res.ok) {
     throw new ApiError(
       '검침 히스토리를 불러오지 못했습니다. 잠시 후 다시 시도해 주세요.',
@@ -47,13 +83,19 @@ export async function fetchHistory(): Promise<SensorReading[]> {
   return parseJson<SensorReading[]>(res)
 }
 
-export async function fetchHealth(): Promise<HealthResponse> {
-  let res: Response
-  try {
-    res = await fetch('/api/health')
-  } catch {
-    throw new ApiError('서버에 연결하지 못했습니다. 네트워크를 확인해 주세요.')
+export async function fetchReading(id: string): Promise<ReadingDetail> {
+  const res = await apiFetch(`/api/readings/${encodeURIComponent(id)}`)
+  if (!res.ok) {
+    throw new ApiError(
+      res.status === 404 ? '검침값을 찾을 수 없습니다.' : '검침값을 불러오지 못했습니다. 잠시 후 다시 시도해 주세요.',
+      res.status,
+    )
   }
+  return parseJson<ReadingDetail>(res)
+}
+
+export async function fetchHealth(): Promise<HealthResponse> {
+  const res = await apiFetch('/api/health')
   // 503 still returns a useful body
   try {
     return await parseJson<HealthResponse>(res)
@@ -64,3 +106,19 @@ export async function fetchHealth(): Promise<HealthResponse> {
     )
   }
 }
+
+// subscribeEvents listens to /api/events; EventSource reconnects by itself and
+// resumes with Last-Event-ID. The token goes in the cookie set by setToken.
+// Call the returned function to stop.
+export function subscribeEvents<T extends keyof ServerEvents>(
+  types: T[],
+  onEvent: (type: T, data: ServerEvents[T]) => void,
+): () => void {
+  const source = new EventSource('/api/events')
+  for (const type of types) {
+    source.addEventListener(type, (e) => {
+      onEvent(type, JSON.parse((e as MessageEvent<string>).data) as ServerEvents[T])
+    })
+  }
+  return () => source.close()
+}
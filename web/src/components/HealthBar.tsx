@@ -34,13 +34,13 @@ export function HealthBar({
   onRefresh,
 }: Props) {
   const appOk = health ? health.status === 'ok' : undefined
-  const mqttOk = health?.mqtt ? health.mqtt.connected : appOk
+  const mqttOk = health?.mqtt.enabled ? health.mqtt.connected : appOk
   const appDetail =
     health?.status !== 'ok'
-      ? (health?.mqtt?.last_error ?? health?.info ?? '앱 상태가 정상이 아닙니다.')
-      : health?.info
-  const mqttDetail = health?.mqtt?.last_error ?? (
-    health?.mqtt && !health.mqtt.connected
+      ? (health?.mqtt.last_error ?? '앱 상태가 정상이 아닙니다.')
+      : undefined
+  const mqttDetail = health?.mqtt.last_error ?? (
+    health?.mqtt.enabled && !health.mqtt.connected
       ? 'MQTT 브로커에 연결되어 있지 않습니다.'
       : null
   )
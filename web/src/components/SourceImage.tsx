@@ -2,15 +2,17 @@ import { useEffect, useState } from 'react'
 
 type Props = {
   src?: string | null
+  // preview is a small derivative shown in place of src; downloads stay full size.
+  preview?: string | null
   loading?: boolean
 }
 
-export function SourceImage({ src, loading }: Props) {
+export function SourceImage({ src, preview, loading }: Props) {
   const [failed, setFailed] = useState(false)
 
   useEffect(() => {
     setFailed(false)
-  }, [src])
+  }, [src, preview])
 
   const showImage = Boolean(src) && !failed
 
@@ -49,7 +51,7 @@ export function SourceImage({ src, loading }: Props) {
           <span className="skeleton skeleton--image" aria-hidden />
         ) : showImage ? (
           <img
-            src={src!}
+            src={preview || src!}
             alt="가스 미터 카메라 원본"
             loading="lazy"
             onError={() => setFailed(true)}
@@ -58,7 +60,7 @@ export function SourceImage({ src, loading }: Props) {
           <p className="image-frame__empty">
             {failed
               ? '이미지를 불러오지 못했습니다. URL이 만료되었거나 접근할 수 없습니다.'
-              : '저장된 카메라 이미지가 없습니다. Concierge에 업로드되면 여기에 나타납니다.'}
+              : '저장된 카메라 이미지가 없습니다. 이미지가 보관되면 여기에 나타납니다.'}
           </p>
         )}
       </div>
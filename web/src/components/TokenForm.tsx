@@ -0,0 +1,32 @@
+import { useState, type FormEvent } from 'react'
+
+type Props = {
+  onSubmit: (token: string) => void
+}
+
+// TokenForm asks for the API token when the server does not allow anonymous reads.
+export function TokenForm({ onSubmit }: Props) {
+  const [token, setToken] = useState('')
+
+  const handleSubmit = (e: FormEvent) => {
+    e.preventDefault()
+    const trimmed = token.trim()
+    if (trimmed) onSubmit(trimmed)
+  }
+
+  return (
+    <form className="token-form" onSubmit={handleSubmit}>
+      <label htmlFor="api-token">API 토큰</label>
+      <input
+        id="api-token"
+        type="password"
+        autoComplete="current-password"
+        value={token}
+        onChange={(e) => setToken(e.target.value)}
+      />
+      <button type="submit" className="refresh" disabled={!token.trim()}>
+        확인
+      </button>
+    </form>
+  )
+}
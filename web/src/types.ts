@@ -1,31 +1,28 @@
-export type SensorMetadata = {
-  read?: string
-  date?: string
-  read_at?: string
-  it_takes?: string
-  src_image_url?: string
-}
+// Shapes of the HTTP API. Those in components.schemas of /api/openapi.json
+// are generated into api.gen.ts (go test -run TestWebTypes . -update), which
+// fails the Go tests when it drifts from the document.
 
-export type SensorResponse = {
-  value: number
-  updated_at: string
-  metadata?: SensorMetadata
-}
+import type { Health, Sensor, SensorReading } from './api.gen'
 
-export type SensorReading = {
-  value: number
-  updated_at: string
-  metadata?: SensorMetadata
-}
+export type {
+  AuditEntry,
+  CaptureEvent,
+  Correction,
+  CostStatus,
+  LimitStatus,
+  ReadingDetail,
+  SensorMetadata,
+  SensorReading,
+} from './api.gen'
+
+export type SensorResponse = Sensor
+
+export type HealthResponse = Health
 
-export type HealthResponse = {
-  status: string
-  mqtt?: {
-    connected: boolean
-    last_error: string | null
-  }
-  sensor?: {
-    last_updated: string | null
-  }
-  info?: string
+// ServerEvents are the messages of /api/events, which the document does not describe.
+export type ServerEvents = {
+  reading: SensorReading
+  rejected: { meter_id: string; image?: string; reason: string; error: string }
+  mqtt: { connected: boolean; error?: string }
+  progress: { meter_id: string; image: string; stage: 'received' | 'reading' }
 }
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var updateWebTypes = flag.Bool("update", false, "rewrite web/src/api.gen.ts from openapi.json")
+
+// webTypesFile holds the TypeScript types of the schemas in openapi.json for the web UI.
+const webTypesFile = "web/src/api.gen.ts"
+
+// TestWebTypes fails when webTypesFile is not what openapi.json generates;
+// go test -run TestWebTypes . -update rewrites it.
+func TestWebTypes(t *testing.T) {
+	t.Parallel()
+
+	var doc specDoc
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatalf("parse openapi.json: %v", err)
+	}
+	want := tsTypes(doc.Components.Schemas)
+	if *updateWebTypes {
+		if err := os.WriteFile(webTypesFile, []byte(want), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	got, err := os.ReadFile(webTypesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("%s is out of date with openapi.json; run go test -run TestWebTypes . -update", webTypesFile)
+	}
+}
+
+// tsTypes renders every schema as an exported TypeScript type, by name.
+func tsTypes(schemas map[string]*specSchema) string {
+	var b strings.Builder
+	b.WriteString("// Code generated from openapi.json by webtypes_test.go; DO NOT EDIT.\n")
+	b.WriteString("// Regenerate with: go test -run TestWebTypes . -update\n")
+	for _, name := range sortedKeys(schemas) {
+		s := schemas[name]
+		b.WriteString("\n")
+		tsComment(&b, "", s.Description)
+		fmt.Fprintf(&b, "export type %s = %s\n", name, tsType(s, ""))
+	}
+	return b.String()
+}
+
+// tsType renders s; indent is the indentation of the line it starts on.
+func tsType(s *specSchema, indent string) string {
+	if s == nil {
+		return "unknown"
+	}
+	if s.Ref != "" {
+		return strings.TrimPrefix(s.Ref, "#/components/schemas/")
+	}
+	if len(s.OneOf) > 0 {
+		var alts []string
+		for _, alt := range s.OneOf {
+			alts = append(alts, tsType(alt, indent))
+		}
+		return strings.Join(alts, " | ")
+	}
+	if len(s.AllOf) > 0 {
+		var parts []string
+		for _, part := range s.AllOf {
+			parts = append(parts, tsType(part, indent))
+		}
+		return strings.Join(parts, " & ")
+	}
+
+	var types []string
+	switch t := s.Type.(type) {
+	case string:
+		types = []string{t}
+	case []any:
+		for _, v := range t {
+			types = append(types, fmt.Sprint(v))
+		}
+	}
+	var alts []string
+	for _, typ := range types {
+		if typ == "null" {
+			continue
+		}
+		alts = append(alts, tsBaseType(s, typ, indent))
+	}
+	if len(types) == 0 {
+		alts = append(alts, tsBaseType(s, "", indent))
+	}
+	if slices.Contains(types, "null") {
+		alts = append(alts, "null")
+	}
+	return strings.Join(alts, " | ")
+}
+
+func tsBaseType(s *specSchema, typ, indent string) string {
+	if len(s.Enum) > 0 && typ == "string" {
+		var lits []string
+		for _, v := range s.Enum {
+			if v != nil {
+				lits = append(lits, "'"+fmt.Sprint(v)+"'")
+			}
+		}
+		return strings.Join(lits, " | ")
+	}
+	switch typ {
+	case "string":
+		return "string"
+	case "integer", "number":
+		return "number"
+	case "boolean":
+		return "boolean"
+	case "array":
+		item := tsType(s.Items, indent)
+		if strings.ContainsAny(item, " |&") && !strings.HasPrefix(item, "{") {
+			item = "(" + item + ")"
+		}
+		return item + "[]"
+	case "object", "":
+		return tsObject(s, indent)
+	}
+	return "unknown"
+}
+
+func tsObject(s *specSchema, indent string) string {
+	if len(s.Properties) == 0 {
+		if s.AdditionalProperties != nil {
+			return "Record<string, " + tsType(s.AdditionalProperties, indent) + ">"
+		}
+		if s.Type == nil {
+			return "unknown"
+		}
+		return "Record<string, unknown>"
+	}
+	inner := indent + "  "
+	var b strings.Builder
+	b.WriteString("{\n")
+	for _, name := range sortedKeys(s.Properties) {
+		p := s.Properties[name]
+		tsComment(&b, inner, p.Description)
+		opt := "?"
+		if slices.Contains(s.Required, name) {
+			opt = ""
+		}
+		fmt.Fprintf(&b, "%s%s%s: %s\n", inner, name, opt, tsType(p, inner))
+	}
+	b.WriteString(indent + "}")
+	return b.String()
+}
+
+func tsComment(b *strings.Builder, indent, text string) {
+	if text != "" {
+		fmt.Fprintf(b, "%s/** %s */\n", indent, strings.ReplaceAll(text, "*/", "* /"))
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
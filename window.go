@@ -0,0 +1,98 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/suapapa/mqvision/internal/quality"
+)
+
+type burst struct {
+	best    *Frame
+	skipped []*Frame
+}
+
+// FrameWindow collects the frames of a meter for a fixed window after the first
+// one arrives and forwards only the best-scoring frame, so a camera burst costs
+// a single vision call.
+type FrameWindow struct {
+	mu      sync.Mutex
+	windows map[string]time.Duration
+	open    map[string]*burst
+	next    func(*Frame)
+}
+
+// NewFrameWindow forwards admitted frames to next. Meters without a positive
+// window pass every frame straight through.
+func NewFrameWindow(meters []MeterConfig, next func(*Frame)) *FrameWindow {
+	w := &FrameWindow{
+		windows: make(map[string]time.Duration),
+		open:    make(map[string]*burst),
+		next:    next,
+	}
+	for _, m := range meters {
+		w.windows[m.ID] = m.Window
+	}
+	return w
+}
+
+// Submit scores f unless already scored and either forwards it or holds it until its meter's window closes.
+func (w *FrameWindow) Submit(f *Frame) {
+	if f.Quality == nil {
+		r, err := quality.Analyze(f.Image)
+		if err != nil {
+			slog.WarnContext(f.context(), "Error scoring frame", "err", err)
+		} else {
+			f.Quality = &r
+		}
+	}
+
+	w.mu.Lock()
+	window := w.windows[f.MeterID]
+	if window <= 0 {
+		w.mu.Unlock()
+		w.next(f)
+		return
+	}
+
+	b, ok := w.open[f.MeterID]
+	if !ok {
+		w.open[f.MeterID] = &burst{best: f}
+		w.mu.Unlock()
+		time.AfterFunc(window, func() { w.flush(f.MeterID) })
+		return
+	}
+	if score(f) > score(b.best) {
+		b.best, f = f, b.best
+	}
+	b.skipped = append(b.skipped, f)
+	w.mu.Unlock()
+}
+
+func (w *FrameWindow) flush(meterID string) {
+	w.mu.Lock()
+	b, ok := w.open[meterID]
+	delete(w.open, meterID)
+	w.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	for _, f := range b.skipped {
+		f.settle(errFrameSkipped)
+	}
+	b.best.Skipped = len(b.skipped)
+	if b.best.Skipped > 0 {
+		slog.InfoContext(b.best.context(), "Picked the best frame of a burst", "frames", b.best.Skipped+1)
+	}
+	w.next(b.best)
+}
+
+// score ranks frames; frames that failed to decode lose to any scored frame.
+func score(f *Frame) float64 {
+	if f.Quality == nil {
+		return -1
+	}
+	return f.Quality.Score
+}
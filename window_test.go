@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFrameWindowKeepsBestFrame(t *testing.T) {
+	t.Parallel()
+
+	got := make(chan *Frame, 4)
+	w := NewFrameWindow(
+		[]MeterConfig{{ID: "gas", Window: time.Second}, {ID: "nowindow"}},
+		func(f *Frame) { got <- f },
+	)
+
+	var frames []*Frame
+	for _, name := range []string{"too_dark.jpg", "ok.jpg", "blur_image.jpg"} {
+		data, err := os.ReadFile(filepath.Join("sample", name))
+		if err != nil {
+			t.Fatalf("read sample: %v", err)
+		}
+		f := &Frame{MeterID: "gas", Image: data, ReceivedAt: time.Now(), done: make(chan error, 1)}
+		frames = append(frames, f)
+		w.Submit(f)
+	}
+
+	select {
+	case f := <-got:
+		if f.Skipped != 2 {
+			t.Errorf("Skipped = %d, want 2", f.Skipped)
+		}
+		if f.Quality == nil || !f.Quality.OK() {
+			t.Errorf("picked frame quality = %+v, want the ok sample", f.Quality)
+		}
+		for _, sf := range frames {
+			if sf == f {
+				continue
+			}
+			select {
+			case err := <-sf.done:
+				if err != errFrameSkipped {
+					t.Errorf("skipped frame settled with %v, want errFrameSkipped", err)
+				}
+			default:
+				t.Error("skipped frame was not settled")
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("window did not flush")
+	}
+
+	w.Submit(&Frame{MeterID: "nowindow", Image: []byte("not an image")})
+	select {
+	case f := <-got:
+		if f.MeterID != "nowindow" || f.Quality != nil {
+			t.Errorf("unexpected frame %+v", f)
+		}
+	default:
+		t.Fatal("frame without a window was not forwarded immediately")
+	}
+}